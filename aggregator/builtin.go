@@ -1,33 +1,73 @@
 package aggregator
 
 import (
+	"fmt"
 	"math"
-	"sort"
 	"strconv"
-	"sync"
+	"strings"
+	"time"
 )
 
 type AggregateType string
 
 const (
-	Sum         AggregateType = "sum"
-	Count       AggregateType = "count"
-	Avg         AggregateType = "avg"
-	Max         AggregateType = "max"
-	Min         AggregateType = "min"
-	StdDev      AggregateType = "stddev"
-	Median      AggregateType = "median"
-	Percentile  AggregateType = "percentile"
+	Sum        AggregateType = "sum"
+	Count      AggregateType = "count"
+	Avg        AggregateType = "avg"
+	Max        AggregateType = "max"
+	Min        AggregateType = "min"
+	StdDev     AggregateType = "stddev"
+	Median     AggregateType = "median"
+	Percentile AggregateType = "percentile"
+	// PercentileCont 连续分位数，在相邻数据之间线性插值
+	PercentileCont AggregateType = "percentile_cont"
+	// PercentileDisc 离散分位数，结果为输入中的某条数据
+	PercentileDisc AggregateType = "percentile_disc"
+	// CountDistinct 不同值的个数，对应 count(DISTINCT field)
+	CountDistinct AggregateType = "count_distinct"
+	// ApproxCountDistinct 使用 HyperLogLog 估算的不同值的个数
+	ApproxCountDistinct AggregateType = "approx_count_distinct"
+	// FirstValue 按时间最早的一条数据的值
+	FirstValue AggregateType = "first_value"
+	// LastValue 按时间最晚的一条数据的值
+	LastValue AggregateType = "last_value"
+	// CollectList 按数据到达顺序收集所有的值
+	CollectList AggregateType = "collect_list"
+	// CollectSet 按首次出现的顺序收集不同的值
+	CollectSet AggregateType = "collect_set"
+	// Mode 出现次数最多的值
+	Mode AggregateType = "mode"
+	// TopK 出现次数最多的 k 个值，如 topk(deviceId, 5)
+	TopK AggregateType = "topk"
+	// TopKBy 权重之和最大的 k 个值，如 topk_by(deviceId, bytes, 5)
+	TopKBy      AggregateType = "topk_by"
 	WindowStart AggregateType = "window_start"
 	WindowEnd   AggregateType = "window_end"
 )
 
+// AggregatorFunction 聚合器，New 为每个分组创建新的实例，Add 加入一条数据的值，Result 返回聚合结果。
+// 结果可以是任意类型，如数值、字符串、time.Time 或切片
 type AggregatorFunction interface {
 	New() AggregatorFunction
 	Add(value interface{})
 	Result() interface{}
 }
 
+// MergeableAggregator 可以合并部分聚合状态的聚合器。Merge 把 other 的状态并入当前实例，
+// 结果与先加入当前实例的数据、再加入 other 的数据相同，other 为同一聚合器 New 创建的实例，合并后不再使用。
+// 滑动窗口的所有聚合器都可以合并时，窗口按 gcd(size, slide) 大小的分片预聚合，每次滑动只合并分片的状态
+type MergeableAggregator interface {
+	AggregatorFunction
+	Merge(other AggregatorFunction)
+}
+
+// RetractableAggregator 可以撤销部分聚合状态的聚合器，Retract 从当前实例中去掉此前合并的 other 的数据。
+// 滑动窗口的所有聚合器都可以撤销时，每次滑动只撤销移出窗口的分片、合并移入窗口的分片
+type RetractableAggregator interface {
+	MergeableAggregator
+	Retract(other AggregatorFunction)
+}
+
 type SumAggregator struct {
 	value float64
 }
@@ -45,6 +85,14 @@ func (s *SumAggregator) Result() interface{} {
 	return s.value
 }
 
+func (s *SumAggregator) Merge(other AggregatorFunction) {
+	s.value += other.(*SumAggregator).value
+}
+
+func (s *SumAggregator) Retract(other AggregatorFunction) {
+	s.value -= other.(*SumAggregator).value
+}
+
 type CountAggregator struct {
 	count int
 }
@@ -61,6 +109,14 @@ func (c *CountAggregator) Result() interface{} {
 	return float64(c.count)
 }
 
+func (c *CountAggregator) Merge(other AggregatorFunction) {
+	c.count += other.(*CountAggregator).count
+}
+
+func (c *CountAggregator) Retract(other AggregatorFunction) {
+	c.count -= other.(*CountAggregator).count
+}
+
 type AvgAggregator struct {
 	sum   float64
 	count int
@@ -83,76 +139,76 @@ func (a *AvgAggregator) Result() interface{} {
 	return a.sum / float64(a.count)
 }
 
-var (
-	aggregatorRegistry = make(map[string]func() AggregatorFunction)
-	registryMutex      sync.RWMutex
-)
-
-// Register 添加自定义聚合器到全局注册表
-func Register(name string, constructor func() AggregatorFunction) {
-	registryMutex.Lock()
-	defer registryMutex.Unlock()
-	aggregatorRegistry[name] = constructor
+func (a *AvgAggregator) Merge(other AggregatorFunction) {
+	o := other.(*AvgAggregator)
+	a.sum += o.sum
+	a.count += o.count
 }
 
-func CreateBuiltinAggregator(aggType AggregateType) AggregatorFunction {
-	registryMutex.RLock()
-	constructor, exists := aggregatorRegistry[string(aggType)]
-	registryMutex.RUnlock()
-	if exists {
-		return constructor()
-	}
-
-	switch aggType {
-	case Sum:
-		return &SumAggregator{}
-	case Count:
-		return &CountAggregator{}
-	case Avg:
-		return &AvgAggregator{}
-	case Min:
-		return &MinAggregator{}
-	case Max:
-		return &MaxAggregator{}
-	case StdDev:
-		return &StdDevAggregator{}
-	//case "var":
-	//	return &VarAggregator{}
-	case Median:
-		return &MedianAggregator{}
-	case Percentile:
-		return &PercentileAggregator{p: 0.95}
-	case WindowStart:
-		return &WindowStartAggregator{}
-	case WindowEnd:
-		return &WindowEndAggregator{}
-	default:
-		panic("unsupported aggregator type: " + aggType)
-	}
+func (a *AvgAggregator) Retract(other AggregatorFunction) {
+	o := other.(*AvgAggregator)
+	a.sum -= o.sum
+	a.count -= o.count
 }
 
+// StdDevAggregator 样本标准差，按 Welford 算法只保存数据条数、平均值和离差平方和，内存占用不随数据量增长。
+// 合并和撤销部分状态时按并行方差公式计算，数据少于两条时结果为 0
 type StdDevAggregator struct {
-	values []float64
+	count float64
+	mean  float64
+	// m2 各数据与平均值之差的平方和
+	m2 float64
 }
 
 func (s *StdDevAggregator) New() AggregatorFunction {
 	return &StdDevAggregator{}
 }
 
-func calculateVariance(values []float64) float64 {
-	if len(values) < 2 {
+func (s *StdDevAggregator) Add(v interface{}) {
+	var vv float64 = ConvertToFloat64(v, 0)
+	s.count++
+	delta := vv - s.mean
+	s.mean += delta / s.count
+	s.m2 += delta * (vv - s.mean)
+}
+
+func (s *StdDevAggregator) Result() interface{} {
+	if s.count < 2 {
 		return 0
 	}
-	avg := calculateAverage(values)
-	var sum float64
-	for _, v := range values {
-		sum += (v - avg) * (v - avg)
+	return math.Sqrt(math.Max(s.m2, 0) / (s.count - 1))
+}
+
+func (s *StdDevAggregator) Merge(other AggregatorFunction) {
+	o := other.(*StdDevAggregator)
+	if o.count == 0 {
+		return
+	}
+	count := s.count + o.count
+	delta := o.mean - s.mean
+	s.m2 += o.m2 + delta*delta*s.count*o.count/count
+	s.mean += delta * o.count / count
+	s.count = count
+}
+
+// Retract 按并行方差公式的逆运算去掉 other 的数据，撤销后的结果可能与重新计算存在舍入误差
+func (s *StdDevAggregator) Retract(other AggregatorFunction) {
+	o := other.(*StdDevAggregator)
+	count := s.count - o.count
+	if count <= 0 {
+		*s = StdDevAggregator{}
+		return
 	}
-	return sum / float64(len(values)-1)
+	mean := (s.count*s.mean - o.count*o.mean) / count
+	delta := o.mean - mean
+	s.m2 -= o.m2 + delta*delta*count*o.count/s.count
+	s.mean = mean
+	s.count = count
 }
 
+// MedianAggregator 中位数，数据条数为偶数时取中间两个数的平均值，没有数据时结果为 nil
 type MedianAggregator struct {
-	values []float64
+	digest tdigest
 }
 
 func (m *MedianAggregator) New() AggregatorFunction {
@@ -160,45 +216,69 @@ func (m *MedianAggregator) New() AggregatorFunction {
 }
 
 func (m *MedianAggregator) Add(val interface{}) {
-	var vv float64 = ConvertToFloat64(val, 0)
-	m.values = append(m.values, vv)
+	m.digest.add(ConvertToFloat64(val, 0))
 }
 
 func (m *MedianAggregator) Result() interface{} {
-	sort.Float64s(m.values)
-	return m.values[len(m.values)/2]
+	if m.digest.count == 0 {
+		return nil
+	}
+	return m.digest.quantile(0.5)
+}
+
+func (m *MedianAggregator) Merge(other AggregatorFunction) {
+	m.digest.merge(&other.(*MedianAggregator).digest)
 }
 
+// PercentileAggregator 分位数，p 为 0 到 1 之间的比例。
+// disc 为 false 时在相邻数据之间线性插值（percentile_cont），为 true 时返回累计比例不小于 p 的第一条数据（percentile_disc）。
+// 数据较少时结果是精确值，数据较多时由 t-digest 估算，内存占用不随数据量增长，没有数据时结果为 nil
 type PercentileAggregator struct {
-	values []float64
 	p      float64
+	disc   bool
+	digest tdigest
+}
+
+// DefaultPercentile percentile 未指定比例时使用的比例
+const DefaultPercentile = 0.95
+
+// NewPercentileAggregator 创建分位数聚合器，p 必须在 0 到 1 之间
+func NewPercentileAggregator(p float64, disc bool) (*PercentileAggregator, error) {
+	if p < 0 || p > 1 || math.IsNaN(p) {
+		return nil, fmt.Errorf("percentile must be between 0 and 1 but got %v", p)
+	}
+	return &PercentileAggregator{p: p, disc: disc}, nil
 }
 
 func (p *PercentileAggregator) New() AggregatorFunction {
-	return &PercentileAggregator{}
+	return &PercentileAggregator{p: p.p, disc: p.disc}
 }
 
 func (p *PercentileAggregator) Add(v interface{}) {
-	vv := ConvertToFloat64(v, 0)
-	p.values = append(p.values, vv)
+	p.digest.add(ConvertToFloat64(v, 0))
+}
+
+func (p *PercentileAggregator) Merge(other AggregatorFunction) {
+	p.digest.merge(&other.(*PercentileAggregator).digest)
 }
 
+// MinAggregator 最小值，支持数值、字符串和 time.Time，数值的结果为 float64，没有数据时结果为 nil
 type MinAggregator struct {
-	value float64
-	first bool
+	value interface{}
 }
 
-func (s *MinAggregator) New() AggregatorFunction {
-	return &MinAggregator{
-		first: true,
-	}
+func (m *MinAggregator) New() AggregatorFunction {
+	return &MinAggregator{}
+}
+
+func (m *MinAggregator) CheckValue(v interface{}) error {
+	return checkComparable(v, m.value)
 }
 
 func (m *MinAggregator) Add(v interface{}) {
-	var vv float64 = ConvertToFloat64(v, math.MaxFloat64)
-	if m.first || vv < m.value {
-		m.value = vv
-		m.first = false
+	v = normalizeNumber(v)
+	if c, err := compareValues(v, m.value); m.value == nil || err == nil && c < 0 {
+		m.value = v
 	}
 }
 
@@ -206,20 +286,29 @@ func (m *MinAggregator) Result() interface{} {
 	return m.value
 }
 
+func (m *MinAggregator) Merge(other AggregatorFunction) {
+	if v := other.(*MinAggregator).value; v != nil {
+		m.Add(v)
+	}
+}
+
+// MaxAggregator 最大值，支持数值、字符串和 time.Time，数值的结果为 float64，没有数据时结果为 nil
 type MaxAggregator struct {
-	value float64
-	first bool
+	value interface{}
 }
 
 func (m *MaxAggregator) New() AggregatorFunction {
 	return &MaxAggregator{}
 }
 
+func (m *MaxAggregator) CheckValue(v interface{}) error {
+	return checkComparable(v, m.value)
+}
+
 func (m *MaxAggregator) Add(v interface{}) {
-	var vv float64 = ConvertToFloat64(v, 0)
-	if m.first || vv > m.value {
-		m.value = vv
-		m.first = false
+	v = normalizeNumber(v)
+	if c, err := compareValues(v, m.value); m.value == nil || err == nil && c > 0 {
+		m.value = v
 	}
 }
 
@@ -227,68 +316,118 @@ func (m *MaxAggregator) Result() interface{} {
 	return m.value
 }
 
-func (s *StdDevAggregator) Add(v interface{}) {
-	var vv float64 = ConvertToFloat64(v, 0)
-	s.values = append(s.values, vv)
+func (m *MaxAggregator) Merge(other AggregatorFunction) {
+	if v := other.(*MaxAggregator).value; v != nil {
+		m.Add(v)
+	}
 }
 
-func (s *StdDevAggregator) Result() interface{} {
-	if len(s.values) < 2 {
-		return 0
+// normalizeNumber 把数值转换为 float64，其他类型的值原样返回
+func normalizeNumber(v interface{}) interface{} {
+	switch v.(type) {
+	case float64, float32, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return ConvertToFloat64(v, 0)
 	}
-	avg := calculateAverage(s.values)
-	var sum float64
-	for _, v := range s.values {
-		sum += (v - avg) * (v - avg)
+	return v
+}
+
+// checkComparable 检查 v 能否与当前结果比较，current 为 nil 时只检查 v 的类型
+func checkComparable(v, current interface{}) error {
+	v = normalizeNumber(v)
+	if current == nil {
+		current = v
 	}
-	return math.Sqrt(sum / float64(len(s.values)-1))
+	_, err := compareValues(v, current)
+	return err
 }
 
-func (p *PercentileAggregator) Result() interface{} {
-	if len(p.values) == 0 {
-		return 0
+// compareValues 比较两个同类的值，支持 float64、字符串和 time.Time，a 小于、等于、大于 b 时分别返回 -1、0、1
+func compareValues(a, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1, nil
+			case x.After(y):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	default:
+		return 0, fmt.Errorf("unsupported type %T", a)
 	}
-	sort.Float64s(p.values)
-	index := p.p * float64(len(p.values)-1)
-	return p.values[int(index)]
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
 }
 
-func calculateAverage(values []float64) float64 {
-	var sum float64
-	for _, v := range values {
-		sum += v
+func (p *PercentileAggregator) Result() interface{} {
+	if p.digest.count == 0 {
+		return nil
 	}
-	return sum / float64(len(values))
+	if p.disc {
+		return p.digest.quantileDisc(p.p)
+	}
+	return p.digest.quantile(p.p)
 }
 
+// ConvertToFloat64 将数值或数字字符串转换为 float64，无法转换时返回 defaultVal
 func ConvertToFloat64(v interface{}, defaultVal float64) float64 {
-	var vv float64 = defaultVal
+	vv, err := ConvertToFloat64E(v)
+	if err != nil {
+		return defaultVal
+	}
+	return vv
+}
+
+// ConvertToFloat64E 将数值或数字字符串转换为 float64，无法转换时返回错误
+func ConvertToFloat64E(v interface{}) (float64, error) {
 	switch val := v.(type) {
 	case float64:
-		vv = val
+		return val, nil
 	case float32:
-		vv = float64(val)
+		return float64(val), nil
 	case int:
-		vv = float64(val)
+		return float64(val), nil
+	case int8:
+		return float64(val), nil
+	case int16:
+		return float64(val), nil
 	case int32:
-		vv = float64(val)
+		return float64(val), nil
 	case int64:
-		vv = float64(val)
+		return float64(val), nil
 	case uint:
-		vv = float64(val)
+		return float64(val), nil
+	case uint8:
+		return float64(val), nil
+	case uint16:
+		return float64(val), nil
 	case uint32:
-		vv = float64(val)
+		return float64(val), nil
 	case uint64:
-		vv = float64(val)
+		return float64(val), nil
 	case string:
 		// 处理字符串类型的转换
-		if floatValue, err := strconv.ParseFloat(val, 64); err == nil {
-			vv = floatValue
-		} else {
-			panic("unsupported type for sum aggregator")
+		floatValue, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return 0, fmt.Errorf("unable to convert %q to float64", val)
 		}
+		return floatValue, nil
 	default:
-		panic("unsupported type for sum aggregator")
+		return 0, fmt.Errorf("unable to convert %v (%T) to float64", v, v)
 	}
-	return vv
 }
@@ -5,6 +5,10 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/functions"
 )
 
 type Aggregator interface {
@@ -14,32 +18,277 @@ type Aggregator interface {
 	Reset()
 }
 
+// AggregationField 聚合字段的定义
+type AggregationField struct {
+	// InputField 参与聚合的输入字段，从上下文取值的聚合器（如 window_start）可以为空
+	InputField string
+	// Expr 参与聚合的输入表达式，如 avg(temperature/10) 中的 temperature/10，不为空时取代 InputField
+	Expr expr.Expr
+	// Args 多参数聚合函数的输入表达式，如 weighted_avg(value, weight) 中的 value 和 weight，不为空时取代 InputField 和 Expr
+	Args []expr.Expr
+	// Params 聚合函数的常量参数，如 percentile(latency, 0.99) 中的 0.99
+	Params []interface{}
+	// AggregateType 聚合类型
+	AggregateType AggregateType
+	// OutputAlias 聚合结果在分组结果中的名称，同一个聚合器中不能重复
+	OutputAlias string
+}
+
+// GroupField 分组字段的定义
+type GroupField struct {
+	// Name 分组字段在分组结果中的名称，为字段名、嵌套字段的路径（如 payload.meta.site）或分组表达式的规范文本
+	Name string
+	// Expr 分组表达式，如 floor(temperature / 10)，为空时按 Name 从数据中取值
+	Expr expr.Expr
+}
+
+// Value 从数据中计算分组字段的值，字段缺失时为 nil
+func (g GroupField) Value(data interface{}) (interface{}, error) {
+	if g.Expr != nil {
+		return expr.Eval(g.Expr, data)
+	}
+	v, _ := expr.Lookup(data, g.Name)
+	return v, nil
+}
+
+// GroupFieldsOf 根据字段名创建分组字段
+func GroupFieldsOf(names []string) []GroupField {
+	groups := make([]GroupField, len(names))
+	for i, name := range names {
+		groups[i] = GroupField{Name: name}
+	}
+	return groups
+}
+
+// FieldError 数据中的字段无法参与分组或聚合时返回的错误
+type FieldError struct {
+	// Field 出错的字段或聚合表达式
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
 type GroupAggregator struct {
-	fieldMap    map[string]AggregateType
-	groupFields []string
+	fields      []AggregationField
+	groupFields []GroupField
 	aggregators map[string]AggregatorFunction
-	groups      map[string]map[string]AggregatorFunction
-	mu          sync.RWMutex
-	context     map[string]interface{}
-	fieldAlias  map[string]string
+	// argTypes 每个聚合的输入参数类型，数值参数在聚合前转换为 float64
+	argTypes map[string][]functions.ArgType
+	groups   map[string]map[string]AggregatorFunction
+	// groupValues 每个分组的分组字段原始值，按 groupFields 的顺序排列
+	groupValues map[string][]interface{}
+	// rowFields 既不分组也不聚合的字段，rowValues 保存每个分组中这些字段最后出现的值
+	rowFields []string
+	rowValues map[string]map[string]interface{}
+	// rows 每个分组加入的数据条数，撤销分片后条数为 0 的分组被删除
+	rows    map[string]int
+	mu      sync.RWMutex
+	context map[string]interface{}
 }
 
-func NewGroupAggregator(groupFields []string, fieldMap map[string]AggregateType, fieldAlias map[string]string) *GroupAggregator {
+// NewGroupAggregator 根据字段和聚合类型的映射创建分组聚合器，每个字段只能有一种聚合。
+// fieldAlias 指定聚合结果的名称，没有指定时结果名称为 字段名_聚合类型
+func NewGroupAggregator(groupFields []string, fieldMap map[string]AggregateType, fieldAlias map[string]string) (*GroupAggregator, error) {
+	fields := make([]AggregationField, 0, len(fieldMap))
+	for field, aggType := range fieldMap {
+		alias, ok := fieldAlias[field]
+		if !ok {
+			agg, err := CreateBuiltinAggregator(aggType)
+			if err != nil {
+				return nil, err
+			}
+			alias = field + "_" + string(aggType)
+			if _, isContext := agg.(ContextAggregator); isContext {
+				alias = field
+			}
+		}
+		fields = append(fields, AggregationField{
+			InputField:    field,
+			AggregateType: aggType,
+			OutputAlias:   alias,
+		})
+	}
+	return NewGroupAggregatorWithFields(groupFields, fields)
+}
+
+// NewGroupAggregatorWithFields 根据聚合字段的定义创建分组聚合器，同一个字段可以有多种聚合，聚合类型不支持时返回错误
+func NewGroupAggregatorWithFields(groupFields []string, fields []AggregationField) (*GroupAggregator, error) {
+	return NewGroupAggregatorWithGroups(GroupFieldsOf(groupFields), fields)
+}
+
+// NewGroupAggregatorWithGroups 根据分组字段和聚合字段的定义创建分组聚合器，分组字段可以是嵌套字段的路径或表达式
+func NewGroupAggregatorWithGroups(groupFields []GroupField, fields []AggregationField) (*GroupAggregator, error) {
 	aggregators := make(map[string]AggregatorFunction)
 
-	for field, aggType := range fieldMap {
-		aggregators[field] = CreateBuiltinAggregator(aggType)
+	argTypes := make(map[string][]functions.ArgType)
+	for _, field := range fields {
+		agg, err := CreateAggregator(field.AggregateType, field.Params)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := agg.(MultiArgAggregator); !ok && len(field.Args) > 1 {
+			return nil, fmt.Errorf("aggregator %s does not accept multiple arguments", field.AggregateType)
+		}
+		aggregators[field.OutputAlias] = agg
+		if sig, ok := SignatureOf(string(field.AggregateType)); ok {
+			argTypes[field.OutputAlias] = sig.Args
+		}
 	}
 
 	return &GroupAggregator{
-		fieldMap:    fieldMap,
+		fields:      fields,
 		groupFields: groupFields,
 		aggregators: aggregators,
+		argTypes:    argTypes,
 		groups:      make(map[string]map[string]AggregatorFunction),
-		fieldAlias:  fieldAlias,
+		groupValues: make(map[string][]interface{}),
+		rowValues:   make(map[string]map[string]interface{}),
+		rows:        make(map[string]int),
+	}, nil
+}
+
+// New 创建分组字段、聚合字段和非聚合字段都相同的空分组聚合器，不复制上下文
+func (ga *GroupAggregator) New() *GroupAggregator {
+	ga.mu.RLock()
+	defer ga.mu.RUnlock()
+	return &GroupAggregator{
+		fields:      ga.fields,
+		groupFields: ga.groupFields,
+		aggregators: ga.aggregators,
+		argTypes:    ga.argTypes,
+		groups:      make(map[string]map[string]AggregatorFunction),
+		groupValues: make(map[string][]interface{}),
+		rowFields:   ga.rowFields,
+		rowValues:   make(map[string]map[string]interface{}),
+		rows:        make(map[string]int),
+	}
+}
+
+// Mergeable 判断分组聚合器能否通过 Merge 合并，除从上下文取值的聚合器外，所有聚合器都要实现 MergeableAggregator
+func (ga *GroupAggregator) Mergeable() bool {
+	for _, agg := range ga.aggregators {
+		if _, ok := agg.(ContextAggregator); ok {
+			continue
+		}
+		if _, ok := agg.(MergeableAggregator); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Retractable 判断分组聚合器能否通过 Retract 撤销，除从上下文取值的聚合器外，所有聚合器都要实现 RetractableAggregator，
+// 且没有非聚合字段，因为非聚合字段取最后一条数据的值，无法撤销
+func (ga *GroupAggregator) Retractable() bool {
+	if len(ga.rowFields) > 0 {
+		return false
+	}
+	for _, agg := range ga.aggregators {
+		if _, ok := agg.(ContextAggregator); ok {
+			continue
+		}
+		if _, ok := agg.(RetractableAggregator); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge 按分组把 other 的聚合状态并入当前分组聚合器，结果与先加入当前聚合器的数据、再加入 other 的数据相同。
+// other 由同一个分组聚合器的 New 创建，从上下文取值的聚合器不合并，由 ApplyContext 重新计算
+func (ga *GroupAggregator) Merge(other *GroupAggregator) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	for key, aggregators := range other.groups {
+		if _, exists := ga.groups[key]; !exists {
+			group := make(map[string]AggregatorFunction, len(ga.aggregators))
+			for alias, agg := range ga.aggregators {
+				group[alias] = agg.New()
+			}
+			ga.groups[key] = group
+			ga.groupValues[key] = other.groupValues[key]
+		}
+		ga.rows[key] += other.rows[key]
+		if values, ok := other.rowValues[key]; ok {
+			row, exists := ga.rowValues[key]
+			if !exists {
+				row = make(map[string]interface{}, len(values))
+				ga.rowValues[key] = row
+			}
+			for field, val := range values {
+				row[field] = val
+			}
+		}
+		for alias, agg := range aggregators {
+			if mergeable, ok := ga.groups[key][alias].(MergeableAggregator); ok {
+				mergeable.Merge(agg)
+			}
+		}
+	}
+}
+
+// Retract 按分组从当前分组聚合器中撤销此前合并的 other 的聚合状态，数据条数为 0 的分组被删除
+func (ga *GroupAggregator) Retract(other *GroupAggregator) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	for key, aggregators := range other.groups {
+		if _, exists := ga.groups[key]; !exists {
+			continue
+		}
+		ga.rows[key] -= other.rows[key]
+		if ga.rows[key] <= 0 {
+			delete(ga.groups, key)
+			delete(ga.groupValues, key)
+			delete(ga.rowValues, key)
+			delete(ga.rows, key)
+			continue
+		}
+		for alias, agg := range aggregators {
+			if retractable, ok := ga.groups[key][alias].(RetractableAggregator); ok {
+				retractable.Retract(agg)
+			}
+		}
 	}
 }
 
+// ApplyContext 按上下文中的值重新计算每个分组中从上下文取值的聚合器，如合并分片后的 window_start 和 window_end
+func (ga *GroupAggregator) ApplyContext() {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	for _, group := range ga.groups {
+		for alias, agg := range ga.aggregators {
+			ctxAgg, ok := agg.(ContextAggregator)
+			if !ok {
+				continue
+			}
+			fresh := agg.New()
+			if val, exists := ga.context[ctxAgg.GetContextKey()]; exists {
+				fresh.Add(val)
+			}
+			group[alias] = fresh
+		}
+	}
+}
+
+// SetRowFields 设置既不分组也不聚合的字段，如 SELECT deviceId, avg(temperature) ... GROUP BY TumblingWindow('1m') 中的 deviceId。
+// 分组结果中这些字段取分组内最后一条包含该字段的数据的值，字段可以是嵌套字段的路径
+func (ga *GroupAggregator) SetRowFields(fields []string) {
+	ga.mu.Lock()
+	defer ga.mu.Unlock()
+	ga.rowFields = fields
+}
+
 func (ga *GroupAggregator) Put(key string, val interface{}) error {
 	ga.mu.Lock()         // 获取写锁
 	defer ga.mu.Unlock() // 确保函数返回时释放锁
@@ -53,47 +302,17 @@ func (ga *GroupAggregator) Put(key string, val interface{}) error {
 func (ga *GroupAggregator) Add(data interface{}) error {
 	ga.mu.Lock()         // 获取写锁
 	defer ga.mu.Unlock() // 确保函数返回时释放锁
-	var v reflect.Value
 
-	switch data.(type) {
-	case map[string]interface{}:
-		dataMap := data.(map[string]interface{})
-		v = reflect.ValueOf(dataMap)
-	default:
-		v = reflect.ValueOf(data)
-		if v.Kind() == reflect.Ptr {
-			v = v.Elem()
-		}
-	}
-
-	key := ""
-	for _, field := range ga.groupFields {
-		var f reflect.Value
-
-		if v.Kind() == reflect.Map {
-			// 处理 map 类型
-			keyVal := reflect.ValueOf(field)
-			f = v.MapIndex(keyVal)
-		} else {
-			// 处理结构体类型
-			f = v.FieldByName(field)
-		}
-
-		if !f.IsValid() {
-			return fmt.Errorf("field %s not found", field)
-		}
-
-		keyVal := f.Interface()
-		if keyVal == nil {
-			return fmt.Errorf("field %s has nil value", field)
-		}
-
-		if str, ok := keyVal.(string); ok {
-			key += fmt.Sprintf("%s|", str)
-		} else {
-			key += fmt.Sprintf("%v|", keyVal)
+	// 分组字段缺失或为 nil 的数据归入该字段为 nil 的分组
+	values := make([]interface{}, len(ga.groupFields))
+	for i, field := range ga.groupFields {
+		val, err := field.Value(data)
+		if err != nil {
+			return &FieldError{Field: field.Name, Err: err}
 		}
+		values[i] = val
 	}
+	key := GroupKey(values)
 
 	/**
 	    sql中没有'Group By'时，key为空串
@@ -104,70 +323,167 @@ func (ga *GroupAggregator) Add(data interface{}) error {
 		// key = key[:len(key)-1]
 	*/
 
-	if _, exists := ga.groups[key]; !exists {
-		ga.groups[key] = make(map[string]AggregatorFunction)
+	// 先计算并检查所有聚合的输入，任一输入出错时整条数据都不参与聚合，分组中的聚合结果不会只更新一部分
+	group, exists := ga.groups[key]
+	if !exists {
+		group = make(map[string]AggregatorFunction, len(ga.aggregators))
 	}
 	// field级别的聚合可以分批创建
 	for field, agg := range ga.aggregators {
-		if _, exists := ga.groups[key][field]; !exists {
+		if _, ok := group[field]; !ok {
 			// 创建新的聚合器实例
-			ga.groups[key][field] = agg.New()
-			//fmt.Printf("groups by %s : %v \n", key, ga.groups[key])
-		}
-	}
-
-	for field := range ga.fieldMap {
-		var f reflect.Value
-
-		if v.Kind() == reflect.Map {
-			// 处理 map 类型
-			keyVal := reflect.ValueOf(field)
-			f = v.MapIndex(keyVal)
-		} else {
-			// 处理结构体类型
-			f = v.FieldByName(field)
-		}
-
-		if !f.IsValid() {
-			//return fmt.Errorf("field %s not found", field)
-			//fmt.Printf("field %s not found in %v \n ", field, data)
-
-			// 尝试从context中获取
-			if ga.context != nil {
-				if groupAgg, exists := ga.groups[key][field]; exists {
-					if _, ok := groupAgg.(ContextAggregator); ok {
-						key := groupAgg.(ContextAggregator).GetContextKey()
-						if val, exists := ga.context[key]; exists {
-							groupAgg.Add(val)
-							//fmt.Printf("add agg group by %s:%s , %v  \n", key, field, value)
-						}
-					}
-				}
+			group[field] = agg.New()
+		}
+	}
+	adds := make([]func(), 0, len(ga.fields))
+	for _, field := range ga.fields {
+		add, err := ga.prepare(group[field.OutputAlias], field, data)
+		if err != nil {
+			return err
+		}
+		if add != nil {
+			adds = append(adds, add)
+		}
+	}
+
+	if !exists {
+		ga.groups[key] = group
+		ga.groupValues[key] = values
+	}
+	ga.rows[key]++
+	if len(ga.rowFields) > 0 {
+		row, exists := ga.rowValues[key]
+		if !exists {
+			row = make(map[string]interface{}, len(ga.rowFields))
+			ga.rowValues[key] = row
+		}
+		for _, field := range ga.rowFields {
+			if val, ok := expr.Lookup(data, field); ok {
+				row[field] = val
 			}
-			continue
 		}
+	}
+	for _, add := range adds {
+		add()
+	}
+	return nil
+}
 
-		fieldVal := f.Interface()
-		var value float64
-		switch vType := fieldVal.(type) {
-		case float64:
-			value = vType
-		case int, int32, int64:
-			value = float64(vType.(int))
-		case float32:
-			value = float64(vType)
-		default:
-			return fmt.Errorf("unsupported type for field %s: %T", field, fieldVal)
+// prepare 计算并检查数据对聚合 field 的输入，返回把输入加入聚合器 groupAgg 的函数，数据不参与该聚合时返回 nil
+func (ga *GroupAggregator) prepare(groupAgg AggregatorFunction, field AggregationField, data interface{}) (func(), error) {
+	if len(field.Args) > 0 {
+		return ga.prepareArgs(groupAgg, field, data)
+	}
+	if field.Expr != nil {
+		return ga.prepareExpr(groupAgg, field, data)
+	}
+	var fieldVal interface{}
+	exists := false
+	if field.InputField != "" {
+		fieldVal, exists = expr.Lookup(data, field.InputField)
+	}
+	if exists {
+		return ga.prepareValue(groupAgg, field, field.InputField, fieldVal)
+	}
+	// 尝试从context中获取
+	if ctxAgg, ok := groupAgg.(ContextAggregator); ok {
+		if val, exists := ga.context[ctxAgg.GetContextKey()]; exists {
+			return func() { groupAgg.Add(val) }, nil
 		}
-		if groupAgg, exists := ga.groups[key][field]; exists {
-			groupAgg.Add(value)
-			//fmt.Printf("add agg group by %s:%s , %v  \n", key, field, value)
-		} else {
+	} else if field.InputField == "" {
+		// 没有参数的聚合，如 count(*)，每条数据调用一次 Add(nil)
+		return func() { groupAgg.Add(nil) }, nil
+	}
+	return nil, nil
+}
+
+// prepareExpr 对数据计算聚合的输入表达式，结果为 nil 时不参与聚合
+func (ga *GroupAggregator) prepareExpr(groupAgg AggregatorFunction, field AggregationField, data interface{}) (func(), error) {
+	val, err := expr.Eval(field.Expr, data)
+	if err != nil {
+		return nil, &FieldError{Field: field.OutputAlias, Err: err}
+	}
+	return ga.prepareValue(groupAgg, field, field.OutputAlias, val)
+}
 
+// prepareValue 检查聚合的输入值，值为 nil 时不参与聚合，数值参数转换为 float64，不是数值时返回错误。
+// 按时间聚合的聚合器同时传入上下文中的数据时间
+func (ga *GroupAggregator) prepareValue(groupAgg AggregatorFunction, field AggregationField, name string, val interface{}) (func(), error) {
+	if val == nil {
+		return nil, nil
+	}
+	val, err := ga.convertArg(field, 0, val)
+	if err != nil {
+		return nil, &FieldError{Field: name, Err: err}
+	}
+	if checker, ok := groupAgg.(ValueChecker); ok {
+		if err := checker.CheckValue(val); err != nil {
+			return nil, &FieldError{Field: name, Err: err}
 		}
 	}
+	if timed, ok := groupAgg.(TimedAggregator); ok {
+		ts, _ := ga.context[EventTimeKey].(time.Time)
+		return func() { timed.AddAt(val, ts) }, nil
+	}
+	return func() { groupAgg.Add(val) }, nil
+}
 
-	return nil
+// prepareArgs 对数据计算多参数聚合的各个参数，任一参数为 nil 时不参与聚合
+func (ga *GroupAggregator) prepareArgs(groupAgg AggregatorFunction, field AggregationField, data interface{}) (func(), error) {
+	args := make([]interface{}, len(field.Args))
+	for i, arg := range field.Args {
+		val, err := expr.Eval(arg, data)
+		if err != nil {
+			return nil, &FieldError{Field: field.OutputAlias, Err: err}
+		}
+		if val == nil {
+			return nil, nil
+		}
+		if args[i], err = ga.convertArg(field, i, val); err != nil {
+			return nil, &FieldError{Field: field.OutputAlias, Err: fmt.Errorf("argument %d: %w", i+1, err)}
+		}
+	}
+	if multi, ok := groupAgg.(MultiArgAggregator); ok {
+		return func() { multi.AddArgs(args) }, nil
+	}
+	return func() { groupAgg.Add(args[0]) }, nil
+}
+
+// convertArg 按聚合函数签名中第 i 个参数的类型转换输入值，数值参数转换为 float64，其他类型按原值返回。
+// 数值参数可以是任意整数或浮点数类型，包括以数值为底层类型的自定义类型
+func (ga *GroupAggregator) convertArg(field AggregationField, i int, val interface{}) (interface{}, error) {
+	types := ga.argTypes[field.OutputAlias]
+	if i < len(types) && types[i] != functions.TypeNumber {
+		return val, nil
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", val)
+}
+
+// GroupKey 根据分组字段的值生成分组键。值的类型和内容都相同时分组键才相同，
+// 因此 int 类型的 1 和字符串 "1" 属于不同的分组，包含分隔符的值也不会与其他分组混淆，nil 是单独的分组
+func GroupKey(values []interface{}) string {
+	var key strings.Builder
+	for _, val := range values {
+		if val == nil {
+			key.WriteString("nil;")
+			continue
+		}
+		s, ok := val.(string)
+		if !ok {
+			s = fmt.Sprintf("%v", val)
+		}
+		fmt.Fprintf(&key, "%T:%d:%s;", val, len(s), s)
+	}
+	return key.String()
 }
 
 func (ga *GroupAggregator) GetResults() ([]map[string]interface{}, error) {
@@ -176,25 +492,16 @@ func (ga *GroupAggregator) GetResults() ([]map[string]interface{}, error) {
 	result := make([]map[string]interface{}, 0, len(ga.groups))
 	for key, aggregators := range ga.groups {
 		group := make(map[string]interface{})
-		fields := strings.Split(key, "|")
+		for _, field := range ga.rowFields {
+			group[field] = ga.rowValues[key][field]
+		}
+		// 分组字段保留数据中的原始值和类型
+		values := ga.groupValues[key]
 		for i, field := range ga.groupFields {
-			group[field] = fields[i]
-		}
-		for field, agg := range aggregators {
-			if _, ok := agg.(ContextAggregator); ok {
-				if alias, ok := ga.fieldAlias[field]; ok {
-					group[alias] = agg.Result()
-				} else {
-					group[field] = agg.Result()
-				}
-			} else {
-				if alias, ok := ga.fieldAlias[field]; ok {
-					group[alias] = agg.Result()
-				} else {
-					group[field+"_"+string(ga.fieldMap[field])] = agg.Result()
-				}
-			}
-
+			group[field.Name] = values[i]
+		}
+		for alias, agg := range aggregators {
+			group[alias] = agg.Result()
 		}
 		result = append(result, group)
 	}
@@ -205,4 +512,7 @@ func (ga *GroupAggregator) Reset() {
 	ga.mu.Lock()         // 获取写锁
 	defer ga.mu.Unlock() // 确保函数返回时释放锁
 	ga.groups = make(map[string]map[string]AggregatorFunction)
+	ga.groupValues = make(map[string][]interface{})
+	ga.rowValues = make(map[string]map[string]interface{})
+	ga.rows = make(map[string]int)
 }
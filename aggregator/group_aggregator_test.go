@@ -1,9 +1,15 @@
 package aggregator
 
 import (
+	"fmt"
+	"math"
 	"testing"
+	"time"
 
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/functions"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type testData struct {
@@ -13,7 +19,7 @@ type testData struct {
 }
 
 func TestGroupAggregator_MultiFieldSum(t *testing.T) {
-	agg := NewGroupAggregator(
+	agg, err := NewGroupAggregator(
 		[]string{"Device"},
 		map[string]AggregateType{
 			"temperature": Sum,
@@ -24,6 +30,7 @@ func TestGroupAggregator_MultiFieldSum(t *testing.T) {
 			"humidity":    "humidity_sum",
 		},
 	)
+	require.NoError(t, err)
 
 	testData := []map[string]interface{}{
 		{"Device": "aa", "temperature": 25.5, "humidity": 60.0},
@@ -46,7 +53,7 @@ func TestGroupAggregator_MultiFieldSum(t *testing.T) {
 }
 
 func TestGroupAggregator_SingleField(t *testing.T) {
-	agg := NewGroupAggregator(
+	agg, err := NewGroupAggregator(
 		[]string{"Device"},
 		map[string]AggregateType{
 			"temperature": Sum,
@@ -55,6 +62,7 @@ func TestGroupAggregator_SingleField(t *testing.T) {
 			"temperature": "temperature_sum",
 		},
 	)
+	require.NoError(t, err)
 
 	testData := []map[string]interface{}{
 		{"Device": "cc", "temperature": 24.5},
@@ -74,7 +82,7 @@ func TestGroupAggregator_SingleField(t *testing.T) {
 }
 
 func TestGroupAggregator_MultipleAggregators(t *testing.T) {
-	agg := NewGroupAggregator(
+	agg, err := NewGroupAggregator(
 		[]string{"Device"},
 		map[string]AggregateType{
 			"temperature": Sum,
@@ -89,6 +97,7 @@ func TestGroupAggregator_MultipleAggregators(t *testing.T) {
 			"PM10":        "PM10_min",
 		},
 	)
+	require.NoError(t, err)
 
 	testData := []map[string]interface{}{
 		{"Device": "cc", "temperature": 25.5, "humidity": 65.5, "presure": 1008, "PM10": 35},
@@ -112,3 +121,653 @@ func TestGroupAggregator_MultipleAggregators(t *testing.T) {
 	results, _ := agg.GetResults()
 	assert.ElementsMatch(t, expected, results)
 }
+
+func TestGroupAggregator_ExpressionInput(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields(
+		[]string{"Device"},
+		[]AggregationField{
+			{AggregateType: Sum, OutputAlias: "amount", Expr: &expr.BinaryExpr{
+				Op: expr.OpMul, Left: &expr.Ident{Name: "price"}, Right: &expr.Ident{Name: "qty"},
+			}},
+			{InputField: "price", AggregateType: Sum, OutputAlias: "price_sum"},
+			{AggregateType: Max, OutputAlias: "max_delta", Expr: &expr.CallExpr{
+				Name: "abs", Args: []expr.Expr{&expr.Ident{Name: "delta"}},
+			}},
+		},
+	)
+	require.NoError(t, err)
+
+	testData := []map[string]interface{}{
+		{"Device": "aa", "price": 2.5, "qty": 4, "delta": -7},
+		{"Device": "aa", "price": 1.0, "qty": 3, "delta": 5},
+		// qty 缺失时表达式结果为 nil，不参与 amount 的聚合
+		{"Device": "aa", "price": 10.0, "delta": 1},
+	}
+	for _, d := range testData {
+		assert.NoError(t, agg.Add(d))
+	}
+
+	results, _ := agg.GetResults()
+	assert.Equal(t, []map[string]interface{}{
+		{"Device": "aa", "amount": 13.0, "price_sum": 13.5, "max_delta": 7.0},
+	}, results)
+
+	assert.Error(t, agg.Add(map[string]interface{}{"Device": "aa", "price": "x", "qty": 1, "delta": 0}))
+}
+
+func TestGroupAggregator_GroupValueTypes(t *testing.T) {
+	agg, err := NewGroupAggregator([]string{"id", "online"}, map[string]AggregateType{"temperature": Sum}, nil)
+	require.NoError(t, err)
+	require.NoError(t, agg.Add(map[string]interface{}{"id": 7, "online": true, "temperature": 1.0}))
+	require.NoError(t, agg.Add(map[string]interface{}{"id": 7, "online": true, "temperature": 2.0}))
+
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	// 分组字段保留数据中的原始类型
+	assert.Equal(t, []map[string]interface{}{{"id": 7, "online": true, "temperature_sum": 3.0}}, results)
+}
+
+func TestGroupAggregator_GroupKeys(t *testing.T) {
+	agg, err := NewGroupAggregator([]string{"a", "b"}, map[string]AggregateType{"v": Count}, nil)
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		// 包含分隔符的值不会与其他分组混淆
+		{"a": "x|y", "b": "z", "v": 1},
+		{"a": "x", "b": "y|z", "v": 1},
+		// 类型不同的值属于不同的分组
+		{"a": 1, "b": "z", "v": 1},
+		{"a": "1", "b": "z", "v": 1},
+		// 分组字段为 nil 或缺失时归入 nil 分组
+		{"a": nil, "b": "z", "v": 1},
+		{"b": "z", "v": 1},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"a": "x|y", "b": "z", "v_count": 1.0},
+		{"a": "x", "b": "y|z", "v_count": 1.0},
+		{"a": 1, "b": "z", "v_count": 1.0},
+		{"a": "1", "b": "z", "v_count": 1.0},
+		{"a": nil, "b": "z", "v_count": 2.0},
+	}, results)
+
+	assert.Equal(t, GroupKey([]interface{}{"x", 1}), GroupKey([]interface{}{"x", 1}))
+	assert.NotEqual(t, GroupKey([]interface{}{nil}), GroupKey([]interface{}{"<nil>"}))
+	assert.NotEqual(t, GroupKey([]interface{}{int64(1)}), GroupKey([]interface{}{1}))
+}
+
+func TestGroupAggregator_GroupByExpression(t *testing.T) {
+	bucket := &expr.CallExpr{Name: "floor", Args: []expr.Expr{
+		&expr.BinaryExpr{Op: expr.OpDiv, Left: &expr.Ident{Name: "temperature"}, Right: &expr.Literal{Value: int64(10)}},
+	}}
+	agg, err := NewGroupAggregatorWithGroups(
+		[]GroupField{{Name: "payload.site"}, {Name: bucket.String(), Expr: bucket}},
+		[]AggregationField{{InputField: "temperature", AggregateType: Count, OutputAlias: "cnt"}},
+	)
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"payload": map[string]interface{}{"site": "s1"}, "temperature": 21.0},
+		{"payload": map[string]interface{}{"site": "s1"}, "temperature": 29.5},
+		{"payload": map[string]interface{}{"site": "s1"}, "temperature": 31.0},
+		{"payload": map[string]interface{}{"site": "s2"}, "temperature": 22.0},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"payload.site": "s1", "floor(temperature / 10)": 2.0, "cnt": 2.0},
+		{"payload.site": "s1", "floor(temperature / 10)": 3.0, "cnt": 1.0},
+		{"payload.site": "s2", "floor(temperature / 10)": 2.0, "cnt": 1.0},
+	}, results)
+
+	// 分组表达式求值出错时返回出错的分组字段
+	var fieldErr *FieldError
+	err = agg.Add(map[string]interface{}{"temperature": "hot"})
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "floor(temperature / 10)", fieldErr.Field)
+}
+
+func TestGroupAggregator_RowFields(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields([]string{"device"}, []AggregationField{
+		{InputField: "temperature", AggregateType: Max, OutputAlias: "max_temp"},
+	})
+	require.NoError(t, err)
+	agg.SetRowFields([]string{"status", "meta.site"})
+	for _, row := range []map[string]interface{}{
+		{"device": "aa", "temperature": 20.0, "status": "starting", "meta": map[string]interface{}{"site": "s1"}},
+		{"device": "aa", "temperature": 25.0, "status": "running"},
+		{"device": "bb", "temperature": 30.0},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	// 非聚合字段取分组内最后一条包含该字段的数据的值
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"device": "aa", "max_temp": 25.0, "status": "running", "meta.site": "s1"},
+		{"device": "bb", "max_temp": 30.0, "status": nil, "meta.site": nil},
+	}, results)
+
+	agg.Reset()
+	require.NoError(t, agg.Add(map[string]interface{}{"device": "aa", "temperature": 1.0}))
+	results, err = agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"device": "aa", "max_temp": 1.0, "status": nil, "meta.site": nil}}, results)
+}
+
+func TestGroupAggregator_Errors(t *testing.T) {
+	_, err := CreateBuiltinAggregator("unknown")
+	assert.Error(t, err)
+	_, err = NewGroupAggregatorWithFields(nil, []AggregationField{{InputField: "a", AggregateType: "unknown", OutputAlias: "a"}})
+	assert.Error(t, err)
+
+	agg, err := NewGroupAggregator([]string{"Device"}, map[string]AggregateType{"temperature": Sum}, nil)
+	require.NoError(t, err)
+	var fieldErr *FieldError
+	// 非数值字段不能参与聚合
+	err = agg.Add(map[string]interface{}{"Device": "aa", "temperature": "hot"})
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "temperature", fieldErr.Field)
+}
+
+func TestGroupAggregator_FailedRowIsAtomic(t *testing.T) {
+	count := AggregationField{AggregateType: Count, OutputAlias: "c"}
+	sum := AggregationField{InputField: "s", AggregateType: Sum, OutputAlias: "x"}
+	countT := AggregationField{InputField: "t", AggregateType: Count, OutputAlias: "ct"}
+	// 出错的聚合在 SELECT 中的位置不影响结果，出错的数据不参与任何聚合
+	for _, fields := range [][]AggregationField{{count, sum, countT}, {sum, count, countT}} {
+		agg, err := NewGroupAggregatorWithFields(nil, fields)
+		require.NoError(t, err)
+		for i := 0; i < 4; i++ {
+			assert.Error(t, agg.Add(map[string]interface{}{"s": "bad", "t": i}))
+		}
+		require.NoError(t, agg.Add(map[string]interface{}{"s": 2, "t": 1}))
+		results, err := agg.GetResults()
+		require.NoError(t, err)
+		assert.Equal(t, []map[string]interface{}{{"c": float64(1), "x": float64(2), "ct": float64(1)}}, results)
+	}
+}
+
+type smallIntReading struct {
+	Device string
+	Level  int16
+	Code   uint8
+}
+
+func TestGroupAggregator_SmallIntegerTypes(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields([]string{"Device"}, []AggregationField{
+		{InputField: "Level", AggregateType: Sum, OutputAlias: "level_sum"},
+		{InputField: "Level", AggregateType: Max, OutputAlias: "level_max"},
+		{InputField: "Code", AggregateType: CountDistinct, OutputAlias: "codes"},
+	})
+	require.NoError(t, err)
+	for _, row := range []smallIntReading{
+		{Device: "aa", Level: 3, Code: 1},
+		{Device: "aa", Level: -5, Code: 2},
+		{Device: "aa", Level: 7, Code: 1},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"Device": "aa", "level_sum": 5.0, "level_max": 7.0, "codes": 2.0},
+	}, results)
+}
+
+func TestConvertToFloat64(t *testing.T) {
+	v, err := ConvertToFloat64E("25.5")
+	require.NoError(t, err)
+	assert.Equal(t, 25.5, v)
+	_, err = ConvertToFloat64E("hot")
+	assert.Error(t, err)
+	_, err = ConvertToFloat64E(nil)
+	assert.Error(t, err)
+	// 无法转换时返回默认值而不是 panic
+	assert.Equal(t, 1.0, ConvertToFloat64("hot", 1))
+	assert.Equal(t, 3.0, ConvertToFloat64(int64(3), 1))
+}
+
+// weightedAvg 测试用的多参数聚合器，计算 value 按 weight 加权的平均值
+type weightedAvg struct {
+	sum, weight float64
+}
+
+func (w *weightedAvg) New() AggregatorFunction { return &weightedAvg{} }
+func (w *weightedAvg) Add(v interface{})       { w.AddArgs([]interface{}{v, 1.0}) }
+func (w *weightedAvg) AddArgs(args []interface{}) {
+	w.sum += args[0].(float64) * args[1].(float64)
+	w.weight += args[1].(float64)
+}
+func (w *weightedAvg) Result() interface{} {
+	if w.weight == 0 {
+		return nil
+	}
+	return w.sum / w.weight
+}
+
+// countAbove 测试用的带常量参数的聚合器，统计大于阈值的数据条数
+type countAbove struct {
+	threshold float64
+	count     int
+}
+
+func (c *countAbove) New() AggregatorFunction { return &countAbove{threshold: c.threshold} }
+func (c *countAbove) Add(v interface{}) {
+	if v.(float64) > c.threshold {
+		c.count++
+	}
+}
+func (c *countAbove) Result() interface{} { return c.count }
+
+func TestGroupAggregator_RegisteredAggregators(t *testing.T) {
+	number := functions.TypeNumber
+	RegisterWithSignature("Weighted_Avg", Signature{Args: []functions.ArgType{number, number}, Result: number},
+		func([]interface{}) (AggregatorFunction, error) { return &weightedAvg{}, nil })
+	RegisterWithSignature("count_above", Signature{Args: []functions.ArgType{number}, Params: []functions.ArgType{number}, OptionalParams: 1, Result: number},
+		func(params []interface{}) (AggregatorFunction, error) {
+			if len(params) == 0 {
+				return &countAbove{}, nil
+			}
+			threshold, err := ConvertToFloat64E(params[0])
+			if err != nil {
+				return nil, err
+			}
+			return &countAbove{threshold: threshold}, nil
+		})
+
+	sig, ok := SignatureOf("weighted_avg")
+	require.True(t, ok)
+	assert.NoError(t, sig.Check("weighted_avg", []functions.ArgType{number, functions.TypeAny}))
+	assert.EqualError(t, sig.Check("weighted_avg", []functions.ArgType{number}), "weighted_avg expects 2 arguments but got 1")
+	assert.EqualError(t, sig.Check("weighted_avg", []functions.ArgType{number, functions.TypeString}), "weighted_avg argument 2 expects NUMBER but got STRING")
+	sig, ok = SignatureOf("count_above")
+	require.True(t, ok)
+	assert.False(t, sig.IsParam(0))
+	assert.True(t, sig.IsParam(1))
+	assert.EqualError(t, sig.Check("count_above", nil), "count_above expects 1 to 2 arguments but got 0")
+	assert.True(t, IsAggregate("COUNT_ABOVE"))
+	assert.False(t, IsAggregate("abs"))
+
+	_, err := CreateAggregator("count_above", []interface{}{"x"})
+	assert.Error(t, err)
+
+	agg, err := NewGroupAggregatorWithFields([]string{"device"}, []AggregationField{
+		{AggregateType: "weighted_avg", OutputAlias: "w", Args: []expr.Expr{&expr.Ident{Name: "value"}, &expr.Ident{Name: "weight"}}},
+		{InputField: "value", AggregateType: "count_above", OutputAlias: "hot", Params: []interface{}{int64(20)}},
+		{InputField: "value", AggregateType: "count_above", OutputAlias: "positive"},
+	})
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"device": "aa", "value": 10, "weight": 1},
+		{"device": "aa", "value": 30, "weight": 3},
+		// 任一参数为 nil 的数据不参与多参数聚合
+		{"device": "aa", "value": 50},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"device": "aa", "w": 25.0, "hot": 2, "positive": 3}}, results)
+
+	var fieldErr *FieldError
+	require.ErrorAs(t, agg.Add(map[string]interface{}{"device": "aa", "value": 1, "weight": "heavy"}), &fieldErr)
+	assert.Equal(t, "w", fieldErr.Field)
+
+	// 只接受一个参数的聚合器不能用于多参数调用
+	_, err = NewGroupAggregatorWithFields(nil, []AggregationField{
+		{AggregateType: Sum, OutputAlias: "s", Args: []expr.Expr{&expr.Ident{Name: "a"}, &expr.Ident{Name: "b"}}},
+	})
+	assert.Error(t, err)
+}
+
+func TestPercentileAggregators(t *testing.T) {
+	add := func(agg AggregatorFunction, values ...float64) AggregatorFunction {
+		for _, v := range values {
+			agg.Add(v)
+		}
+		return agg
+	}
+	create := func(aggType AggregateType, params ...interface{}) AggregatorFunction {
+		agg, err := CreateAggregator(aggType, params)
+		require.NoError(t, err)
+		// 每个分组通过 New 创建实例，实例保留比例参数
+		return agg.New()
+	}
+
+	// 没有数据时结果为 nil，偶数条数据的中位数为中间两个数的平均值
+	assert.Nil(t, create(Median).Result())
+	assert.Equal(t, 2.5, add(create(Median), 4, 1, 3, 2).Result())
+	assert.Equal(t, 3.0, add(create(Median), 5, 1, 3).Result())
+
+	values := []float64{15, 20, 35, 40, 50}
+	assert.Nil(t, create(Percentile).Result())
+	assert.InDelta(t, 48.0, add(create(Percentile), values...).Result(), 1e-9)
+	assert.InDelta(t, 29.0, add(create(Percentile, 0.4), values...).Result(), 1e-9)
+	assert.InDelta(t, 29.0, add(create(PercentileCont, 0.4), values...).Result(), 1e-9)
+	assert.Equal(t, 15.0, add(create(PercentileCont, int64(0)), values...).Result())
+	assert.Equal(t, 50.0, add(create(PercentileCont, int64(1)), values...).Result())
+	assert.Equal(t, 20.0, add(create(PercentileDisc, 0.4), values...).Result())
+	assert.Equal(t, 35.0, add(create(PercentileDisc, 0.5), values...).Result())
+	assert.Equal(t, 15.0, add(create(PercentileDisc, 0.0), values...).Result())
+	assert.Equal(t, 50.0, add(create(PercentileDisc, 1.0), values...).Result())
+
+	_, err := CreateAggregator(PercentileCont, []interface{}{1.5})
+	assert.EqualError(t, err, "percentile_cont: percentile must be between 0 and 1 but got 1.5")
+
+	// 数据较多时由 t-digest 估算分位数，质心个数不随数据量增长
+	agg := create(Percentile, 0.99).(*PercentileAggregator)
+	median := create(Median).(*MedianAggregator)
+	const n = 200000
+	for i := 0; i < n; i++ {
+		v := float64((i * 7919) % n)
+		agg.Add(v)
+		median.Add(v)
+	}
+	assert.InDelta(t, 0.99*(n-1), agg.Result(), n*0.002)
+	assert.InDelta(t, 0.5*(n-1), median.Result(), n*0.005)
+	assert.Less(t, len(agg.digest.centroids), 10*tdigestCompression)
+	assert.Less(t, len(agg.digest.buffer), tdigestBufferSize)
+}
+
+func TestCountAggregators(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields([]string{"site"}, []AggregationField{
+		{AggregateType: Count, OutputAlias: "count(*)"},
+		{InputField: "deviceId", AggregateType: Count, OutputAlias: "devices"},
+		{InputField: "deviceId", AggregateType: CountDistinct, OutputAlias: "unique"},
+		{InputField: "deviceId", AggregateType: ApproxCountDistinct, OutputAlias: "approx"},
+	})
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"site": "s1", "deviceId": "a"},
+		{"site": "s1", "deviceId": "b"},
+		{"site": "s1", "deviceId": "a"},
+		// nil 和缺失的字段不参与 count(field)，但参与 count(*)
+		{"site": "s1", "deviceId": nil},
+		{"site": "s1"},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{
+		{"site": "s1", "count(*)": 5.0, "devices": 3.0, "unique": 2.0, "approx": 2.0},
+	}, results)
+
+	// 数值按数值去重
+	distinct := (&CountDistinctAggregator{}).New()
+	for _, v := range []interface{}{1, int64(1), 1.0, "1", true, 2.5} {
+		distinct.Add(v)
+	}
+	assert.Equal(t, 4.0, distinct.Result())
+
+	_, err = CreateAggregator(ApproxCountDistinct, []interface{}{int64(20)})
+	assert.EqualError(t, err, "approx_count_distinct: precision must be between 4 and 18 but got 20")
+	_, err = CreateAggregator(ApproxCountDistinct, []interface{}{12.5})
+	assert.Error(t, err)
+
+	// HyperLogLog 的误差在标准误差的数倍以内
+	for _, precision := range []int64{10, 14} {
+		approx, err := CreateAggregator(ApproxCountDistinct, []interface{}{precision})
+		require.NoError(t, err)
+		approx = approx.New()
+		const n = 300000
+		for i := 0; i < n; i++ {
+			approx.Add(fmt.Sprintf("device-%d", i))
+			approx.Add(fmt.Sprintf("device-%d", i/2))
+		}
+		stdErr := 1.04 / math.Sqrt(float64(int(1)<<precision))
+		assert.InDelta(t, float64(n), approx.Result(), 4*stdErr*n, "precision %d", precision)
+		assert.Len(t, approx.(*ApproxCountDistinctAggregator).registers, 1<<precision)
+	}
+}
+
+func TestValueAggregators(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields([]string{"device"}, []AggregationField{
+		{InputField: "status", AggregateType: FirstValue, OutputAlias: "first"},
+		{InputField: "status", AggregateType: LastValue, OutputAlias: "last"},
+		{InputField: "status", AggregateType: CollectList, OutputAlias: "list"},
+		{InputField: "status", AggregateType: CollectSet, OutputAlias: "set"},
+		{InputField: "status", AggregateType: Mode, OutputAlias: "mode"},
+		{InputField: "status", AggregateType: Min, OutputAlias: "min_status"},
+		{InputField: "ts", AggregateType: Max, OutputAlias: "max_ts"},
+		{InputField: "temperature", AggregateType: Max, OutputAlias: "max_temp"},
+	})
+	require.NoError(t, err)
+	base := time.Date(2025, 4, 7, 16, 0, 0, 0, time.UTC)
+	for _, row := range []struct {
+		offset time.Duration
+		data   map[string]interface{}
+	}{
+		{2 * time.Second, map[string]interface{}{"device": "aa", "status": "running", "temperature": -3}},
+		// 乱序到达的数据按事件时间取 first_value 和 last_value
+		{1 * time.Second, map[string]interface{}{"device": "aa", "status": "starting", "temperature": -5.5}},
+		{4 * time.Second, map[string]interface{}{"device": "aa", "status": "stopped", "ts": base.Add(4 * time.Second)}},
+		{3 * time.Second, map[string]interface{}{"device": "aa", "status": "running", "ts": base}},
+	} {
+		require.NoError(t, agg.Put(EventTimeKey, base.Add(row.offset)))
+		require.NoError(t, agg.Add(row.data))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{
+		"device":     "aa",
+		"first":      "starting",
+		"last":       "stopped",
+		"list":       []interface{}{"running", "starting", "stopped", "running"},
+		"set":        []interface{}{"running", "starting", "stopped"},
+		"mode":       "running",
+		"min_status": "running",
+		"max_ts":     base.Add(4 * time.Second),
+		"max_temp":   -3.0,
+	}}, results)
+
+	// min 和 max 只能比较同类的值
+	var fieldErr *FieldError
+	require.ErrorAs(t, agg.Add(map[string]interface{}{"device": "aa", "temperature": "hot"}), &fieldErr)
+	assert.Equal(t, "temperature", fieldErr.Field)
+	require.ErrorAs(t, agg.Add(map[string]interface{}{"device": "bb", "temperature": true}), &fieldErr)
+
+	// 没有数据时间时按到达顺序聚合
+	last := (&LastValueAggregator{}).New()
+	first := (&FirstValueAggregator{}).New()
+	for _, v := range []interface{}{1, 2, 3} {
+		last.Add(v)
+		first.Add(v)
+	}
+	assert.Equal(t, 3, last.Result())
+	assert.Equal(t, 1, first.Result())
+	assert.Nil(t, (&MinAggregator{}).New().Result())
+	mode := (&ModeAggregator{}).New()
+	for _, v := range []interface{}{2, 1, 1.0, 2.0} {
+		mode.Add(v)
+	}
+	assert.Equal(t, 2, mode.Result())
+}
+
+func TestTopKAggregators(t *testing.T) {
+	agg, err := NewGroupAggregatorWithFields([]string{"gateway"}, []AggregationField{
+		{InputField: "deviceId", AggregateType: TopK, OutputAlias: "noisy", Params: []interface{}{int64(2)}},
+		{AggregateType: TopKBy, OutputAlias: "heavy", Params: []interface{}{int64(2)},
+			Args: []expr.Expr{&expr.Ident{Name: "deviceId"}, &expr.Ident{Name: "bytes"}}},
+	})
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"gateway": "g1", "deviceId": "a", "bytes": 10},
+		{"gateway": "g1", "deviceId": "b", "bytes": 500},
+		{"gateway": "g1", "deviceId": "a", "bytes": 20},
+		{"gateway": "g1", "deviceId": "c", "bytes": 100},
+		{"gateway": "g1", "deviceId": "c", "bytes": 1},
+		{"gateway": "g1", "deviceId": "a", "bytes": 5},
+	} {
+		require.NoError(t, agg.Add(row))
+	}
+	results, err := agg.GetResults()
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{
+		"gateway": "g1",
+		"noisy":   []map[string]interface{}{{"value": "a", "count": 3.0}, {"value": "c", "count": 2.0}},
+		"heavy":   []map[string]interface{}{{"value": "b", "weight": 500.0}, {"value": "c", "weight": 101.0}},
+	}}, results)
+
+	for _, params := range [][]interface{}{nil, {int64(0)}, {1.5}} {
+		_, err := CreateAggregator(TopK, params)
+		assert.Error(t, err, params)
+	}
+
+	// 不同值的个数远超计数器个数时仍能找出高频值，计数器个数有上限
+	topk, err := NewTopKAggregator(3)
+	require.NoError(t, err)
+	heavy := []string{"x", "y", "z"}
+	for i := 0; i < 100000; i++ {
+		topk.Add(fmt.Sprintf("device-%d", i))
+		topk.Add(heavy[i%3])
+	}
+	var values []interface{}
+	for _, item := range topk.Result().([]map[string]interface{}) {
+		values = append(values, item["value"])
+	}
+	assert.ElementsMatch(t, []interface{}{"x", "y", "z"}, values)
+	assert.Len(t, topk.sketch.heap, topKMinCapacity)
+	assert.Len(t, topk.sketch.counters, topKMinCapacity)
+}
+
+func TestGroupAggregator_Merge(t *testing.T) {
+	fields := []AggregationField{
+		{InputField: "value", AggregateType: Sum, OutputAlias: "sum"},
+		{InputField: "value", AggregateType: Count, OutputAlias: "count"},
+		{InputField: "value", AggregateType: Avg, OutputAlias: "avg"},
+		{InputField: "value", AggregateType: Min, OutputAlias: "min"},
+		{InputField: "value", AggregateType: Max, OutputAlias: "max"},
+		{InputField: "value", AggregateType: StdDev, OutputAlias: "stddev"},
+		{InputField: "value", AggregateType: Median, OutputAlias: "median"},
+		{InputField: "value", AggregateType: PercentileDisc, OutputAlias: "p90", Params: []interface{}{0.9}},
+		{InputField: "name", AggregateType: CountDistinct, OutputAlias: "distinct"},
+		{InputField: "name", AggregateType: ApproxCountDistinct, OutputAlias: "approx"},
+		{InputField: "name", AggregateType: FirstValue, OutputAlias: "first"},
+		{InputField: "name", AggregateType: LastValue, OutputAlias: "last"},
+		{InputField: "name", AggregateType: CollectList, OutputAlias: "list"},
+		{InputField: "name", AggregateType: CollectSet, OutputAlias: "set"},
+		{InputField: "name", AggregateType: Mode, OutputAlias: "mode"},
+		{InputField: "name", AggregateType: TopK, OutputAlias: "top", Params: []interface{}{int64(2)}},
+		{InputField: "start", AggregateType: WindowStart, OutputAlias: "start"},
+	}
+	whole, err := NewGroupAggregatorWithFields([]string{"device"}, fields)
+	require.NoError(t, err)
+	assert.True(t, whole.Mergeable())
+	assert.False(t, whole.Retractable())
+	left, right := whole.New(), whole.New()
+
+	rows := []map[string]interface{}{
+		{"device": "aa", "value": 3, "name": "x"},
+		{"device": "aa", "value": 8, "name": "y"},
+		{"device": "bb", "value": 1, "name": "x"},
+		{"device": "aa", "value": 5, "name": "y"},
+		{"device": "aa", "value": 2, "name": "z"},
+		{"device": "bb", "value": 4, "name": "z"},
+	}
+	base := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	for i, row := range rows {
+		part := left
+		if i >= 3 {
+			part = right
+		}
+		for _, agg := range []*GroupAggregator{whole, part} {
+			require.NoError(t, agg.Put(EventTimeKey, base.Add(time.Duration(i)*time.Second)))
+			require.NoError(t, agg.Add(row))
+		}
+	}
+	require.NoError(t, whole.Put("window_start", base.UnixNano()))
+
+	merged := whole.New()
+	merged.Merge(left)
+	merged.Merge(right)
+	require.NoError(t, merged.Put("window_start", base.UnixNano()))
+	merged.ApplyContext()
+
+	expected, err := whole.GetResults()
+	require.NoError(t, err)
+	actual, err := merged.GetResults()
+	require.NoError(t, err)
+	// whole 的 window_start 只在加入数据时从上下文取值，此时上下文中还没有窗口开始时间
+	for _, group := range expected {
+		assert.Nil(t, group["start"])
+		group["start"] = base.UnixNano()
+	}
+	assert.ElementsMatch(t, expected, actual)
+
+	// 分片的聚合状态在合并后不变
+	results, err := right.GetResults()
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	RegisterWithSignature("merge_weighted_avg", Signature{Args: []functions.ArgType{functions.TypeNumber, functions.TypeNumber}, Result: functions.TypeNumber},
+		func([]interface{}) (AggregatorFunction, error) { return &weightedAvg{}, nil })
+	custom, err := NewGroupAggregatorWithFields(nil, []AggregationField{
+		{AggregateType: "merge_weighted_avg", OutputAlias: "wavg", Args: []expr.Expr{&expr.Ident{Name: "value"}, &expr.Ident{Name: "weight"}}},
+	})
+	require.NoError(t, err)
+	assert.False(t, custom.Mergeable())
+}
+
+func TestGroupAggregator_Retract(t *testing.T) {
+	running, err := NewGroupAggregatorWithFields([]string{"device"}, []AggregationField{
+		{InputField: "value", AggregateType: Sum, OutputAlias: "sum"},
+		{InputField: "value", AggregateType: Count, OutputAlias: "count"},
+		{InputField: "value", AggregateType: Avg, OutputAlias: "avg"},
+	})
+	require.NoError(t, err)
+	assert.True(t, running.Retractable())
+	first, second := running.New(), running.New()
+	require.NoError(t, first.Add(map[string]interface{}{"device": "aa", "value": 10}))
+	require.NoError(t, first.Add(map[string]interface{}{"device": "bb", "value": 4}))
+	require.NoError(t, second.Add(map[string]interface{}{"device": "aa", "value": 20}))
+	require.NoError(t, second.Add(map[string]interface{}{"device": "aa", "value": 30}))
+
+	running.Merge(first)
+	running.Merge(second)
+	running.Retract(first)
+	results, err := running.GetResults()
+	require.NoError(t, err)
+	// 撤销后没有数据的分组被删除
+	assert.Equal(t, []map[string]interface{}{
+		{"device": "aa", "sum": 50.0, "count": 2.0, "avg": 25.0},
+	}, results)
+
+	running.SetRowFields([]string{"name"})
+	assert.False(t, running.Retractable())
+}
+
+func TestStdDevAggregator(t *testing.T) {
+	values := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	whole, left, right := &StdDevAggregator{}, &StdDevAggregator{}, &StdDevAggregator{}
+	for i, v := range values {
+		whole.Add(v)
+		if i < 3 {
+			left.Add(v)
+		} else {
+			right.Add(v)
+		}
+	}
+	assert.InDelta(t, math.Sqrt(32.0/7), whole.Result(), 1e-9)
+
+	// 按并行方差公式合并部分状态，结果与对所有数据计算相同
+	merged := &StdDevAggregator{}
+	merged.Merge(left)
+	merged.Merge(right)
+	assert.InDelta(t, whole.Result(), merged.Result(), 1e-9)
+
+	// 撤销一部分后结果与只对另一部分计算相同
+	merged.Retract(left)
+	assert.InDelta(t, right.Result(), merged.Result(), 1e-9)
+	merged.Retract(right)
+	assert.Equal(t, 0, merged.Result())
+	assert.Equal(t, StdDevAggregator{}, *merged)
+
+	var empty StdDevAggregator
+	empty.Add(3)
+	assert.Equal(t, 0, empty.Result())
+}
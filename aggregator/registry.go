@@ -0,0 +1,244 @@
+package aggregator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rulego/streamsql/functions"
+)
+
+// MultiArgAggregator 接受多个参数的聚合器，如 weighted_avg(value, weight)。
+// 每行数据按签名中 Args 的顺序传入各参数的值，任一参数为 nil 的数据不参与聚合
+type MultiArgAggregator interface {
+	AggregatorFunction
+	AddArgs(args []interface{})
+}
+
+// Signature 聚合函数的签名，描述参数的个数和类型以及聚合结果的类型，编译查询时用于检查聚合函数调用
+type Signature struct {
+	// Args 对每行数据求值的参数类型。数值参数在聚合前转换为 float64，其他类型的参数按原值传入
+	Args []functions.ArgType
+	// Params 位于 Args 之后的常量参数类型，如 percentile(latency, 0.99) 中的 0.99，创建聚合器时传入
+	Params []functions.ArgType
+	// OptionalParams Params 末尾可以省略的参数个数
+	OptionalParams int
+	// Result 聚合结果的类型
+	Result functions.ArgType
+}
+
+// Check 检查聚合函数调用的参数个数和参数类型，args 为调用中所有参数的类型，类型为 functions.TypeAny 的参数不检查类型
+func (s *Signature) Check(name string, args []functions.ArgType) error {
+	max := len(s.Args) + len(s.Params)
+	min := max - s.OptionalParams
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return fmt.Errorf("%s expects %d arguments but got %d", name, min, len(args))
+		}
+		return fmt.Errorf("%s expects %d to %d arguments but got %d", name, min, max, len(args))
+	}
+	for i, t := range args {
+		param := s.argType(i)
+		if !param.Accepts(t) {
+			return fmt.Errorf("%s argument %d expects %s but got %s", name, i+1, param, t)
+		}
+	}
+	return nil
+}
+
+// IsParam 判断第 i 个参数是否为常量参数，i 从 0 开始
+func (s *Signature) IsParam(i int) bool {
+	return i >= len(s.Args)
+}
+
+func (s *Signature) argType(i int) functions.ArgType {
+	if i < len(s.Args) {
+		return s.Args[i]
+	}
+	return s.Params[i-len(s.Args)]
+}
+
+// registration 注册表中的聚合函数
+type registration struct {
+	sig    Signature
+	create func(params []interface{}) (AggregatorFunction, error)
+}
+
+var (
+	aggregatorRegistry = make(map[string]registration)
+	registryMutex      sync.RWMutex
+)
+
+// builtinSignatures 内置聚合函数的签名
+var builtinSignatures = map[AggregateType]Signature{
+	Sum:            numericSignature,
+	Avg:            numericSignature,
+	Max:            anySignature,
+	Min:            anySignature,
+	StdDev:         numericSignature,
+	Median:         numericSignature,
+	Percentile:     {Args: []functions.ArgType{functions.TypeNumber}, Params: []functions.ArgType{functions.TypeNumber}, OptionalParams: 1, Result: functions.TypeNumber},
+	PercentileCont: percentileSignature,
+	PercentileDisc: percentileSignature,
+	Count:          countSignature,
+	CountDistinct:  countSignature,
+	// approx_count_distinct 的第二个参数为 HyperLogLog 的精度
+	ApproxCountDistinct: {Args: countSignature.Args, Params: []functions.ArgType{functions.TypeNumber}, OptionalParams: 1, Result: functions.TypeNumber},
+	FirstValue:          anySignature,
+	LastValue:           anySignature,
+	CollectList:         anySignature,
+	CollectSet:          anySignature,
+	Mode:                anySignature,
+	TopK:                {Args: []functions.ArgType{functions.TypeAny}, Params: []functions.ArgType{functions.TypeNumber}, Result: functions.TypeAny},
+	TopKBy:              {Args: []functions.ArgType{functions.TypeAny, functions.TypeNumber}, Params: []functions.ArgType{functions.TypeNumber}, Result: functions.TypeAny},
+	WindowStart:         {Result: functions.TypeNumber},
+	WindowEnd:           {Result: functions.TypeNumber},
+}
+
+// numericSignature 只有一个数值参数的聚合函数的签名
+var numericSignature = Signature{Args: []functions.ArgType{functions.TypeNumber}, Result: functions.TypeNumber}
+
+// anySignature 接受任意类型参数、结果类型取决于参数的聚合函数的签名
+var anySignature = Signature{Args: []functions.ArgType{functions.TypeAny}, Result: functions.TypeAny}
+
+// countSignature 接受任意类型参数的计数聚合函数的签名
+var countSignature = Signature{Args: []functions.ArgType{functions.TypeAny}, Result: functions.TypeNumber}
+
+// percentileSignature 第二个参数为分位数比例的聚合函数的签名，如 percentile_cont(latency, 0.99)
+var percentileSignature = Signature{Args: []functions.ArgType{functions.TypeNumber}, Params: []functions.ArgType{functions.TypeNumber}, Result: functions.TypeNumber}
+
+// Register 添加自定义聚合器到全局注册表，函数名不区分大小写。
+// 聚合器只有一个数值参数，需要多个参数、常量参数或非数值参数时使用 RegisterWithSignature
+func Register(name string, constructor func() AggregatorFunction) {
+	RegisterWithSignature(name, numericSignature, func([]interface{}) (AggregatorFunction, error) {
+		return constructor(), nil
+	})
+}
+
+// RegisterWithSignature 按签名添加自定义聚合器到全局注册表，函数名不区分大小写。
+// create 根据调用中的常量参数创建聚合器，参数个数和类型已按签名检查，省略的可选参数不传入；
+// 返回的聚合器通过 New 为每个分组创建实例，New 创建的实例应使用相同的常量参数。
+// Args 多于一个时聚合器必须实现 MultiArgAggregator
+func RegisterWithSignature(name string, sig Signature, create func(params []interface{}) (AggregatorFunction, error)) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	aggregatorRegistry[strings.ToLower(name)] = registration{sig: sig, create: create}
+}
+
+// SignatureOf 返回内置或已注册的聚合函数的签名
+func SignatureOf(name string) (*Signature, bool) {
+	registryMutex.RLock()
+	r, exists := aggregatorRegistry[strings.ToLower(name)]
+	registryMutex.RUnlock()
+	if exists {
+		return &r.sig, true
+	}
+	if sig, ok := builtinSignatures[AggregateType(strings.ToLower(name))]; ok {
+		return &sig, true
+	}
+	return nil, false
+}
+
+// IsAggregate 判断函数名是否为内置或已注册的聚合函数
+func IsAggregate(name string) bool {
+	_, ok := SignatureOf(name)
+	return ok
+}
+
+// CreateBuiltinAggregator 根据聚合类型创建聚合器，优先使用注册表中的自定义聚合器，不支持的类型返回错误
+func CreateBuiltinAggregator(aggType AggregateType) (AggregatorFunction, error) {
+	return CreateAggregator(aggType, nil)
+}
+
+// CreateAggregator 根据聚合类型和常量参数创建聚合器，优先使用注册表中的自定义聚合器，不支持的类型返回错误
+func CreateAggregator(aggType AggregateType, params []interface{}) (AggregatorFunction, error) {
+	registryMutex.RLock()
+	r, exists := aggregatorRegistry[strings.ToLower(string(aggType))]
+	registryMutex.RUnlock()
+	if exists {
+		return r.create(params)
+	}
+
+	switch aggType {
+	case Sum:
+		return &SumAggregator{}, nil
+	case Count:
+		return &CountAggregator{}, nil
+	case CountDistinct:
+		return &CountDistinctAggregator{}, nil
+	case ApproxCountDistinct:
+		precision := DefaultHLLPrecision
+		if len(params) > 0 {
+			p, ok := params[0].(int64)
+			if !ok {
+				return nil, fmt.Errorf("%s: precision must be an integer but got %v", aggType, params[0])
+			}
+			precision = int(p)
+		}
+		agg, err := NewApproxCountDistinctAggregator(precision)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", aggType, err)
+		}
+		return agg, nil
+	case Avg:
+		return &AvgAggregator{}, nil
+	case Min:
+		return &MinAggregator{}, nil
+	case Max:
+		return &MaxAggregator{}, nil
+	case StdDev:
+		return &StdDevAggregator{}, nil
+	//case "var":
+	//	return &VarAggregator{}
+	case Median:
+		return &MedianAggregator{}, nil
+	case Percentile, PercentileCont, PercentileDisc:
+		p := DefaultPercentile
+		if len(params) > 0 {
+			var err error
+			if p, err = ConvertToFloat64E(params[0]); err != nil {
+				return nil, fmt.Errorf("%s: %w", aggType, err)
+			}
+		}
+		agg, err := NewPercentileAggregator(p, aggType == PercentileDisc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", aggType, err)
+		}
+		return agg, nil
+	case FirstValue:
+		return &FirstValueAggregator{}, nil
+	case LastValue:
+		return &LastValueAggregator{}, nil
+	case CollectList:
+		return &CollectListAggregator{}, nil
+	case CollectSet:
+		return &CollectSetAggregator{}, nil
+	case Mode:
+		return &ModeAggregator{}, nil
+	case TopK, TopKBy:
+		if len(params) == 0 {
+			return nil, fmt.Errorf("%s: missing k", aggType)
+		}
+		k, ok := params[0].(int64)
+		if !ok {
+			return nil, fmt.Errorf("%s: k must be an integer but got %v", aggType, params[0])
+		}
+		var agg AggregatorFunction
+		var err error
+		if aggType == TopK {
+			agg, err = NewTopKAggregator(int(k))
+		} else {
+			agg, err = NewTopKByAggregator(int(k))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", aggType, err)
+		}
+		return agg, nil
+	case WindowStart:
+		return &WindowStartAggregator{}, nil
+	case WindowEnd:
+		return &WindowEndAggregator{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported aggregator type: %s", aggType)
+	}
+}
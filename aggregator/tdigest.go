@@ -0,0 +1,135 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+)
+
+// tdigestCompression t-digest 的压缩参数，质心个数约为该值的数倍，与数据量无关
+const tdigestCompression = 100
+
+// tdigestBufferSize 未合并数据的缓冲区大小，缓冲区满时合并到质心
+const tdigestBufferSize = 5 * tdigestCompression
+
+// centroid t-digest 的质心，mean 为质心内数据的平均值，weight 为数据条数
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// tdigest 估算分位数的 t-digest 草图，内存占用有上限。
+// 越靠近两端的质心包含的数据越少，数据较少时每个质心只包含一条数据，分位数是精确值
+type tdigest struct {
+	centroids []centroid
+	// buffer 尚未合并到 centroids 的数据，合并其他草图时也加入其质心
+	buffer   []centroid
+	count    float64
+	min, max float64
+}
+
+func (t *tdigest) add(x float64) {
+	if t.count == 0 || x < t.min {
+		t.min = x
+	}
+	if t.count == 0 || x > t.max {
+		t.max = x
+	}
+	t.count++
+	t.buffer = append(t.buffer, centroid{mean: x, weight: 1})
+	if len(t.buffer) >= tdigestBufferSize {
+		t.compress()
+	}
+}
+
+// merge 把另一个草图的质心和未合并的数据并入当前草图
+func (t *tdigest) merge(other *tdigest) {
+	if other.count == 0 {
+		return
+	}
+	if t.count == 0 || other.min < t.min {
+		t.min = other.min
+	}
+	if t.count == 0 || other.max > t.max {
+		t.max = other.max
+	}
+	t.count += other.count
+	t.buffer = append(t.buffer, other.centroids...)
+	t.buffer = append(t.buffer, other.buffer...)
+	if len(t.buffer) >= tdigestBufferSize {
+		t.compress()
+	}
+}
+
+// compress 把缓冲区中的数据合并到质心，相邻质心合并后的数据条数不超过其所在分位数处的上限
+func (t *tdigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := make([]centroid, 0, len(t.centroids)+len(t.buffer))
+	all = append(all, t.centroids...)
+	all = append(all, t.buffer...)
+	t.buffer = t.buffer[:0]
+	sort.Slice(all, func(i, j int) bool { return all[i].mean < all[j].mean })
+
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	cur := all[0]
+	var cum float64
+	for _, c := range all[1:] {
+		w := cur.weight + c.weight
+		q := (cum + w/2) / t.count
+		if w <= 4*t.count*q*(1-q)/tdigestCompression {
+			cur.mean += (c.mean - cur.mean) * c.weight / w
+			cur.weight = w
+			continue
+		}
+		merged = append(merged, cur)
+		cum += cur.weight
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+}
+
+// quantile 按连续分布估算分位数，在第 p*(n-1) 条数据的位置对相邻质心线性插值，与 percentile_cont 的定义相同
+func (t *tdigest) quantile(p float64) float64 {
+	t.compress()
+	h := p * (t.count - 1)
+	prevCenter, prevMean := 0.0, t.min
+	var start float64
+	for _, c := range t.centroids {
+		// 质心的位置为其包含的数据的中间位置
+		center := start + (c.weight-1)/2
+		if h <= center {
+			if center == prevCenter {
+				return c.mean
+			}
+			return prevMean + (c.mean-prevMean)*(h-prevCenter)/(center-prevCenter)
+		}
+		prevCenter, prevMean = center, c.mean
+		start += c.weight
+	}
+	last := t.count - 1
+	if last == prevCenter {
+		return prevMean
+	}
+	return prevMean + (t.max-prevMean)*(h-prevCenter)/(last-prevCenter)
+}
+
+// quantileDisc 按离散分布估算分位数，返回累计比例不小于 p 的第一条数据，与 percentile_disc 的定义相同
+func (t *tdigest) quantileDisc(p float64) float64 {
+	t.compress()
+	rank := math.Ceil(p*t.count) - 1
+	switch {
+	case rank <= 0:
+		return t.min
+	case rank >= t.count-1:
+		return t.max
+	}
+	var start float64
+	for _, c := range t.centroids {
+		start += c.weight
+		if rank < start {
+			return c.mean
+		}
+	}
+	return t.max
+}
@@ -0,0 +1,193 @@
+package aggregator
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+)
+
+// topKCapacityFactor Space-Saving 保留的计数器个数与 k 的倍数，不同值的个数不超过计数器个数时结果是精确的
+const topKCapacityFactor = 10
+
+// topKMinCapacity Space-Saving 保留的最少计数器个数
+const topKMinCapacity = 100
+
+// topKCounter Space-Saving 中一个值的计数器，count 可能高估该值的实际计数
+type topKCounter struct {
+	key   interface{}
+	value interface{}
+	count float64
+	// seq 值开始计数的顺序，计数相同时先出现的值排在前面
+	seq   uint64
+	index int
+}
+
+// topKHeap 按计数排序的小顶堆，堆顶为下一个被替换的计数器
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int { return len(h) }
+func (h topKHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq > h[j].seq
+}
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *topKHeap) Push(x interface{}) {
+	c := x.(*topKCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// spaceSaving 估算出现次数或权重之和最大的值的 Space-Saving 草图，最多保留 capacity 个计数器。
+// 计数器已满时新的值替换计数最小的计数器，并在其计数的基础上累加
+type spaceSaving struct {
+	capacity int
+	counters map[interface{}]*topKCounter
+	heap     topKHeap
+	seq      uint64
+}
+
+func (s *spaceSaving) add(v interface{}, weight float64) {
+	if s.counters == nil {
+		s.counters = make(map[interface{}]*topKCounter)
+	}
+	key := distinctKey(v)
+	if c, ok := s.counters[key]; ok {
+		c.count += weight
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	s.seq++
+	if len(s.heap) < s.capacity {
+		c := &topKCounter{key: key, value: v, count: weight, seq: s.seq}
+		heap.Push(&s.heap, c)
+		s.counters[key] = c
+		return
+	}
+	c := s.heap[0]
+	delete(s.counters, c.key)
+	c.key, c.value, c.seq = key, v, s.seq
+	c.count += weight
+	s.counters[key] = c
+	heap.Fix(&s.heap, 0)
+}
+
+// merge 按开始计数的顺序把另一个草图的计数器并入当前草图，不同值的个数不超过计数器个数时结果是精确的
+func (s *spaceSaving) merge(other *spaceSaving) {
+	counters := make([]*topKCounter, len(other.heap))
+	copy(counters, other.heap)
+	sort.Slice(counters, func(i, j int) bool { return counters[i].seq < counters[j].seq })
+	for _, c := range counters {
+		s.add(c.value, c.count)
+	}
+}
+
+// top 返回计数最大的 k 个值，按计数从大到小排列，结果中计数的名称为 countName
+func (s *spaceSaving) top(k int, countName string) []map[string]interface{} {
+	counters := make([]*topKCounter, len(s.heap))
+	copy(counters, s.heap)
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].count != counters[j].count {
+			return counters[i].count > counters[j].count
+		}
+		return counters[i].seq < counters[j].seq
+	})
+	if len(counters) > k {
+		counters = counters[:k]
+	}
+	result := make([]map[string]interface{}, len(counters))
+	for i, c := range counters {
+		result[i] = map[string]interface{}{"value": c.value, countName: c.count}
+	}
+	return result
+}
+
+// newSpaceSaving 创建可以估算前 k 个值的 Space-Saving 草图
+func newSpaceSaving(k int) spaceSaving {
+	capacity := k * topKCapacityFactor
+	if capacity < topKMinCapacity {
+		capacity = topKMinCapacity
+	}
+	return spaceSaving{capacity: capacity}
+}
+
+// TopKAggregator 出现次数最多的 k 个值，结果为按次数从大到小排列的 []map[string]interface{}，
+// 每项包含 value 和 count。使用 Space-Saving 算法，内存占用与 k 成正比，
+// 不同值的个数超过 10*k 时次数为估算值
+type TopKAggregator struct {
+	k      int
+	sketch spaceSaving
+}
+
+// NewTopKAggregator 创建 Top-K 聚合器，k 必须大于 0
+func NewTopKAggregator(k int) (*TopKAggregator, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be greater than 0 but got %d", k)
+	}
+	return &TopKAggregator{k: k, sketch: newSpaceSaving(k)}, nil
+}
+
+func (t *TopKAggregator) New() AggregatorFunction {
+	return &TopKAggregator{k: t.k, sketch: newSpaceSaving(t.k)}
+}
+
+func (t *TopKAggregator) Add(v interface{}) {
+	t.sketch.add(v, 1)
+}
+
+func (t *TopKAggregator) Result() interface{} {
+	return t.sketch.top(t.k, "count")
+}
+
+func (t *TopKAggregator) Merge(other AggregatorFunction) {
+	t.sketch.merge(&other.(*TopKAggregator).sketch)
+}
+
+// TopKByAggregator 权重之和最大的 k 个值，如 topk_by(deviceId, bytes, 5)，结果为按权重之和从大到小排列的
+// []map[string]interface{}，每项包含 value 和 weight。权重不大于 0 的数据不参与聚合
+type TopKByAggregator struct {
+	k      int
+	sketch spaceSaving
+}
+
+// NewTopKByAggregator 创建按权重的 Top-K 聚合器，k 必须大于 0
+func NewTopKByAggregator(k int) (*TopKByAggregator, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("k must be greater than 0 but got %d", k)
+	}
+	return &TopKByAggregator{k: k, sketch: newSpaceSaving(k)}, nil
+}
+
+func (t *TopKByAggregator) New() AggregatorFunction {
+	return &TopKByAggregator{k: t.k, sketch: newSpaceSaving(t.k)}
+}
+
+// Add 按权重 1 加入值
+func (t *TopKByAggregator) Add(v interface{}) {
+	t.sketch.add(v, 1)
+}
+
+// AddArgs 加入值和权重，args[1] 为 float64 类型的权重
+func (t *TopKByAggregator) AddArgs(args []interface{}) {
+	if weight, ok := args[1].(float64); ok && weight > 0 {
+		t.sketch.add(args[0], weight)
+	}
+}
+
+func (t *TopKByAggregator) Result() interface{} {
+	return t.sketch.top(t.k, "weight")
+}
+
+func (t *TopKByAggregator) Merge(other AggregatorFunction) {
+	t.sketch.merge(&other.(*TopKByAggregator).sketch)
+}
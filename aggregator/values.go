@@ -0,0 +1,181 @@
+package aggregator
+
+import "time"
+
+// EventTimeKey 上下文中数据时间的键，窗口在加入每条数据前通过 Put 设置，
+// 事件时间语义下为数据的事件时间，处理时间语义下为数据进入窗口的时间
+const EventTimeKey = "event_time"
+
+// TimedAggregator 按数据时间聚合的聚合器，如 first_value、last_value。
+// GroupAggregator 通过 AddAt 传入值和上下文中的数据时间，上下文中没有时间时传入零值，此时按数据到达的顺序聚合
+type TimedAggregator interface {
+	AggregatorFunction
+	AddAt(value interface{}, ts time.Time)
+}
+
+// ValueChecker 在加入值之前检查值的聚合器，如 min、max 只能比较同类的值。
+// 检查失败时 GroupAggregator.Add 返回 FieldError，该值不参与聚合
+type ValueChecker interface {
+	CheckValue(value interface{}) error
+}
+
+// FirstValueAggregator 时间最早的一条数据的值，时间相同时取先到达的数据
+type FirstValueAggregator struct {
+	value interface{}
+	ts    time.Time
+	set   bool
+}
+
+func (f *FirstValueAggregator) New() AggregatorFunction {
+	return &FirstValueAggregator{}
+}
+
+func (f *FirstValueAggregator) Add(v interface{}) {
+	f.AddAt(v, time.Time{})
+}
+
+func (f *FirstValueAggregator) AddAt(v interface{}, ts time.Time) {
+	if !f.set || ts.Before(f.ts) {
+		f.value, f.ts, f.set = v, ts, true
+	}
+}
+
+func (f *FirstValueAggregator) Result() interface{} {
+	return f.value
+}
+
+func (f *FirstValueAggregator) Merge(other AggregatorFunction) {
+	if o := other.(*FirstValueAggregator); o.set {
+		f.AddAt(o.value, o.ts)
+	}
+}
+
+// LastValueAggregator 时间最晚的一条数据的值，时间相同时取后到达的数据
+type LastValueAggregator struct {
+	value interface{}
+	ts    time.Time
+	set   bool
+}
+
+func (l *LastValueAggregator) New() AggregatorFunction {
+	return &LastValueAggregator{}
+}
+
+func (l *LastValueAggregator) Add(v interface{}) {
+	l.AddAt(v, time.Time{})
+}
+
+func (l *LastValueAggregator) AddAt(v interface{}, ts time.Time) {
+	if !l.set || !ts.Before(l.ts) {
+		l.value, l.ts, l.set = v, ts, true
+	}
+}
+
+func (l *LastValueAggregator) Result() interface{} {
+	return l.value
+}
+
+func (l *LastValueAggregator) Merge(other AggregatorFunction) {
+	if o := other.(*LastValueAggregator); o.set {
+		l.AddAt(o.value, o.ts)
+	}
+}
+
+// CollectListAggregator 按数据到达的顺序收集所有的值，结果为 []interface{}
+type CollectListAggregator struct {
+	values []interface{}
+}
+
+func (c *CollectListAggregator) New() AggregatorFunction {
+	return &CollectListAggregator{}
+}
+
+func (c *CollectListAggregator) Add(v interface{}) {
+	c.values = append(c.values, v)
+}
+
+func (c *CollectListAggregator) Result() interface{} {
+	return c.values
+}
+
+func (c *CollectListAggregator) Merge(other AggregatorFunction) {
+	c.values = append(c.values, other.(*CollectListAggregator).values...)
+}
+
+// CollectSetAggregator 按首次出现的顺序收集不同的值，结果为 []interface{}，数值按数值去重
+type CollectSetAggregator struct {
+	seen   map[interface{}]struct{}
+	values []interface{}
+}
+
+func (c *CollectSetAggregator) New() AggregatorFunction {
+	return &CollectSetAggregator{}
+}
+
+func (c *CollectSetAggregator) Add(v interface{}) {
+	if c.seen == nil {
+		c.seen = make(map[interface{}]struct{})
+	}
+	key := distinctKey(v)
+	if _, ok := c.seen[key]; !ok {
+		c.seen[key] = struct{}{}
+		c.values = append(c.values, v)
+	}
+}
+
+func (c *CollectSetAggregator) Result() interface{} {
+	return c.values
+}
+
+func (c *CollectSetAggregator) Merge(other AggregatorFunction) {
+	for _, v := range other.(*CollectSetAggregator).values {
+		c.Add(v)
+	}
+}
+
+// ModeAggregator 出现次数最多的值，次数相同时取先出现的值，数值按数值比较
+type ModeAggregator struct {
+	counts map[interface{}]int
+	// values 按首次出现的顺序保存每个不同的值
+	values []interface{}
+}
+
+func (m *ModeAggregator) New() AggregatorFunction {
+	return &ModeAggregator{}
+}
+
+func (m *ModeAggregator) Add(v interface{}) {
+	if m.counts == nil {
+		m.counts = make(map[interface{}]int)
+	}
+	key := distinctKey(v)
+	if _, ok := m.counts[key]; !ok {
+		m.values = append(m.values, v)
+	}
+	m.counts[key]++
+}
+
+func (m *ModeAggregator) Result() interface{} {
+	var mode interface{}
+	best := 0
+	for _, v := range m.values {
+		if n := m.counts[distinctKey(v)]; n > best {
+			mode, best = v, n
+		}
+	}
+	return mode
+}
+
+func (m *ModeAggregator) Merge(other AggregatorFunction) {
+	o := other.(*ModeAggregator)
+	for _, v := range o.values {
+		key := distinctKey(v)
+		if m.counts == nil {
+			m.counts = make(map[interface{}]int)
+		}
+		if _, ok := m.counts[key]; !ok {
+			m.values = append(m.values, v)
+		}
+		m.counts[key] += o.counts[key]
+	}
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2025 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamsql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+// TagName 结构体字段标签的名称，标签值为输出字段的名称或别名，"-" 表示忽略该字段
+const TagName = "streamsql"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Subscribe 订阅查询结果，每个结果解码为 T 后调用 fn。
+// 窗口查询的结果为分组结果的列表，T 通常为 []MyStruct；无窗口查询的结果为单条数据，T 通常为 MyStruct。
+// 解码失败的结果作为 sink 阶段的错误报告，不调用 fn。必须在 Execute 之后调用
+func Subscribe[T any](s *Streamsql, fn func(T)) error {
+	if s.stream == nil {
+		return errors.New("streamsql: Subscribe must be called after Execute")
+	}
+	s.stream.AddSinkE(func(result interface{}) error {
+		var v T
+		if err := DecodeResult(result, &v); err != nil {
+			return err
+		}
+		fn(v)
+		return nil
+	})
+	return nil
+}
+
+// DecodeResult 将查询结果解码到 out 指向的值中，out 可以指向结构体、结构体切片、map 或 interface{}。
+// 结构体字段按 streamsql 标签匹配输出字段的名称或别名，没有标签时按字段名匹配，不区分大小写。
+// 数值类型之间按需转换，有小数部分的浮点数、负数解码为无符号整数和超出目标类型范围的值返回错误，
+// 结果中没有的字段和 nil 值保持零值
+func DecodeResult(result interface{}, out interface{}) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode result: out must be a non-nil pointer but got %T", out)
+	}
+	return decodeValue(rv.Elem(), result, "")
+}
+
+// decodeValue 将 src 解码到 dst，path 为出错时提示的字段路径
+func decodeValue(dst reflect.Value, src interface{}, path string) error {
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Type().AssignableTo(dst.Type()) {
+		dst.Set(sv)
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Ptr:
+		elem := reflect.New(dst.Type().Elem())
+		if err := decodeValue(elem.Elem(), src, path); err != nil {
+			return err
+		}
+		dst.Set(elem)
+		return nil
+	case reflect.Slice:
+		if sv.Kind() != reflect.Slice && sv.Kind() != reflect.Array {
+			break
+		}
+		slice := reflect.MakeSlice(dst.Type(), sv.Len(), sv.Len())
+		for i := 0; i < sv.Len(); i++ {
+			if err := decodeValue(slice.Index(i), sv.Index(i).Interface(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+		return nil
+	case reflect.Map:
+		row, ok := src.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			break
+		}
+		m := reflect.MakeMapWithSize(dst.Type(), len(row))
+		for key, val := range row {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(elem, val, joinPath(path, key)); err != nil {
+				return err
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(m)
+		return nil
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			t, err := cast.ToTimeE(src)
+			if err != nil {
+				return decodeError(path, err)
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+		row, ok := src.(map[string]interface{})
+		if !ok {
+			break
+		}
+		return decodeStruct(dst, row, path)
+	case reflect.String:
+		s, err := cast.ToStringE(src)
+		if err != nil {
+			return decodeError(path, err)
+		}
+		dst.SetString(s)
+		return nil
+	case reflect.Bool:
+		b, err := cast.ToBoolE(src)
+		if err != nil {
+			return decodeError(path, err)
+		}
+		dst.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := toInt64(src)
+		if err != nil {
+			return decodeError(path, err)
+		}
+		if dst.OverflowInt(i) {
+			return decodeError(path, fmt.Errorf("value %v overflows %s", src, dst.Type()))
+		}
+		dst.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := toUint64(src)
+		if err != nil {
+			return decodeError(path, err)
+		}
+		if dst.OverflowUint(u) {
+			return decodeError(path, fmt.Errorf("value %v overflows %s", src, dst.Type()))
+		}
+		dst.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := cast.ToFloat64E(src)
+		if err != nil {
+			return decodeError(path, err)
+		}
+		dst.SetFloat(f)
+		return nil
+	}
+	if sv.Type().ConvertibleTo(dst.Type()) {
+		dst.Set(sv.Convert(dst.Type()))
+		return nil
+	}
+	return decodeError(path, fmt.Errorf("cannot decode %T into %s", src, dst.Type()))
+}
+
+// toInt64 将数值或整数字符串转换为 int64，浮点数有小数部分或超出 int64 的范围时返回错误，不截断
+func toInt64(src interface{}) (int64, error) {
+	switch v := reflect.ValueOf(src); v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, fmt.Errorf("cannot convert %v to an integer without losing precision", src)
+		}
+		return int64(f), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return 0, fmt.Errorf("value %v overflows int64", src)
+		}
+		return int64(v.Uint()), nil
+	}
+	return cast.ToInt64E(src)
+}
+
+// toUint64 将非负的数值或整数字符串转换为 uint64，负数、有小数部分或超出 uint64 范围的浮点数返回错误
+func toUint64(src interface{}) (uint64, error) {
+	switch v := reflect.ValueOf(src); v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f != math.Trunc(f) || f >= math.MaxUint64 {
+			return 0, fmt.Errorf("cannot convert %v to an integer without losing precision", src)
+		}
+		if f < 0 {
+			return 0, fmt.Errorf("cannot decode negative value %v into an unsigned integer", src)
+		}
+		return uint64(f), nil
+	case reflect.String:
+		u, err := strconv.ParseUint(v.String(), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return u, nil
+	}
+	i, err := cast.ToInt64E(src)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("cannot decode negative value %v into an unsigned integer", src)
+	}
+	return uint64(i), nil
+}
+
+// decodeStruct 按字段标签将一条结果解码到结构体
+func decodeStruct(dst reflect.Value, row map[string]interface{}, path string) error {
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup(TagName); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag = strings.Split(tag, ",")[0]; tag != "" {
+				name = tag
+			}
+		}
+		val, ok := lookupField(row, name)
+		if !ok {
+			continue
+		}
+		if err := decodeValue(dst.Field(i), val, joinPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupField 按名称查找结果中的字段，没有完全匹配时不区分大小写匹配
+func lookupField(row map[string]interface{}, name string) (interface{}, bool) {
+	if val, ok := row[name]; ok {
+		return val, true
+	}
+	for key, val := range row {
+		if strings.EqualFold(key, name) {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// joinPath 拼接出错时提示的字段路径
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func decodeError(path string, err error) error {
+	if path == "" {
+		return fmt.Errorf("decode result: %w", err)
+	}
+	return fmt.Errorf("decode result field %s: %w", path, err)
+}
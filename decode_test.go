@@ -0,0 +1,163 @@
+package streamsql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rulego/streamsql/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type deviceStats struct {
+	DeviceID int     `streamsql:"deviceId"`
+	AvgTemp  float64 `streamsql:"avg_temp"`
+	Count    int     `streamsql:"cnt"`
+	Start    time.Time
+	Note     *string
+	Ignored  string `streamsql:"-"`
+}
+
+func TestDecodeResult(t *testing.T) {
+	start := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	note := "ok"
+	result := []map[string]interface{}{
+		{"deviceId": 1, "avg_temp": 25.5, "cnt": 2.0, "start": start, "note": note, "Ignored": "x"},
+		{"deviceId": int64(2), "avg_temp": 30, "cnt": nil},
+	}
+
+	var stats []deviceStats
+	require.NoError(t, DecodeResult(result, &stats))
+	assert.Equal(t, []deviceStats{
+		{DeviceID: 1, AvgTemp: 25.5, Count: 2, Start: start, Note: &note},
+		{DeviceID: 2, AvgTemp: 30},
+	}, stats)
+
+	var ptrs []*deviceStats
+	require.NoError(t, DecodeResult(result, &ptrs))
+	require.Len(t, ptrs, 2)
+	assert.Equal(t, 2, ptrs[1].DeviceID)
+
+	var row deviceStats
+	require.NoError(t, DecodeResult(result[0], &row))
+	assert.Equal(t, 1, row.DeviceID)
+
+	var values map[string]float64
+	require.NoError(t, DecodeResult(map[string]interface{}{"a": 1, "b": "2.5"}, &values))
+	assert.Equal(t, map[string]float64{"a": 1, "b": 2.5}, values)
+
+	var raw interface{}
+	require.NoError(t, DecodeResult(result, &raw))
+	assert.Equal(t, result, raw)
+
+	assert.EqualError(t, DecodeResult(result, stats), "decode result: out must be a non-nil pointer but got []streamsql.deviceStats")
+	err := DecodeResult([]map[string]interface{}{{"avg_temp": "hot"}}, &stats)
+	assert.ErrorContains(t, err, "decode result field [0].avg_temp")
+	err = DecodeResult("text", &row)
+	assert.EqualError(t, err, "decode result: cannot decode string into streamsql.deviceStats")
+}
+
+func TestDecodeResultIntegers(t *testing.T) {
+	var u uint32
+	require.NoError(t, DecodeResult(7.0, &u))
+	assert.Equal(t, uint32(7), u)
+	require.NoError(t, DecodeResult("42", &u))
+	assert.Equal(t, uint32(42), u)
+	var i int8
+	require.NoError(t, DecodeResult(-3.0, &i))
+	assert.Equal(t, int8(-3), i)
+
+	// 负数不能解码为无符号整数，浮点数不截断小数部分，超出目标类型范围的值返回错误
+	assert.EqualError(t, DecodeResult(-1, &u), "decode result: cannot decode negative value -1 into an unsigned integer")
+	assert.EqualError(t, DecodeResult(-2.0, &u), "decode result: cannot decode negative value -2 into an unsigned integer")
+	assert.EqualError(t, DecodeResult(3.7, &u), "decode result: cannot convert 3.7 to an integer without losing precision")
+	assert.EqualError(t, DecodeResult(3.7, &i), "decode result: cannot convert 3.7 to an integer without losing precision")
+	assert.EqualError(t, DecodeResult(300, &i), "decode result: value 300 overflows int8")
+	assert.EqualError(t, DecodeResult(uint64(1)<<40, &u), "decode result: value 1099511627776 overflows uint32")
+	assert.Error(t, DecodeResult("-5", &u))
+
+	var stats []deviceStats
+	err := DecodeResult([]map[string]interface{}{{"cnt": 2.5}}, &stats)
+	assert.EqualError(t, err, "decode result field [0].cnt: cannot convert 2.5 to an integer without losing precision")
+}
+
+func TestSubscribe(t *testing.T) {
+	type stats struct {
+		DeviceID int     `streamsql:"deviceId"`
+		MaxTemp  float64 `streamsql:"max_temp"`
+	}
+	errs := make(chan *stream.StreamError, 10)
+	streamsql := New(WithErrorHandler(func(err *stream.StreamError) {
+		errs <- err
+	}))
+	assert.Error(t, Subscribe(streamsql, func([]stats) {}))
+	require.NoError(t, streamsql.Execute("SELECT deviceId, max(temperature) AS max_temp FROM stream GROUP BY deviceId, TumblingWindow('1m')"))
+
+	var mu sync.Mutex
+	var got []stats
+	require.NoError(t, Subscribe(streamsql, func(rows []stats) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, rows...)
+	}))
+	// 无法解码的结果作为 sink 错误报告
+	require.NoError(t, Subscribe(streamsql, func(rows []struct {
+		MaxTemp []int `streamsql:"max_temp"`
+	}) {
+		t.Error("unexpected decoded result")
+	}))
+
+	// 分组字段保留原始的 int 类型
+	streamsql.AddData(map[string]interface{}{"deviceId": 7, "temperature": 25.0})
+	streamsql.AddData(map[string]interface{}{"deviceId": 7, "temperature": 30.0})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+
+	mu.Lock()
+	assert.Equal(t, []stats{{DeviceID: 7, MaxTemp: 30}}, got)
+	mu.Unlock()
+	for result := range streamsql.GetResult() {
+		rows := result.([]map[string]interface{})
+		require.Len(t, rows, 1)
+		assert.Equal(t, 7, rows[0]["deviceId"])
+	}
+	select {
+	case err := <-errs:
+		assert.Equal(t, stream.StageSink, err.Stage)
+		assert.ErrorContains(t, err, "decode result field [0].max_temp: cannot decode float64 into []int")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for errors")
+	}
+}
+
+func TestSubscribeWithoutReadingResults(t *testing.T) {
+	type stats struct {
+		Count int `streamsql:"cnt"`
+	}
+	streamsql := New()
+	require.NoError(t, streamsql.Execute("SELECT count(*) AS cnt FROM stream GROUP BY TumblingWindow('1s') WITH (TIMESTAMP='ts', EVENTTIME=true)"))
+	var mu sync.Mutex
+	windows := 0
+	require.NoError(t, Subscribe(streamsql, func(rows []stats) {
+		mu.Lock()
+		defer mu.Unlock()
+		windows++
+	}))
+
+	// 只订阅结果、从不读取 GetResult() 时，结果通道写满后窗口仍然继续触发
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, streamsql.AddData(map[string]interface{}{"ts": baseTime.Add(time.Duration(i) * time.Second)}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 30, windows)
+	assert.Len(t, streamsql.GetResult(), stream.DefaultResultBufferSize)
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2025 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamsql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/rulego/streamsql/model"
+	"github.com/rulego/streamsql/rsql"
+	"github.com/rulego/streamsql/stream"
+)
+
+// Engine 多查询引擎，同时运行多个查询，通过 Publish 按流名称把数据路由到 FROM 子句匹配的所有查询
+type Engine struct {
+	opts []Option
+	// mu 保护 queries、order、routes 和 schemas
+	mu      sync.RWMutex
+	queries map[string]*engineQuery
+	// order 查询的添加顺序
+	order []string
+	// routes 流名称到运行中的查询的路由表，查询启停时重建
+	routes map[string][]*Streamsql
+	// schemas 通过 CreateStream 声明的流结构，按流名称索引
+	schemas map[string]*model.Schema
+}
+
+// engineQuery 引擎中的查询
+type engineQuery struct {
+	sql string
+	// ssql 最近一次启动的查询实例，停止后保留以便读取剩余结果
+	ssql    *Streamsql
+	running bool
+}
+
+// QueryInfo 查询的描述信息
+type QueryInfo struct {
+	ID string
+	// SQL 查询语句
+	SQL string
+	// Source FROM 子句中的流名称
+	Source string
+	// Running 查询是否正在运行
+	Running bool
+}
+
+// NewEngine 创建多查询引擎，opts 应用于引擎中的每个查询
+func NewEngine(opts ...Option) *Engine {
+	return &Engine{
+		opts:    opts,
+		queries: make(map[string]*engineQuery),
+		routes:  make(map[string][]*Streamsql),
+		schemas: make(map[string]*model.Schema),
+	}
+}
+
+// CreateStream 执行 CREATE STREAM 语句声明流结构。
+// 之后添加的 FROM 该流的查询按流结构检查字段类型，发布到该流的数据按流结构校验和转换
+func (e *Engine) CreateStream(sql string) error {
+	create, err := rsql.NewParser(sql).ParseCreateStream()
+	if err != nil {
+		return err
+	}
+	schema, err := create.ToSchema()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.schemas[schema.Name]; ok {
+		return fmt.Errorf("stream %s already exists", schema.Name)
+	}
+	e.schemas[schema.Name] = schema
+	return nil
+}
+
+// Schema 返回通过 CreateStream 声明的流结构
+func (e *Engine) Schema(streamName string) (*model.Schema, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	schema, ok := e.schemas[streamName]
+	return schema, ok
+}
+
+// newQuery 创建使用引擎配置项和流结构的查询实例，调用方需持有写锁
+func (e *Engine) newQuery() *Streamsql {
+	ssql := New(e.opts...)
+	ssql.schemas = make(map[string]*model.Schema, len(e.schemas))
+	for name, schema := range e.schemas {
+		ssql.schemas[name] = schema
+	}
+	return ssql
+}
+
+// AddQuery 添加并启动查询，id 在引擎中必须唯一
+func (e *Engine) AddQuery(id, sql string) error {
+	if id == "" {
+		return errors.New("query id is empty")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.queries[id]; ok {
+		return fmt.Errorf("query %s already exists", id)
+	}
+	ssql := e.newQuery()
+	if err := ssql.Execute(sql); err != nil {
+		return fmt.Errorf("query %s: %w", id, err)
+	}
+	e.queries[id] = &engineQuery{sql: sql, ssql: ssql, running: true}
+	e.order = append(e.order, id)
+	e.rebuildRoutes()
+	return nil
+}
+
+// StartQuery 重新启动已停止的查询。
+// 查询以新的实例运行，需要通过 Query 重新获取结果通道
+func (e *Engine) StartQuery(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, ok := e.queries[id]
+	if !ok {
+		return fmt.Errorf("query %s not found", id)
+	}
+	if q.running {
+		return nil
+	}
+	ssql := e.newQuery()
+	if err := ssql.Execute(q.sql); err != nil {
+		return fmt.Errorf("query %s: %w", id, err)
+	}
+	q.ssql = ssql
+	q.running = true
+	e.rebuildRoutes()
+	return nil
+}
+
+// StopQuery 立即停止查询，查询不再接收数据但仍保留在引擎中，可以通过 StartQuery 重新启动
+func (e *Engine) StopQuery(id string) error {
+	ssql, err := e.detach(id, false)
+	if err != nil {
+		return err
+	}
+	if ssql != nil {
+		ssql.Stop()
+	}
+	return nil
+}
+
+// RemoveQuery 立即停止并移除查询
+func (e *Engine) RemoveQuery(id string) error {
+	ssql, err := e.detach(id, true)
+	if err != nil {
+		return err
+	}
+	if ssql != nil {
+		ssql.Stop()
+	}
+	return nil
+}
+
+// detach 把查询从路由表中移除，remove 为 true 时同时从引擎中移除，返回需要停止的查询实例。
+// 停止查询在锁外进行，避免阻塞其他查询的数据发布
+func (e *Engine) detach(id string, remove bool) (*Streamsql, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	q, ok := e.queries[id]
+	if !ok {
+		return nil, fmt.Errorf("query %s not found", id)
+	}
+	var ssql *Streamsql
+	if q.running {
+		ssql = q.ssql
+		q.running = false
+	}
+	if remove {
+		delete(e.queries, id)
+		for i, item := range e.order {
+			if item == id {
+				e.order = append(e.order[:i], e.order[i+1:]...)
+				break
+			}
+		}
+	}
+	e.rebuildRoutes()
+	return ssql, nil
+}
+
+// Query 返回查询的实例，用于获取结果通道和错误通道
+func (e *Engine) Query(id string) (*Streamsql, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	q, ok := e.queries[id]
+	if !ok {
+		return nil, false
+	}
+	return q.ssql, true
+}
+
+// Queries 按添加顺序列出引擎中的所有查询
+func (e *Engine) Queries() []QueryInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	infos := make([]QueryInfo, 0, len(e.order))
+	for _, id := range e.order {
+		q := e.queries[id]
+		infos = append(infos, QueryInfo{
+			ID:      id,
+			SQL:     q.sql,
+			Source:  q.ssql.Source(),
+			Running: q.running,
+		})
+	}
+	return infos
+}
+
+// Publish 发布数据到名为 streamName 的流，数据被添加到所有 FROM 子句为该流的运行中的查询。
+// 流声明了结构时，数据先按流结构校验和转换，不符合流结构的数据返回错误且不发送给任何查询。
+// 没有匹配的查询时数据被丢弃；某个查询拒绝数据时仍会发送给其他查询，并返回第一个错误。
+// Publish 不会被单个查询阻塞：查询的数据通道已满时，OverflowDropOldest 策略的查询丢弃最早的数据，
+// 其他策略的查询拒绝这条数据，并作为该查询 ingest 阶段的错误报告到它的错误通道和错误处理函数，Publish 返回 stream.ErrBufferFull
+func (e *Engine) Publish(streamName string, data interface{}) error {
+	e.mu.RLock()
+	targets := e.routes[streamName]
+	schema := e.schemas[streamName]
+	e.mu.RUnlock()
+	if schema != nil {
+		row, err := schema.Coerce(data)
+		if err != nil {
+			return err
+		}
+		data = row
+	}
+	var firstErr error
+	for _, ssql := range targets {
+		// 数据已经按流结构转换，直接添加到查询的流中
+		err := ssql.stream.OfferData(data)
+		// 查询在发布过程中被停止
+		if err == nil || errors.Is(err, stream.ErrStreamClosed) {
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stop 立即停止引擎中的所有查询
+func (e *Engine) Stop() {
+	for _, ssql := range e.detachAll() {
+		ssql.Stop()
+	}
+}
+
+// Close 优雅地停止引擎中的所有查询，处理完已接收的数据并输出所有未触发窗口的结果。
+// ctx 结束时放弃剩余的处理并返回 ctx 的错误
+func (e *Engine) Close(ctx context.Context) error {
+	var firstErr error
+	for _, ssql := range e.detachAll() {
+		if err := ssql.Close(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// detachAll 把所有查询标记为已停止并清空路由表，返回需要停止的查询实例
+func (e *Engine) detachAll() []*Streamsql {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var running []*Streamsql
+	for _, id := range e.order {
+		q := e.queries[id]
+		if q.running {
+			running = append(running, q.ssql)
+			q.running = false
+		}
+	}
+	e.rebuildRoutes()
+	return running
+}
+
+// rebuildRoutes 根据运行中的查询重建路由表，调用方需持有写锁。
+// 路由表整体替换，Publish 持有的旧路由表不受影响
+func (e *Engine) rebuildRoutes() {
+	routes := make(map[string][]*Streamsql)
+	for _, id := range e.order {
+		q := e.queries[id]
+		if q.running {
+			routes[q.ssql.Source()] = append(routes[q.ssql.Source()], q.ssql)
+		}
+	}
+	e.routes = routes
+}
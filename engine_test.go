@@ -0,0 +1,162 @@
+package streamsql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rulego/streamsql/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Stop()
+
+	require.NoError(t, engine.AddQuery("hot", "SELECT deviceId FROM sensors WHERE temperature > 40"))
+	require.NoError(t, engine.AddQuery("all", "SELECT deviceId, temperature FROM sensors"))
+	require.NoError(t, engine.AddQuery("alarm", "SELECT code FROM alarms"))
+
+	assert.EqualError(t, engine.AddQuery("hot", "SELECT deviceId FROM sensors"), "query hot already exists")
+	assert.Error(t, engine.AddQuery("bad", "SELECT FROM"))
+	assert.Equal(t, []QueryInfo{
+		{ID: "hot", SQL: "SELECT deviceId FROM sensors WHERE temperature > 40", Source: "sensors", Running: true},
+		{ID: "all", SQL: "SELECT deviceId, temperature FROM sensors", Source: "sensors", Running: true},
+		{ID: "alarm", SQL: "SELECT code FROM alarms", Source: "alarms", Running: true},
+	}, engine.Queries())
+
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"deviceId": "aa", "temperature": 45.0}))
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"deviceId": "bb", "temperature": 20.0}))
+	require.NoError(t, engine.Publish("alarms", map[string]interface{}{"code": 7}))
+	// 没有匹配的查询时数据被丢弃
+	require.NoError(t, engine.Publish("unknown", map[string]interface{}{"code": 8}))
+
+	receive := func(id string) interface{} {
+		ssql, ok := engine.Query(id)
+		require.True(t, ok)
+		select {
+		case result := <-ssql.GetResult():
+			return result
+		case <-time.After(time.Second):
+			t.Fatalf("Timeout waiting for results of %s", id)
+			return nil
+		}
+	}
+	assert.Equal(t, map[string]interface{}{"deviceId": "aa"}, receive("hot"))
+	assert.Equal(t, map[string]interface{}{"deviceId": "aa", "temperature": 45.0}, receive("all"))
+	assert.Equal(t, map[string]interface{}{"deviceId": "bb", "temperature": 20.0}, receive("all"))
+	assert.Equal(t, map[string]interface{}{"code": 7}, receive("alarm"))
+
+	// 停止的查询不再接收数据
+	require.NoError(t, engine.StopQuery("hot"))
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"deviceId": "cc", "temperature": 50.0}))
+	assert.Equal(t, map[string]interface{}{"deviceId": "cc", "temperature": 50.0}, receive("all"))
+	hot, _ := engine.Query("hot")
+	_, ok := <-hot.GetResult()
+	assert.False(t, ok)
+	assert.False(t, engine.Queries()[0].Running)
+
+	// 重新启动的查询以新的实例运行
+	require.NoError(t, engine.StartQuery("hot"))
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"deviceId": "dd", "temperature": 60.0}))
+	assert.Equal(t, map[string]interface{}{"deviceId": "dd"}, receive("hot"))
+	assert.Equal(t, map[string]interface{}{"deviceId": "dd", "temperature": 60.0}, receive("all"))
+
+	require.NoError(t, engine.RemoveQuery("all"))
+	_, ok = engine.Query("all")
+	assert.False(t, ok)
+	assert.Len(t, engine.Queries(), 2)
+	assert.EqualError(t, engine.StopQuery("all"), "query all not found")
+	assert.EqualError(t, engine.StartQuery("all"), "query all not found")
+}
+
+func TestEngineClose(t *testing.T) {
+	engine := NewEngine()
+	require.NoError(t, engine.AddQuery("max", "SELECT device, max(temperature) as max_temp FROM sensors group by device, TumblingWindow('1m')"))
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"device": "aa", "temperature": 25.0}))
+	require.NoError(t, engine.Publish("sensors", map[string]interface{}{"device": "aa", "temperature": 30.0}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, engine.Close(ctx))
+
+	// 关闭时输出未触发窗口的结果
+	ssql, _ := engine.Query("max")
+	var results []interface{}
+	for result := range ssql.GetResult() {
+		results = append(results, result)
+	}
+	assert.Equal(t, []interface{}{[]map[string]interface{}{{"device": "aa", "max_temp": 30.0}}}, results)
+	assert.False(t, engine.Queries()[0].Running)
+}
+
+func TestEngineSchema(t *testing.T) {
+	engine := NewEngine()
+	defer engine.Stop()
+	require.NoError(t, engine.CreateStream("CREATE STREAM sensors (deviceId STRING, temperature FLOAT)"))
+	assert.EqualError(t, engine.CreateStream("CREATE STREAM sensors (a INT)"), "stream sensors already exists")
+	assert.Error(t, engine.CreateStream("SELECT a FROM sensors"))
+	schema, ok := engine.Schema("sensors")
+	require.True(t, ok)
+	assert.Len(t, schema.Fields, 2)
+
+	assert.EqualError(t, engine.AddQuery("bad", "SELECT humidity FROM sensors"), "query bad: unknown field humidity in stream sensors")
+	require.NoError(t, engine.AddQuery("hot", "SELECT deviceId, temperature FROM sensors WHERE temperature > 40"))
+
+	require.NoError(t, engine.Publish("sensors", `{"deviceId":"aa","temperature":"45","extra":1}`))
+	assert.Error(t, engine.Publish("sensors", map[string]interface{}{"deviceId": "aa", "temperature": "hot"}))
+
+	ssql, _ := engine.Query("hot")
+	select {
+	case result := <-ssql.GetResult():
+		assert.Equal(t, map[string]interface{}{"deviceId": "aa", "temperature": 45.0}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestEnginePublishFullQuery(t *testing.T) {
+	errs := make(chan *stream.StreamError, 100)
+	engine := NewEngine(WithBufferSize(1, 1), WithErrorHandler(func(err *stream.StreamError) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}))
+	defer engine.Stop()
+	// slow 的结果无人读取，数据通道很快被填满；fast 过滤掉普通数据
+	require.NoError(t, engine.AddQuery("slow", "SELECT deviceId FROM sensors"))
+	require.NoError(t, engine.AddQuery("fast", "SELECT deviceId FROM sensors WHERE temperature > 100"))
+
+	var full int
+	for i := 0; i < 10; i++ {
+		err := engine.Publish("sensors", map[string]interface{}{"deviceId": "aa", "temperature": 20.0})
+		if errors.Is(err, stream.ErrBufferFull) {
+			full++
+		}
+	}
+	// 已满的查询不会阻塞 Publish，被拒绝的数据作为 ingest 阶段的错误报告
+	assert.Greater(t, full, 0)
+	select {
+	case err := <-errs:
+		assert.Equal(t, stream.StageIngest, err.Stage)
+		assert.ErrorIs(t, err, stream.ErrBufferFull)
+		assert.Equal(t, map[string]interface{}{"deviceId": "aa", "temperature": 20.0}, err.Data)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for errors")
+	}
+
+	// 其他查询继续接收数据
+	fast, _ := engine.Query("fast")
+	assert.Eventually(t, func() bool {
+		_ = engine.Publish("sensors", map[string]interface{}{"deviceId": "hot", "temperature": 150.0})
+		select {
+		case result := <-fast.GetResult():
+			return assert.Equal(t, map[string]interface{}{"deviceId": "hot"}, result)
+		default:
+			return false
+		}
+	}, time.Second, 10*time.Millisecond)
+}
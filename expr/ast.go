@@ -0,0 +1,374 @@
+// Package expr 定义了 SQL 表达式的语法树，以及基于语法树对数据求值的实现。
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operator 表达式运算符
+type Operator string
+
+const (
+	OpAdd Operator = "+"
+	OpSub Operator = "-"
+	OpMul Operator = "*"
+	OpDiv Operator = "/"
+	OpMod Operator = "%"
+	OpEq  Operator = "=="
+	OpNe  Operator = "!="
+	OpLt  Operator = "<"
+	OpLe  Operator = "<="
+	OpGt  Operator = ">"
+	OpGe  Operator = ">="
+	OpAnd Operator = "&&"
+	OpOr  Operator = "||"
+	OpNot Operator = "!"
+)
+
+// precedence 返回二元运算符的优先级，数值越大优先级越高
+func (op Operator) precedence() int {
+	switch op {
+	case OpOr:
+		return 1
+	case OpAnd:
+		return 2
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		return 3
+	case OpAdd, OpSub:
+		return 4
+	case OpMul, OpDiv, OpMod:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// 一元表达式、IN、IS NULL 等的优先级
+const (
+	compareLevel = 3
+	unaryLevel   = 6
+	primaryLevel = 7
+)
+
+// Expr 表达式语法树的节点
+type Expr interface {
+	// String 返回表达式的规范文本，相同语义的表达式得到相同的文本
+	String() string
+	exprNode()
+}
+
+// Literal 字面量：nil、bool、int64、float64 或 string
+type Literal struct {
+	Value interface{}
+}
+
+// Ident 标识符，即数据中的字段
+type Ident struct {
+	Name string
+}
+
+// BinaryExpr 二元运算表达式，如 a + b、a > b、a && b
+type BinaryExpr struct {
+	Op    Operator
+	Left  Expr
+	Right Expr
+}
+
+// UnaryExpr 一元运算表达式，如 -a、!a
+type UnaryExpr struct {
+	Op Operator
+	X  Expr
+}
+
+// CallExpr 函数调用，如 avg(temperature)、format_time(window_start(), 'YYYY-MM-dd')
+type CallExpr struct {
+	Name string
+	Args []Expr
+	// Distinct 参数前有 DISTINCT，如 count(DISTINCT deviceId)
+	Distinct bool
+	// Star 参数为 *，如 count(*)，此时 Args 为空
+	Star bool
+	// Over 窗口函数的 OVER 子句，没有时为 nil
+	Over *OverClause
+}
+
+// OverClause 窗口函数的 OVER 子句
+type OverClause struct {
+	PartitionBy []Expr
+	OrderBy     []OrderItem
+}
+
+// OrderItem 排序项
+type OrderItem struct {
+	Expr Expr
+	// Desc 是否降序
+	Desc bool
+}
+
+// When CASE 表达式中的 WHEN ... THEN ... 分支
+type When struct {
+	Cond   Expr
+	Result Expr
+}
+
+// CaseExpr CASE 表达式。
+// Operand 不为空时为简单 CASE（CASE x WHEN 1 THEN ...），否则为搜索 CASE（CASE WHEN x > 1 THEN ...）
+type CaseExpr struct {
+	Operand Expr
+	Whens   []When
+	Else    Expr
+}
+
+// InExpr IN 表达式，如 a IN (1, 2, 3)、a NOT IN ('x', 'y')
+type InExpr struct {
+	X    Expr
+	List []Expr
+	Not  bool
+}
+
+// IsNullExpr IS NULL 表达式，如 a IS NULL、a IS NOT NULL
+type IsNullExpr struct {
+	X   Expr
+	Not bool
+}
+
+func (*Literal) exprNode()    {}
+func (*Ident) exprNode()      {}
+func (*BinaryExpr) exprNode() {}
+func (*UnaryExpr) exprNode()  {}
+func (*CallExpr) exprNode()   {}
+func (*CaseExpr) exprNode()   {}
+func (*InExpr) exprNode()     {}
+func (*IsNullExpr) exprNode() {}
+
+func (l *Literal) String() string {
+	switch v := l.Value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (i *Ident) String() string {
+	return i.Name
+}
+
+func (b *BinaryExpr) String() string {
+	level := b.Op.precedence()
+	// 左结合：右侧同级的表达式需要加括号，如 a - (b - c)
+	return b.operand(b.Left, level) + " " + string(b.Op) + " " + b.operand(b.Right, level+1)
+}
+
+// operand 输出运算数，OR 中的 AND 表达式虽然优先级更高，为了易读也加上括号
+func (b *BinaryExpr) operand(e Expr, min int) string {
+	if child, ok := e.(*BinaryExpr); ok && b.Op == OpOr && child.Op == OpAnd {
+		return "(" + child.String() + ")"
+	}
+	return wrap(e, min)
+}
+
+func (u *UnaryExpr) String() string {
+	return string(u.Op) + wrap(u.X, unaryLevel)
+}
+
+func (c *CallExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString(c.Name)
+	sb.WriteString("(")
+	if c.Distinct {
+		sb.WriteString("DISTINCT ")
+	}
+	if c.Star {
+		sb.WriteString("*")
+	}
+	sb.WriteString(joinExprs(c.Args))
+	sb.WriteString(")")
+	if c.Over != nil {
+		sb.WriteString(" OVER (")
+		var parts []string
+		if len(c.Over.PartitionBy) > 0 {
+			parts = append(parts, "PARTITION BY "+joinExprs(c.Over.PartitionBy))
+		}
+		if len(c.Over.OrderBy) > 0 {
+			parts = append(parts, "ORDER BY "+joinOrderItems(c.Over.OrderBy))
+		}
+		sb.WriteString(strings.Join(parts, " "))
+		sb.WriteString(")")
+	}
+	return sb.String()
+}
+
+func (c *CaseExpr) String() string {
+	var sb strings.Builder
+	sb.WriteString("CASE")
+	if c.Operand != nil {
+		sb.WriteString(" " + c.Operand.String())
+	}
+	for _, w := range c.Whens {
+		sb.WriteString(" WHEN " + w.Cond.String() + " THEN " + w.Result.String())
+	}
+	if c.Else != nil {
+		sb.WriteString(" ELSE " + c.Else.String())
+	}
+	sb.WriteString(" END")
+	return sb.String()
+}
+
+func (in *InExpr) String() string {
+	op := " IN ("
+	if in.Not {
+		op = " NOT IN ("
+	}
+	return wrap(in.X, compareLevel+1) + op + joinExprs(in.List) + ")"
+}
+
+func (n *IsNullExpr) String() string {
+	if n.Not {
+		return wrap(n.X, compareLevel+1) + " IS NOT NULL"
+	}
+	return wrap(n.X, compareLevel+1) + " IS NULL"
+}
+
+// String 返回排序项的文本
+func (o OrderItem) String() string {
+	if o.Desc {
+		return o.Expr.String() + " DESC"
+	}
+	return o.Expr.String()
+}
+
+// level 返回表达式的优先级，用于输出文本时决定是否加括号
+func level(e Expr) int {
+	switch n := e.(type) {
+	case *BinaryExpr:
+		return n.Op.precedence()
+	case *UnaryExpr:
+		return unaryLevel
+	case *InExpr, *IsNullExpr:
+		return compareLevel
+	default:
+		return primaryLevel
+	}
+}
+
+// wrap 在表达式的优先级低于 min 时为其加上括号
+func wrap(e Expr, min int) string {
+	if level(e) < min {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+func joinExprs(exprs []Expr) string {
+	parts := make([]string, len(exprs))
+	for i, e := range exprs {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+func joinOrderItems(items []OrderItem) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = item.String()
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Walk 深度优先遍历表达式树，fn 返回 false 时不再遍历该节点的子节点
+func Walk(e Expr, fn func(Expr) bool) {
+	if e == nil || !fn(e) {
+		return
+	}
+	switch n := e.(type) {
+	case *BinaryExpr:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *UnaryExpr:
+		Walk(n.X, fn)
+	case *CallExpr:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	case *CaseExpr:
+		Walk(n.Operand, fn)
+		for _, w := range n.Whens {
+			Walk(w.Cond, fn)
+			Walk(w.Result, fn)
+		}
+		Walk(n.Else, fn)
+	case *InExpr:
+		Walk(n.X, fn)
+		for _, item := range n.List {
+			Walk(item, fn)
+		}
+	case *IsNullExpr:
+		Walk(n.X, fn)
+	}
+}
+
+// Rewrite 深度优先重写表达式树，fn 返回 true 时用返回的表达式替换该节点且不再遍历其子节点，
+// 否则继续重写子节点。只复制发生变化的节点，原表达式树保持不变
+func Rewrite(e Expr, fn func(Expr) (Expr, bool)) Expr {
+	if e == nil {
+		return nil
+	}
+	if r, ok := fn(e); ok {
+		return r
+	}
+	switch n := e.(type) {
+	case *BinaryExpr:
+		left, right := Rewrite(n.Left, fn), Rewrite(n.Right, fn)
+		if left != n.Left || right != n.Right {
+			return &BinaryExpr{Op: n.Op, Left: left, Right: right}
+		}
+	case *UnaryExpr:
+		if x := Rewrite(n.X, fn); x != n.X {
+			return &UnaryExpr{Op: n.Op, X: x}
+		}
+	case *CallExpr:
+		if args, changed := rewriteExprs(n.Args, fn); changed {
+			c := *n
+			c.Args = args
+			return &c
+		}
+	case *CaseExpr:
+		c := &CaseExpr{Operand: Rewrite(n.Operand, fn), Whens: make([]When, len(n.Whens)), Else: Rewrite(n.Else, fn)}
+		changed := c.Operand != n.Operand || c.Else != n.Else
+		for i, w := range n.Whens {
+			c.Whens[i] = When{Cond: Rewrite(w.Cond, fn), Result: Rewrite(w.Result, fn)}
+			changed = changed || c.Whens[i].Cond != w.Cond || c.Whens[i].Result != w.Result
+		}
+		if changed {
+			return c
+		}
+	case *InExpr:
+		x := Rewrite(n.X, fn)
+		list, changed := rewriteExprs(n.List, fn)
+		if changed || x != n.X {
+			return &InExpr{X: x, List: list, Not: n.Not}
+		}
+	case *IsNullExpr:
+		if x := Rewrite(n.X, fn); x != n.X {
+			return &IsNullExpr{X: x, Not: n.Not}
+		}
+	}
+	return e
+}
+
+func rewriteExprs(exprs []Expr, fn func(Expr) (Expr, bool)) ([]Expr, bool) {
+	result := make([]Expr, len(exprs))
+	changed := false
+	for i, e := range exprs {
+		result[i] = Rewrite(e, fn)
+		changed = changed || result[i] != e
+	}
+	return result, changed
+}
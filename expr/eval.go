@@ -0,0 +1,425 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rulego/streamsql/functions"
+)
+
+// GroupEnv 分组聚合结果的求值环境，包含分组字段和以聚合调用文本为键的聚合结果，如 "avg(temperature)"。
+// 只有在 GroupEnv 中求值时，函数调用才优先取环境中以调用文本为键的值
+type GroupEnv map[string]interface{}
+
+// Eval 以 data 作为数据环境对表达式求值，data 可以是 map 或结构体（及其指针）。
+// 字段不存在时取值为 nil，nil 参与运算和比较的结果为 nil，表示结果未知。
+// data 为 GroupEnv 且其中存在以函数调用文本为键的值时，函数调用直接取该值，
+// 否则调用 functions 包中注册的同名函数，原始数据中与调用文本同名的字段不影响函数调用的结果。
+func Eval(e Expr, data interface{}) (interface{}, error) {
+	switch n := e.(type) {
+	case *Literal:
+		return n.Value, nil
+	case *Ident:
+		v, _ := Lookup(data, n.Name)
+		return v, nil
+	case *BinaryExpr:
+		return evalBinary(n, data)
+	case *UnaryExpr:
+		return evalUnary(n, data)
+	case *CallExpr:
+		return evalCall(n, data)
+	case *CaseExpr:
+		return evalCase(n, data)
+	case *InExpr:
+		return evalIn(n, data)
+	case *IsNullExpr:
+		v, err := Eval(n.X, data)
+		if err != nil {
+			return nil, err
+		}
+		return (v == nil) != n.Not, nil
+	case nil:
+		return nil, fmt.Errorf("empty expression")
+	default:
+		return nil, fmt.Errorf("unsupported expression %T", e)
+	}
+}
+
+// Truthy 判断求值结果是否为 true，nil 和非布尔值均视为 false
+func Truthy(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
+
+// Lookup 从数据中获取字段的值，data 可以是 map 或结构体（及其指针）。
+// 数据中没有名为 name 的字段且 name 包含点号时，按点号分隔的路径逐级获取嵌套的 map 或结构体中的字段，如 payload.meta.site
+func Lookup(data interface{}, name string) (interface{}, bool) {
+	if v, ok := lookupField(data, name); ok || !strings.Contains(name, ".") {
+		return v, ok
+	}
+	v := data
+	for _, part := range strings.Split(name, ".") {
+		var ok bool
+		if v, ok = lookupField(v, part); !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// lookupField 从 map 或结构体（及其指针）中获取一个字段的值
+func lookupField(data interface{}, name string) (interface{}, bool) {
+	switch m := data.(type) {
+	case map[string]interface{}:
+		v, exists := m[name]
+		return v, exists
+	case GroupEnv:
+		v, exists := m[name]
+		return v, exists
+	case nil:
+		return nil, false
+	}
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		f := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !f.IsValid() {
+			return nil, false
+		}
+		return f.Interface(), true
+	case reflect.Struct:
+		f := v.FieldByName(name)
+		if !f.IsValid() || !f.CanInterface() {
+			return nil, false
+		}
+		return f.Interface(), true
+	}
+	return nil, false
+}
+
+func evalBinary(b *BinaryExpr, data interface{}) (interface{}, error) {
+	if b.Op == OpAnd || b.Op == OpOr {
+		return evalLogical(b, data)
+	}
+	l, err := Eval(b.Left, data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Eval(b.Right, data)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || r == nil {
+		return nil, nil
+	}
+	switch b.Op {
+	case OpEq:
+		return Equal(l, r), nil
+	case OpNe:
+		return !Equal(l, r), nil
+	case OpLt, OpLe, OpGt, OpGe:
+		c, err := Compare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch b.Op {
+		case OpLt:
+			return c < 0, nil
+		case OpLe:
+			return c <= 0, nil
+		case OpGt:
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	default:
+		return evalArith(b.Op, l, r)
+	}
+}
+
+// evalLogical 按三值逻辑计算 AND、OR，左侧已能确定结果时不再计算右侧
+func evalLogical(b *BinaryExpr, data interface{}) (interface{}, error) {
+	l, err := Eval(b.Left, data)
+	if err != nil {
+		return nil, err
+	}
+	lb, lKnown, err := toBool(l)
+	if err != nil {
+		return nil, err
+	}
+	// AND 左侧为 false 或 OR 左侧为 true 时短路
+	if lKnown && lb == (b.Op == OpOr) {
+		return lb, nil
+	}
+	r, err := Eval(b.Right, data)
+	if err != nil {
+		return nil, err
+	}
+	rb, rKnown, err := toBool(r)
+	if err != nil {
+		return nil, err
+	}
+	if rKnown && rb == (b.Op == OpOr) {
+		return rb, nil
+	}
+	if lKnown && rKnown {
+		return rb, nil
+	}
+	return nil, nil
+}
+
+func evalArith(op Operator, l, r interface{}) (interface{}, error) {
+	if op == OpAdd {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if lok && rok {
+			return ls + rs, nil
+		}
+	}
+	li, lInt := toInt64(l)
+	ri, rInt := toInt64(r)
+	if lInt && rInt && op != OpDiv {
+		switch op {
+		case OpAdd:
+			return li + ri, nil
+		case OpSub:
+			return li - ri, nil
+		case OpMul:
+			return li * ri, nil
+		case OpMod:
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := toFloat64(l)
+	rf, rok := toFloat64(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("invalid operation: %v %s %v (mismatched types %T and %T)", l, op, r, l, r)
+	}
+	switch op {
+	case OpAdd:
+		return lf + rf, nil
+	case OpSub:
+		return lf - rf, nil
+	case OpMul:
+		return lf * rf, nil
+	case OpDiv:
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return lf / rf, nil
+	case OpMod:
+		if rf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(lf, rf), nil
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", op)
+	}
+}
+
+func evalUnary(u *UnaryExpr, data interface{}) (interface{}, error) {
+	v, err := Eval(u.X, data)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	switch u.Op {
+	case OpNot:
+		b, _, err := toBool(v)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+	case OpSub:
+		if i, ok := toInt64(v); ok {
+			return -i, nil
+		}
+		if f, ok := toFloat64(v); ok {
+			return -f, nil
+		}
+		return nil, fmt.Errorf("invalid operation: -%v (%T)", v, v)
+	default:
+		return nil, fmt.Errorf("unsupported operator %s", u.Op)
+	}
+}
+
+func evalCall(c *CallExpr, data interface{}) (interface{}, error) {
+	if env, ok := data.(GroupEnv); ok {
+		if v, exists := env[c.String()]; exists {
+			return v, nil
+		}
+	}
+	fn, ok := functions.Get(c.Name)
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", c.Name)
+	}
+	args := make([]interface{}, len(c.Args))
+	for i, arg := range c.Args {
+		v, err := Eval(arg, data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return fn(args...)
+}
+
+func evalCase(c *CaseExpr, data interface{}) (interface{}, error) {
+	var operand interface{}
+	if c.Operand != nil {
+		v, err := Eval(c.Operand, data)
+		if err != nil {
+			return nil, err
+		}
+		operand = v
+	}
+	for _, w := range c.Whens {
+		cond, err := Eval(w.Cond, data)
+		if err != nil {
+			return nil, err
+		}
+		matched := Truthy(cond)
+		if c.Operand != nil {
+			matched = operand != nil && cond != nil && Equal(operand, cond)
+		}
+		if matched {
+			return Eval(w.Result, data)
+		}
+	}
+	if c.Else != nil {
+		return Eval(c.Else, data)
+	}
+	return nil, nil
+}
+
+func evalIn(in *InExpr, data interface{}) (interface{}, error) {
+	x, err := Eval(in.X, data)
+	if err != nil || x == nil {
+		return nil, err
+	}
+	for _, item := range in.List {
+		v, err := Eval(item, data)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil && Equal(x, v) {
+			return !in.Not, nil
+		}
+	}
+	return in.Not, nil
+}
+
+// Equal 判断两个值是否相等，数值按大小比较，不可比较的值视为不相等
+func Equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	c, err := Compare(a, b)
+	return err == nil && c == 0
+}
+
+// Compare 比较两个值的大小，a 小于、等于、大于 b 时分别返回 -1、0、1。
+// 支持数值、字符串、布尔值和时间之间的比较，类型不同且无法比较时返回错误
+func Compare(a, b interface{}) (int, error) {
+	if ai, ok := toInt64(a); ok {
+		if bi, ok := toInt64(b); ok {
+			return compareOrdered(ai < bi, ai > bi), nil
+		}
+	}
+	if af, ok := toFloat64(a); ok {
+		if bf, ok := toFloat64(b); ok {
+			return compareOrdered(af < bf, af > bf), nil
+		}
+	}
+	switch av := a.(type) {
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), nil
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			return compareOrdered(!av && bv, av && !bv), nil
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return compareOrdered(av.Before(bv), av.After(bv)), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v (%T) with %v (%T)", a, a, b, b)
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// toBool 转换为布尔值，known 为 false 表示值为 nil（未知）
+func toBool(v interface{}) (b bool, known bool, err error) {
+	switch val := v.(type) {
+	case nil:
+		return false, false, nil
+	case bool:
+		return val, true, nil
+	default:
+		return false, false, fmt.Errorf("expected boolean but got %v (%T)", v, v)
+	}
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), true
+	case int8:
+		return int64(val), true
+	case int16:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	case uint:
+		return int64(val), true
+	case uint8:
+		return int64(val), true
+	case uint16:
+		return int64(val), true
+	case uint32:
+		return int64(val), true
+	case uint64:
+		return int64(val), true
+	default:
+		return 0, false
+	}
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch val := v.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	default:
+		i, ok := toInt64(v)
+		return float64(i), ok
+	}
+}
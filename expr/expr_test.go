@@ -0,0 +1,168 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	data := map[string]interface{}{
+		"a":       int64(7),
+		"b":       2,
+		"f":       1.5,
+		"s":       "aa",
+		"ok":      true,
+		"nothing": nil,
+	}
+	a, b, f, s := &Ident{Name: "a"}, &Ident{Name: "b"}, &Ident{Name: "f"}, &Ident{Name: "s"}
+	tests := []struct {
+		name     string
+		expr     Expr
+		expected interface{}
+	}{
+		{"整数加法", &BinaryExpr{Op: OpAdd, Left: a, Right: b}, int64(9)},
+		{"整数取模", &BinaryExpr{Op: OpMod, Left: a, Right: b}, int64(1)},
+		{"除法结果为浮点数", &BinaryExpr{Op: OpDiv, Left: a, Right: b}, 3.5},
+		{"混合类型运算", &BinaryExpr{Op: OpMul, Left: a, Right: f}, 10.5},
+		{"字符串拼接", &BinaryExpr{Op: OpAdd, Left: s, Right: &Literal{Value: "b"}}, "aab"},
+		{"数值比较", &BinaryExpr{Op: OpGt, Left: a, Right: &Literal{Value: 6.5}}, true},
+		{"字符串相等", &BinaryExpr{Op: OpEq, Left: s, Right: &Literal{Value: "aa"}}, true},
+		{"一元负号", &UnaryExpr{Op: OpSub, X: a}, int64(-7)},
+		{"逻辑非", &UnaryExpr{Op: OpNot, X: &Ident{Name: "ok"}}, false},
+		{"缺失字段", &Ident{Name: "missing"}, nil},
+		{"nil参与运算", &BinaryExpr{Op: OpAdd, Left: &Ident{Name: "missing"}, Right: a}, nil},
+		{"AND短路", &BinaryExpr{Op: OpAnd, Left: &Literal{Value: false}, Right: &Ident{Name: "missing"}}, false},
+		{"OR三值逻辑", &BinaryExpr{Op: OpOr, Left: &Ident{Name: "missing"}, Right: &Literal{Value: true}}, true},
+		{"AND未知", &BinaryExpr{Op: OpAnd, Left: &Ident{Name: "missing"}, Right: &Literal{Value: true}}, nil},
+		{"IN", &InExpr{X: b, List: []Expr{&Literal{Value: int64(1)}, &Literal{Value: 2.0}}}, true},
+		{"NOT IN", &InExpr{X: s, List: []Expr{&Literal{Value: "bb"}}, Not: true}, true},
+		{"IS NULL", &IsNullExpr{X: &Ident{Name: "nothing"}}, true},
+		{"IS NOT NULL", &IsNullExpr{X: s, Not: true}, true},
+		{"搜索CASE", &CaseExpr{
+			Whens: []When{
+				{Cond: &BinaryExpr{Op: OpGt, Left: a, Right: &Literal{Value: int64(10)}}, Result: &Literal{Value: "high"}},
+				{Cond: &BinaryExpr{Op: OpGt, Left: a, Right: &Literal{Value: int64(5)}}, Result: &Literal{Value: "mid"}},
+			},
+			Else: &Literal{Value: "low"},
+		}, "mid"},
+		{"简单CASE", &CaseExpr{
+			Operand: s,
+			Whens:   []When{{Cond: &Literal{Value: "aa"}, Result: &Literal{Value: int64(1)}}},
+		}, int64(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Eval(tt.expr, data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestEvalAggregateResult(t *testing.T) {
+	call := &CallExpr{Name: "avg", Args: []Expr{&Ident{Name: "temperature"}}}
+	node := &BinaryExpr{Op: OpAdd, Left: call, Right: &CallExpr{Name: "max", Args: []Expr{&Ident{Name: "humidity"}}}}
+	group := GroupEnv{
+		"avg(temperature)": 25.0,
+		"max(humidity)":    60.0,
+	}
+	result, err := Eval(node, group)
+	require.NoError(t, err)
+	assert.Equal(t, 85.0, result)
+
+	// 原始数据中与调用文本同名的字段不会替代函数调用的结果
+	abs := &CallExpr{Name: "abs", Args: []Expr{&Ident{Name: "x"}}}
+	result, err = Eval(abs, map[string]interface{}{"x": -2.0, "abs(x)": 99.0})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, result)
+	result, err = Eval(abs, GroupEnv{"x": -2.0, "abs(x)": 99.0})
+	require.NoError(t, err)
+	assert.Equal(t, 99.0, result)
+}
+
+func TestEvalStruct(t *testing.T) {
+	data := struct {
+		Device string
+		Temp   float64
+	}{Device: "aa", Temp: 30}
+	node := &BinaryExpr{Op: OpAnd,
+		Left:  &BinaryExpr{Op: OpEq, Left: &Ident{Name: "Device"}, Right: &Literal{Value: "aa"}},
+		Right: &BinaryExpr{Op: OpGe, Left: &Ident{Name: "Temp"}, Right: &Literal{Value: int64(30)}},
+	}
+	result, err := Eval(node, &data)
+	require.NoError(t, err)
+	assert.Equal(t, true, result)
+}
+
+func TestLookupNested(t *testing.T) {
+	type meta struct{ Site string }
+	data := map[string]interface{}{
+		"payload": map[string]interface{}{
+			"meta": &meta{Site: "s1"},
+		},
+		"a.b": 1,
+	}
+	v, ok := Lookup(data, "payload.meta.Site")
+	require.True(t, ok)
+	assert.Equal(t, "s1", v)
+	// 名称中带点号的字段优先匹配
+	v, ok = Lookup(data, "a.b")
+	require.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok = Lookup(data, "payload.meta.zone")
+	assert.False(t, ok)
+	_, ok = Lookup(data, "payload.none.site")
+	assert.False(t, ok)
+
+	result, err := Eval(&BinaryExpr{Op: OpEq, Left: &Ident{Name: "payload.meta.Site"}, Right: &Literal{Value: "s1"}}, data)
+	require.NoError(t, err)
+	assert.Equal(t, true, result)
+}
+
+func TestEvalError(t *testing.T) {
+	data := map[string]interface{}{"s": "aa", "n": 1}
+	_, err := Eval(&BinaryExpr{Op: OpSub, Left: &Ident{Name: "s"}, Right: &Ident{Name: "n"}}, data)
+	assert.Error(t, err)
+	_, err = Eval(&BinaryExpr{Op: OpDiv, Left: &Ident{Name: "n"}, Right: &Literal{Value: int64(0)}}, data)
+	assert.Error(t, err)
+	_, err = Eval(&BinaryExpr{Op: OpLt, Left: &Ident{Name: "s"}, Right: &Ident{Name: "n"}}, data)
+	assert.Error(t, err)
+	_, err = Eval(&BinaryExpr{Op: OpAnd, Left: &Ident{Name: "s"}, Right: &Literal{Value: true}}, data)
+	assert.Error(t, err)
+	// 数据中没有聚合结果时函数调用无法求值
+	_, err = Eval(&CallExpr{Name: "avg", Args: []Expr{&Ident{Name: "n"}}}, data)
+	assert.Error(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	now := time.Now()
+	c, err := Compare(now, now.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+	c, err = Compare(int32(3), 3.0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, c)
+	assert.False(t, Equal("1", 1))
+}
+
+func TestString(t *testing.T) {
+	a, b, c := &Ident{Name: "a"}, &Ident{Name: "b"}, &Ident{Name: "c"}
+	tests := []struct {
+		expr     Expr
+		expected string
+	}{
+		{&BinaryExpr{Op: OpMul, Left: &BinaryExpr{Op: OpAdd, Left: a, Right: b}, Right: c}, "(a + b) * c"},
+		{&BinaryExpr{Op: OpAdd, Left: a, Right: &BinaryExpr{Op: OpMul, Left: b, Right: c}}, "a + b * c"},
+		{&BinaryExpr{Op: OpSub, Left: a, Right: &BinaryExpr{Op: OpSub, Left: b, Right: c}}, "a - (b - c)"},
+		{&UnaryExpr{Op: OpNot, X: &BinaryExpr{Op: OpGt, Left: a, Right: b}}, "!(a > b)"},
+		{&CallExpr{Name: "format_time", Args: []Expr{&CallExpr{Name: "window_start"}, &Literal{Value: "it's"}}}, "format_time(window_start(), 'it''s')"},
+		{&InExpr{X: a, List: []Expr{&Literal{Value: int64(1)}, &Literal{Value: 2.5}}, Not: true}, "a NOT IN (1, 2.5)"},
+		{&CaseExpr{Whens: []When{{Cond: &IsNullExpr{X: a}, Result: &Literal{Value: nil}}}, Else: b}, "CASE WHEN a IS NULL THEN null ELSE b END"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, tt.expr.String())
+	}
+}
@@ -0,0 +1,70 @@
+package functions
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+func registerConditional() {
+	RegisterWithSignature("coalesce", Signature{Args: []ArgType{TypeAny}, Variadic: true, Result: TypeAny}, coalesce)
+	RegisterWithSignature("if", Signature{Args: []ArgType{TypeBool, TypeAny, TypeAny}, Result: TypeAny}, ifFunc)
+	RegisterWithSignature("nullif", Signature{Args: []ArgType{TypeAny, TypeAny}, Result: TypeAny}, nullif)
+}
+
+// coalesce 返回第一个不为 nil 的参数
+func coalesce(args ...interface{}) (interface{}, error) {
+	for _, arg := range args {
+		if arg != nil {
+			return arg, nil
+		}
+	}
+	return nil, nil
+}
+
+// ifFunc 条件为 true 时返回第二个参数，否则返回第三个参数，条件为 nil 时视为 false
+func ifFunc(args ...interface{}) (interface{}, error) {
+	switch cond := args[0].(type) {
+	case nil:
+		return args[2], nil
+	case bool:
+		if cond {
+			return args[1], nil
+		}
+		return args[2], nil
+	}
+	return nil, fmt.Errorf("if argument 1: condition must be bool but got %T", args[0])
+}
+
+// nullif 两个参数相等时返回 nil，否则返回第一个参数
+func nullif(args ...interface{}) (interface{}, error) {
+	if equal(args[0], args[1]) {
+		return nil, nil
+	}
+	return args[0], nil
+}
+
+// equal 判断两个值是否相等，数值之间按数值比较
+func equal(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if isNumber(a) && isNumber(b) {
+		return cast.ToFloat64(a) == cast.ToFloat64(b)
+	}
+	if at, ok := a.(time.Time); ok {
+		bt, ok := b.(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+// Package functions 提供了可在 SQL 表达式中调用的标量函数及其注册表。
+package functions
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Function 标量函数，对一行数据中的参数值计算出一个结果
+type Function func(args ...interface{}) (interface{}, error)
+
+// ArgType 函数参数和返回值的类型，编译查询时用于检查常量和已知类型字段的参数
+type ArgType string
+
+const (
+	// TypeAny 任意类型，或在编译时无法确定的类型
+	TypeAny ArgType = "ANY"
+	// TypeNumber 整数或浮点数
+	TypeNumber ArgType = "NUMBER"
+	// TypeString 字符串
+	TypeString ArgType = "STRING"
+	// TypeBool 布尔值
+	TypeBool ArgType = "BOOL"
+	// TypeTime 时间，也接受字符串形式的时间和数值形式的时间戳
+	TypeTime ArgType = "TIME"
+)
+
+// Accepts 判断 t 类型的参数能否传给 param 类型的形参，类型未知时不做检查
+func (param ArgType) Accepts(t ArgType) bool {
+	switch {
+	case param == TypeAny || t == TypeAny || param == t:
+		return true
+	case param == TypeTime:
+		return t == TypeString || t == TypeNumber
+	}
+	return false
+}
+
+// Signature 函数签名，描述参数的个数和类型以及返回值的类型
+type Signature struct {
+	// Args 参数类型
+	Args []ArgType
+	// Optional Args 末尾可以省略的参数个数
+	Optional int
+	// Variadic 为 true 时最后一个参数可以重复任意多次
+	Variadic bool
+	// Result 返回值类型
+	Result ArgType
+}
+
+// checkArity 检查参数个数
+func (s *Signature) checkArity(name string, n int) error {
+	min, max := len(s.Args)-s.Optional, len(s.Args)
+	switch {
+	case s.Variadic && n >= min:
+		return nil
+	case !s.Variadic && n >= min && n <= max:
+		return nil
+	case s.Variadic:
+		return fmt.Errorf("%s expects at least %d arguments but got %d", name, min, n)
+	case min == max:
+		return fmt.Errorf("%s expects %d arguments but got %d", name, min, n)
+	}
+	return fmt.Errorf("%s expects %d to %d arguments but got %d", name, min, max, n)
+}
+
+// argType 返回第 i 个参数的类型
+func (s *Signature) argType(i int) ArgType {
+	if i >= len(s.Args) {
+		return s.Args[len(s.Args)-1]
+	}
+	return s.Args[i]
+}
+
+// entry 注册表中的函数
+type entry struct {
+	fn Function
+	// sig 函数签名，通过 Register 注册的函数没有签名
+	sig *Signature
+}
+
+var (
+	registry      = make(map[string]entry)
+	registryMutex sync.RWMutex
+)
+
+// Register 添加自定义函数到全局注册表，函数名不区分大小写，同名函数会被覆盖。
+// 没有签名的函数在编译查询时不检查参数，需要自行检查参数的个数和类型
+func Register(name string, fn Function) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[strings.ToLower(name)] = entry{fn: fn}
+}
+
+// RegisterWithSignature 按函数签名添加自定义函数到全局注册表。
+// 编译查询时按签名检查参数个数和可以确定的参数类型，调用时参数个数不符合签名的返回错误
+func RegisterWithSignature(name string, sig Signature, fn Function) {
+	name = strings.ToLower(name)
+	checked := func(args ...interface{}) (interface{}, error) {
+		if err := sig.checkArity(name, len(args)); err != nil {
+			return nil, err
+		}
+		return fn(args...)
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[name] = entry{fn: checked, sig: &sig}
+}
+
+// Get 根据函数名获取函数
+func Get(name string) (Function, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	e, ok := registry[strings.ToLower(name)]
+	return e.fn, ok
+}
+
+// Check 按函数签名检查函数调用，args 为参数的类型，返回函数返回值的类型。
+// 函数未注册时返回错误，没有签名的函数不做检查，返回值类型为 TypeAny
+func Check(name string, args []ArgType) (ArgType, error) {
+	registryMutex.RLock()
+	e, ok := registry[strings.ToLower(name)]
+	registryMutex.RUnlock()
+	if !ok {
+		return TypeAny, fmt.Errorf("unknown function %s", name)
+	}
+	if e.sig == nil {
+		return TypeAny, nil
+	}
+	if err := e.sig.checkArity(name, len(args)); err != nil {
+		return TypeAny, err
+	}
+	for i, t := range args {
+		if param := e.sig.argType(i); !param.Accepts(t) {
+			return TypeAny, fmt.Errorf("%s argument %d expects %s but got %s", name, i+1, param, t)
+		}
+	}
+	return e.sig.Result, nil
+}
+
+func init() {
+	registerMath()
+	registerStrings()
+	registerTime()
+	registerConditional()
+}
+
+// hasNil 判断参数中是否有 nil，参数为 nil 时大部分函数的结果为 nil
+func hasNil(args []interface{}) bool {
+	for _, arg := range args {
+		if arg == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// argError 包装参数转换的错误，i 从 0 开始
+func argError(name string, i int, err error) error {
+	return fmt.Errorf("%s argument %d: %w", name, i+1, err)
+}
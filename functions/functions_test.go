@@ -0,0 +1,237 @@
+package functions
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAbs(t *testing.T) {
+	fn, ok := Get("ABS")
+	require.True(t, ok)
+
+	result, err := fn(-3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), result)
+
+	result, err = fn(-2.5)
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, result)
+
+	result, err = fn(nil)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	_, err = fn("x")
+	assert.Error(t, err)
+	_, err = fn(1, 2)
+	assert.Error(t, err)
+}
+
+func TestFloor(t *testing.T) {
+	fn, ok := Get("floor")
+	require.True(t, ok)
+
+	result, err := fn(7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), result)
+
+	result, err = fn(-2.5)
+	require.NoError(t, err)
+	assert.Equal(t, -3.0, result)
+
+	result, err = fn(nil)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	_, err = fn("x")
+	assert.Error(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	Register("Double", func(args ...interface{}) (interface{}, error) {
+		return args[0].(float64) * 2, nil
+	})
+	fn, ok := Get("double")
+	require.True(t, ok)
+	result, err := fn(1.5)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, result)
+}
+
+// call 调用注册表中的函数
+func call(t *testing.T, name string, args ...interface{}) (interface{}, error) {
+	fn, ok := Get(name)
+	require.True(t, ok, name)
+	return fn(args...)
+}
+
+func TestMathFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"ceil", []interface{}{2.1}, 3.0},
+		{"ceil", []interface{}{int64(2)}, int64(2)},
+		{"round", []interface{}{2.5}, 3.0},
+		{"round", []interface{}{-2.5}, -3.0},
+		{"round", []interface{}{3.14159, int64(2)}, 3.14},
+		{"round", []interface{}{1234, -2}, 1200.0},
+		{"round", []interface{}{7, 1}, int64(7)},
+		{"log", []interface{}{math.E}, 1.0},
+		{"log", []interface{}{2, 8}, 3.0},
+		{"pow", []interface{}{2, 10}, 1024.0},
+		{"pow", []interface{}{nil, 10}, nil},
+	}
+	for _, tt := range tests {
+		result, err := call(t, tt.name, tt.args...)
+		require.NoError(t, err, tt.name)
+		if f, ok := tt.expected.(float64); ok {
+			assert.InDelta(t, f, result, 1e-9, "%s%v", tt.name, tt.args)
+			continue
+		}
+		assert.Equal(t, tt.expected, result, "%s%v", tt.name, tt.args)
+	}
+	_, err := call(t, "log", 0)
+	assert.EqualError(t, err, "log: arguments must be positive")
+	_, err = call(t, "pow", "a", 1)
+	assert.ErrorContains(t, err, "pow argument 1")
+	_, err = call(t, "round")
+	assert.EqualError(t, err, "round expects 1 to 2 arguments but got 0")
+}
+
+func TestStringFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []interface{}
+		expected interface{}
+	}{
+		{"concat", []interface{}{"dev", "-", 1}, "dev-1"},
+		{"concat", []interface{}{"dev", nil}, nil},
+		{"lower", []interface{}{"AbC"}, "abc"},
+		{"upper", []interface{}{"AbC"}, "ABC"},
+		{"substring", []interface{}{"温度传感器", 3}, "传感器"},
+		{"substring", []interface{}{"sensor", 2, 3}, "ens"},
+		{"substring", []interface{}{"sensor", -3}, "sor"},
+		{"substring", []interface{}{"sensor", 10}, ""},
+		{"replace", []interface{}{"a-b-c", "-", "_"}, "a_b_c"},
+		{"regexp_match", []interface{}{"dev-001", `^dev-\d+$`}, true},
+		{"regexp_match", []interface{}{"gw-001", `^dev-\d+$`}, false},
+	}
+	for _, tt := range tests {
+		result, err := call(t, tt.name, tt.args...)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.expected, result, "%s%v", tt.name, tt.args)
+	}
+	_, err := call(t, "substring", "abc", 1, -1)
+	assert.Error(t, err)
+	_, err = call(t, "regexp_match", "abc", "(")
+	assert.ErrorContains(t, err, "regexp_match argument 2")
+	_, err = call(t, "concat")
+	assert.EqualError(t, err, "concat expects at least 1 arguments but got 0")
+}
+
+func TestTimeFunctions(t *testing.T) {
+	ts := time.Date(2025, 4, 7, 16, 46, 5, 123000000, time.Local)
+
+	result, err := call(t, "format_time", ts, "yyyy-MM-dd HH:mm:ss.SSS")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04-07 16:46:05.123", result)
+	// 纳秒时间戳（如 window_start() 的结果）和毫秒时间戳都可以格式化
+	result, err = call(t, "format_time", ts.UnixNano(), "YYYY/MM/dd")
+	require.NoError(t, err)
+	assert.Equal(t, "2025/04/07", result)
+	result, err = call(t, "format_time", ts.UnixMilli())
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04-07 16:46:05", result)
+	result, err = call(t, "format_time", ts, "2006-01-02")
+	require.NoError(t, err)
+	assert.Equal(t, "2025-04-07", result)
+
+	result, err = call(t, "now")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), result.(time.Time), time.Second)
+
+	result, err = call(t, "date_diff", "second", ts, ts.Add(90*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(90), result)
+	result, err = call(t, "date_diff", "MINUTE", ts.Add(90*time.Second), ts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), result)
+	_, err = call(t, "date_diff", "week", ts, ts)
+	assert.EqualError(t, err, "date_diff: unsupported unit week")
+
+	result, err = call(t, "to_timestamp", "07/04/2025 16:46", "dd/MM/yyyy HH:mm")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 4, 7, 16, 46, 0, 0, time.Local), result)
+	result, err = call(t, "to_timestamp", ts.UnixMilli())
+	require.NoError(t, err)
+	assert.True(t, ts.Equal(result.(time.Time)))
+	_, err = call(t, "to_timestamp", "x", "yyyy")
+	assert.Error(t, err)
+}
+
+func TestConditionalFunctions(t *testing.T) {
+	result, err := call(t, "coalesce", nil, nil, "x", "y")
+	require.NoError(t, err)
+	assert.Equal(t, "x", result)
+	result, err = call(t, "coalesce", nil)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+
+	result, err = call(t, "if", true, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result)
+	result, err = call(t, "if", nil, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result)
+	_, err = call(t, "if", "yes", 1, 2)
+	assert.Error(t, err)
+
+	result, err = call(t, "nullif", 0, 0.0)
+	require.NoError(t, err)
+	assert.Nil(t, result)
+	result, err = call(t, "nullif", "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "a", result)
+}
+
+func TestCheck(t *testing.T) {
+	resultType, err := Check("SUBSTRING", []ArgType{TypeString, TypeNumber})
+	require.NoError(t, err)
+	assert.Equal(t, TypeString, resultType)
+	// 时间参数接受字符串和数值形式的时间
+	_, err = Check("format_time", []ArgType{TypeNumber, TypeString})
+	assert.NoError(t, err)
+	_, err = Check("format_time", []ArgType{TypeAny})
+	assert.NoError(t, err)
+
+	_, err = Check("unknown", nil)
+	assert.EqualError(t, err, "unknown function unknown")
+	_, err = Check("pow", []ArgType{TypeNumber})
+	assert.EqualError(t, err, "pow expects 2 arguments but got 1")
+	_, err = Check("upper", []ArgType{TypeNumber})
+	assert.EqualError(t, err, "upper argument 1 expects STRING but got NUMBER")
+	_, err = Check("concat", []ArgType{TypeString, TypeBool, TypeNumber})
+	assert.NoError(t, err)
+
+	// 没有签名的函数不做检查
+	Register("no_signature", func(args ...interface{}) (interface{}, error) { return nil, nil })
+	resultType, err = Check("no_signature", []ArgType{TypeNumber, TypeBool})
+	require.NoError(t, err)
+	assert.Equal(t, TypeAny, resultType)
+
+	RegisterWithSignature("Clamp", Signature{Args: []ArgType{TypeNumber, TypeNumber, TypeNumber}, Result: TypeNumber},
+		func(args ...interface{}) (interface{}, error) {
+			return math.Min(math.Max(args[0].(float64), args[1].(float64)), args[2].(float64)), nil
+		})
+	_, err = call(t, "clamp", 1.0)
+	assert.EqualError(t, err, "clamp expects 3 arguments but got 1")
+	result, err := call(t, "clamp", 5.0, 0.0, 2.0)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, result)
+}
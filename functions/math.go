@@ -0,0 +1,139 @@
+package functions
+
+import (
+	"errors"
+	"math"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+func registerMath() {
+	unary := Signature{Args: []ArgType{TypeNumber}, Result: TypeNumber}
+	RegisterWithSignature("abs", unary, abs)
+	RegisterWithSignature("floor", unary, floor)
+	RegisterWithSignature("ceil", unary, ceil)
+	RegisterWithSignature("round", Signature{Args: []ArgType{TypeNumber, TypeNumber}, Optional: 1, Result: TypeNumber}, round)
+	RegisterWithSignature("log", Signature{Args: []ArgType{TypeNumber, TypeNumber}, Optional: 1, Result: TypeNumber}, log)
+	RegisterWithSignature("pow", Signature{Args: []ArgType{TypeNumber, TypeNumber}, Result: TypeNumber}, pow)
+}
+
+// isInteger 判断参数是否为整数类型
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64:
+		return true
+	}
+	return false
+}
+
+// numbers 把参数转换为 float64
+func numbers(name string, args []interface{}) ([]float64, error) {
+	result := make([]float64, len(args))
+	for i, arg := range args {
+		f, err := cast.ToFloat64E(arg)
+		if err != nil {
+			return nil, argError(name, i, err)
+		}
+		result[i] = f
+	}
+	return result, nil
+}
+
+// abs 返回数值的绝对值，整数参数返回 int64，其他数值返回 float64
+func abs(args ...interface{}) (interface{}, error) {
+	switch v := args[0]; {
+	case v == nil:
+		return nil, nil
+	case isInteger(v):
+		i := cast.ToInt64(v)
+		if i < 0 {
+			return -i, nil
+		}
+		return i, nil
+	}
+	f, err := numbers("abs", args)
+	if err != nil {
+		return nil, err
+	}
+	return math.Abs(f[0]), nil
+}
+
+// floor 返回不大于数值的最大整数，整数参数原样返回 int64，其他数值返回 float64
+func floor(args ...interface{}) (interface{}, error) {
+	return rounding("floor", math.Floor, args)
+}
+
+// ceil 返回不小于数值的最小整数，整数参数原样返回 int64，其他数值返回 float64
+func ceil(args ...interface{}) (interface{}, error) {
+	return rounding("ceil", math.Ceil, args)
+}
+
+func rounding(name string, fn func(float64) float64, args []interface{}) (interface{}, error) {
+	switch v := args[0]; {
+	case v == nil:
+		return nil, nil
+	case isInteger(v):
+		return cast.ToInt64(v), nil
+	}
+	f, err := numbers(name, args)
+	if err != nil {
+		return nil, err
+	}
+	return fn(f[0]), nil
+}
+
+// round 把数值四舍五入到小数点后第 digits 位，digits 默认为 0，可以为负数。
+// 整数参数且 digits 不为负数时原样返回 int64
+func round(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	f, err := numbers("round", args)
+	if err != nil {
+		return nil, err
+	}
+	digits := 0.0
+	if len(f) > 1 {
+		digits = math.Trunc(f[1])
+	}
+	if isInteger(args[0]) && digits >= 0 {
+		return cast.ToInt64(args[0]), nil
+	}
+	scale := math.Pow(10, digits)
+	return math.Round(f[0]*scale) / scale, nil
+}
+
+// log 返回自然对数，有两个参数时 log(b, x) 返回以 b 为底 x 的对数
+func log(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	f, err := numbers("log", args)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range f {
+		if v <= 0 {
+			return nil, errors.New("log: arguments must be positive")
+		}
+	}
+	if len(f) == 1 {
+		return math.Log(f[0]), nil
+	}
+	if f[0] == 1 {
+		return nil, errors.New("log: base must not be 1")
+	}
+	return math.Log(f[1]) / math.Log(f[0]), nil
+}
+
+// pow 返回 x 的 y 次幂
+func pow(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	f, err := numbers("pow", args)
+	if err != nil {
+		return nil, err
+	}
+	return math.Pow(f[0], f[1]), nil
+}
@@ -0,0 +1,140 @@
+package functions
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+func registerStrings() {
+	RegisterWithSignature("concat", Signature{Args: []ArgType{TypeAny}, Variadic: true, Result: TypeString}, concat)
+	RegisterWithSignature("lower", Signature{Args: []ArgType{TypeString}, Result: TypeString}, lower)
+	RegisterWithSignature("upper", Signature{Args: []ArgType{TypeString}, Result: TypeString}, upper)
+	RegisterWithSignature("substring", Signature{Args: []ArgType{TypeString, TypeNumber, TypeNumber}, Optional: 1, Result: TypeString}, substring)
+	RegisterWithSignature("replace", Signature{Args: []ArgType{TypeString, TypeString, TypeString}, Result: TypeString}, replace)
+	RegisterWithSignature("regexp_match", Signature{Args: []ArgType{TypeString, TypeString}, Result: TypeBool}, regexpMatch)
+}
+
+// stringArgs 把参数转换为字符串
+func stringArgs(name string, args []interface{}) ([]string, error) {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		s, err := cast.ToStringE(arg)
+		if err != nil {
+			return nil, argError(name, i, err)
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+// concat 依次拼接参数的字符串形式
+func concat(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := stringArgs("concat", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Join(s, ""), nil
+}
+
+// lower 把字符串转换为小写
+func lower(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := stringArgs("lower", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToLower(s[0]), nil
+}
+
+// upper 把字符串转换为大写
+func upper(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := stringArgs("upper", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ToUpper(s[0]), nil
+}
+
+// substring 返回字符串从第 start 个字符开始、长度为 length 的子串，省略 length 时截取到末尾。
+// start 从 1 开始，为负数时从末尾倒数，按字符而不是字节计算
+func substring(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := cast.ToStringE(args[0])
+	if err != nil {
+		return nil, argError("substring", 0, err)
+	}
+	n, err := numbers("substring", args[1:])
+	if err != nil {
+		return nil, err
+	}
+	runes := []rune(s)
+	start := int(n[0])
+	switch {
+	case start > 0:
+		start--
+	case start < 0:
+		start += len(runes)
+	}
+	if start < 0 || start >= len(runes) {
+		return "", nil
+	}
+	end := len(runes)
+	if len(n) > 1 {
+		if n[1] < 0 {
+			return nil, errors.New("substring: length must not be negative")
+		}
+		if l := int(n[1]); start+l < end {
+			end = start + l
+		}
+	}
+	return string(runes[start:end]), nil
+}
+
+// replace 把字符串中所有的 old 替换为 new
+func replace(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := stringArgs("replace", args)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ReplaceAll(s[0], s[1], s[2]), nil
+}
+
+// regexps 编译后的正则表达式，按表达式文本缓存
+var regexps sync.Map
+
+// regexpMatch 判断字符串是否匹配正则表达式，表达式语法与 Go regexp 包相同
+func regexpMatch(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	s, err := stringArgs("regexp_match", args)
+	if err != nil {
+		return nil, err
+	}
+	re, ok := regexps.Load(s[1])
+	if !ok {
+		compiled, err := regexp.Compile(s[1])
+		if err != nil {
+			return nil, argError("regexp_match", 1, err)
+		}
+		re, _ = regexps.LoadOrStore(s[1], compiled)
+	}
+	return re.(*regexp.Regexp).MatchString(s[0]), nil
+}
@@ -0,0 +1,152 @@
+package functions
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+// DefaultTimeLayout format_time 未指定格式时使用的格式
+const DefaultTimeLayout = "yyyy-MM-dd HH:mm:ss"
+
+// nanoThreshold 绝对值不小于该值的数值时间戳按 Unix 纳秒处理，如 window_start() 的结果，其他数值按 Unix 毫秒处理
+const nanoThreshold = 1e17
+
+func registerTime() {
+	RegisterWithSignature("format_time", Signature{Args: []ArgType{TypeTime, TypeString}, Optional: 1, Result: TypeString}, formatTime)
+	RegisterWithSignature("now", Signature{Result: TypeTime}, now)
+	RegisterWithSignature("date_diff", Signature{Args: []ArgType{TypeString, TypeTime, TypeTime}, Result: TypeNumber}, dateDiff)
+	RegisterWithSignature("to_timestamp", Signature{Args: []ArgType{TypeAny, TypeString}, Optional: 1, Result: TypeTime}, toTimestamp)
+}
+
+// toTime 把参数转换为时间，数值为 Unix 毫秒或纳秒时间戳，字符串按 cast.ToTimeE 支持的格式解析
+func toTime(name string, i int, v interface{}) (time.Time, error) {
+	switch n := v.(type) {
+	case int64:
+		if n >= nanoThreshold || n <= -nanoThreshold {
+			return time.Unix(0, n), nil
+		}
+	case float64:
+		if math.Abs(n) >= nanoThreshold {
+			return time.Unix(0, int64(n)), nil
+		}
+	}
+	t, err := cast.ToTimeE(v)
+	if err != nil {
+		return time.Time{}, argError(name, i, err)
+	}
+	return t, nil
+}
+
+// formatTime 按格式把时间格式化为字符串。
+// 格式使用 yyyy、MM、dd、HH、mm、ss、SSS 等占位符，如 'yyyy-MM-dd HH:mm:ss'，也可以直接使用 Go 的时间格式
+func formatTime(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	t, err := toTime("format_time", 0, args[0])
+	if err != nil {
+		return nil, err
+	}
+	layout := DefaultTimeLayout
+	if len(args) > 1 {
+		if layout, err = cast.ToStringE(args[1]); err != nil {
+			return nil, argError("format_time", 1, err)
+		}
+	}
+	return t.Format(ConvertLayout(layout)), nil
+}
+
+// layoutTokens 占位符到 Go 时间格式的映射，按占位符长度从长到短匹配
+var layoutTokens = []struct {
+	token, layout string
+}{
+	{"yyyy", "2006"}, {"YYYY", "2006"}, {"SSS", "000"}, {"yy", "06"}, {"YY", "06"},
+	{"MM", "01"}, {"dd", "02"}, {"DD", "02"}, {"HH", "15"}, {"hh", "03"},
+	{"mm", "04"}, {"ss", "05"}, {"M", "1"}, {"d", "2"}, {"h", "3"}, {"m", "4"}, {"s", "5"},
+	{"a", "PM"}, {"Z", "-0700"},
+}
+
+// ConvertLayout 把 yyyy-MM-dd HH:mm:ss 形式的时间格式转换为 Go 的时间格式，包含 2006 的格式视为 Go 的时间格式原样返回
+func ConvertLayout(layout string) string {
+	if strings.Contains(layout, "2006") {
+		return layout
+	}
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		matched := false
+		for _, t := range layoutTokens {
+			if strings.HasPrefix(layout[i:], t.token) {
+				b.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(layout[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// now 返回当前时间
+func now(_ ...interface{}) (interface{}, error) {
+	return time.Now(), nil
+}
+
+// dateUnits date_diff 支持的时间单位
+var dateUnits = map[string]time.Duration{
+	"ms": time.Millisecond, "millisecond": time.Millisecond, "milliseconds": time.Millisecond,
+	"s": time.Second, "second": time.Second, "seconds": time.Second,
+	"minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"day": 24 * time.Hour, "days": 24 * time.Hour,
+}
+
+// dateDiff 返回从 start 到 end 经过的完整时间单位数，end 早于 start 时为负数，如 date_diff('second', start, end)
+func dateDiff(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	unit, err := cast.ToStringE(args[0])
+	if err != nil {
+		return nil, argError("date_diff", 0, err)
+	}
+	d, ok := dateUnits[strings.ToLower(unit)]
+	if !ok {
+		return nil, fmt.Errorf("date_diff: unsupported unit %s", unit)
+	}
+	start, err := toTime("date_diff", 1, args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := toTime("date_diff", 2, args[2])
+	if err != nil {
+		return nil, err
+	}
+	return int64(end.Sub(start) / d), nil
+}
+
+// toTimestamp 把字符串或数值时间戳转换为时间，指定格式时按格式在本地时区解析字符串
+func toTimestamp(args ...interface{}) (interface{}, error) {
+	if hasNil(args) {
+		return nil, nil
+	}
+	if len(args) == 1 {
+		return toTime("to_timestamp", 0, args[0])
+	}
+	s, err := stringArgs("to_timestamp", args)
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.ParseInLocation(ConvertLayout(s[1]), s[0], time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("to_timestamp: %w", err)
+	}
+	return t, nil
+}
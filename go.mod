@@ -2,10 +2,7 @@ module github.com/rulego/streamsql
 
 go 1.18
 
-require (
-	github.com/expr-lang/expr v1.17.2
-	github.com/stretchr/testify v1.10.0
-)
+require github.com/stretchr/testify v1.10.0
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
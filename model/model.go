@@ -6,7 +6,9 @@ import (
 	"time"
 
 	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
 	stringx "github.com/rulego/streamsql/utils/stringx"
+	"github.com/rulego/streamsql/utils/timex"
 )
 
 type ExprType int
@@ -17,22 +19,62 @@ const (
 	Expr
 	Func
 	Win
+)
 
+const (
 	ASC OrderType = iota
 	DESC
 )
 
+// TimeCharacteristic 窗口的时间语义
+type TimeCharacteristic string
+
+const (
+	// ProcessingTime 处理时间，窗口按系统时钟定时触发
+	ProcessingTime TimeCharacteristic = "processing"
+	// EventTime 事件时间，窗口在水位线越过窗口结束时间时触发
+	EventTime TimeCharacteristic = "event"
+)
+
 type Config struct {
 	WindowConfig WindowConfig
 	GroupFields  []string
+	// GroupBy 分组字段的定义，可以是嵌套字段的路径或表达式，不为空时取代 GroupFields
+	GroupBy      []aggregator.GroupField
 	SelectFields map[string]aggregator.AggregateType
 	FieldAlias   map[string]string
+	// Where 过滤条件的语法树，为空时不过滤
+	Where expr.Expr
+	// Having 分组结果的过滤条件，可以引用输出字段的别名和聚合表达式
+	Having expr.Expr
+	// OrderBy 每个窗口输出结果的排序项，可以引用输出字段的别名和聚合表达式
+	OrderBy []expr.OrderItem
+	// Limit 每个窗口最多输出的结果数量，为 0 时不限制
+	Limit int
+	// Projection 输出字段，不为空时按输出字段的语法树计算每个分组的结果
+	Projection Projection
+	// Aggregations 聚合计算，不为空时取代 SelectFields 和 FieldAlias
+	Aggregations []aggregator.AggregationField
+	// RowFields 窗口查询中既不分组也不聚合的字段，分组结果中取分组内最后一条数据的值
+	RowFields []string
 }
 type WindowConfig struct {
 	Type     string
 	Params   map[string]interface{}
 	TsProp   string
 	TimeUnit time.Duration
+	// GroupFields 分组字段，会话窗口按分组键维护独立的会话
+	GroupFields []string
+	// GroupBy 分组字段的定义，不为空时取代 GroupFields
+	GroupBy []aggregator.GroupField
+	// TimeCharacteristic 时间语义，默认为处理时间
+	TimeCharacteristic TimeCharacteristic
+	// MaxOutOfOrderness 事件时间语义下允许的最大乱序时间，水位线 = 最大事件时间 - MaxOutOfOrderness
+	MaxOutOfOrderness time.Duration
+	// AllowedLateness 窗口触发后继续保留窗口数据的时间，在此期间到达的迟到数据会使窗口重新输出更新后的结果
+	AllowedLateness time.Duration
+	// Clock 窗口使用的时钟，为空时使用系统时钟
+	Clock timex.Clock
 }
 
 type ExprMeta struct {
@@ -43,6 +85,7 @@ type ExprMeta struct {
 	Args       []any       // 函数参数
 	Sort       OrderType   // 排序: 0:升序, 1: 降序
 	OverClause *OverClause // over 子句
+	Node       expr.Expr   // 表达式语法树
 }
 
 func (e *ExprMeta) ParseArgs() {
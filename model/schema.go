@@ -0,0 +1,132 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rulego/streamsql/utils/cast"
+)
+
+// DataType 流字段的数据类型
+type DataType string
+
+const (
+	TypeString    DataType = "STRING"
+	TypeInt       DataType = "INT"
+	TypeFloat     DataType = "FLOAT"
+	TypeBool      DataType = "BOOL"
+	TypeTimestamp DataType = "TIMESTAMP"
+)
+
+// dataTypeNames 数据类型名称及其别名，名称不区分大小写
+var dataTypeNames = map[string]DataType{
+	"STRING":    TypeString,
+	"VARCHAR":   TypeString,
+	"TEXT":      TypeString,
+	"INT":       TypeInt,
+	"INTEGER":   TypeInt,
+	"BIGINT":    TypeInt,
+	"FLOAT":     TypeFloat,
+	"DOUBLE":    TypeFloat,
+	"REAL":      TypeFloat,
+	"BOOL":      TypeBool,
+	"BOOLEAN":   TypeBool,
+	"TIMESTAMP": TypeTimestamp,
+	"DATETIME":  TypeTimestamp,
+}
+
+// ParseDataType 根据类型名称获取数据类型，如 STRING、BIGINT、DOUBLE
+func ParseDataType(name string) (DataType, error) {
+	t, ok := dataTypeNames[strings.ToUpper(name)]
+	if !ok {
+		return "", fmt.Errorf("unknown data type %s", name)
+	}
+	return t, nil
+}
+
+// Numeric 判断是否为数值类型
+func (t DataType) Numeric() bool {
+	return t == TypeInt || t == TypeFloat
+}
+
+// Coerce 将值转换为该类型：STRING 转换为 string，INT 转换为 int64，FLOAT 转换为 float64，
+// BOOL 转换为 bool，TIMESTAMP 转换为 time.Time。nil 保持为 nil
+func (t DataType) Coerce(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch t {
+	case TypeString:
+		return cast.ToStringE(value)
+	case TypeInt:
+		return cast.ToInt64E(value)
+	case TypeFloat:
+		return cast.ToFloat64E(value)
+	case TypeBool:
+		return cast.ToBoolE(value)
+	case TypeTimestamp:
+		return cast.ToTimeE(value)
+	default:
+		return nil, fmt.Errorf("unknown data type %s", t)
+	}
+}
+
+// SchemaField 流的字段定义
+type SchemaField struct {
+	Name string
+	Type DataType
+}
+
+// FormatJSON 流数据的 JSON 格式，[]byte 或 string 类型的数据按 JSON 对象解码
+const FormatJSON = "json"
+
+// Schema 通过 CREATE STREAM 声明的流结构
+type Schema struct {
+	// Name 流名称
+	Name   string
+	Fields []SchemaField
+	// TsProp 事件时间字段，未在查询中指定 TIMESTAMP 时使用
+	TsProp string
+	// Format 数据格式，目前只支持 json
+	Format string
+}
+
+// Field 根据名称获取字段定义
+func (s *Schema) Field(name string) (SchemaField, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return SchemaField{}, false
+}
+
+// Coerce 按字段定义校验并转换一条数据，返回只包含声明字段的新数据，缺失的字段为 nil。
+// 数据可以是 map[string]interface{}，或按 Format 解码的 []byte、string
+func (s *Schema) Coerce(data interface{}) (map[string]interface{}, error) {
+	var row map[string]interface{}
+	switch v := data.(type) {
+	case map[string]interface{}:
+		row = v
+	case []byte:
+		if err := json.Unmarshal(v, &row); err != nil {
+			return nil, fmt.Errorf("decode %s row of stream %s: %w", s.Format, s.Name, err)
+		}
+	case string:
+		if err := json.Unmarshal([]byte(v), &row); err != nil {
+			return nil, fmt.Errorf("decode %s row of stream %s: %w", s.Format, s.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported row type %T for stream %s", data, s.Name)
+	}
+	out := make(map[string]interface{}, len(s.Fields))
+	for _, f := range s.Fields {
+		val, err := f.Type.Coerce(row[f.Name])
+		if err != nil {
+			return nil, fmt.Errorf("field %s of stream %s expects %s: %w", f.Name, s.Name, f.Type, err)
+		}
+		out[f.Name] = val
+	}
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDataType(t *testing.T) {
+	for name, expected := range map[string]DataType{
+		"string": TypeString, "VARCHAR": TypeString,
+		"bigint": TypeInt, "Integer": TypeInt,
+		"double": TypeFloat, "FLOAT": TypeFloat,
+		"boolean": TypeBool, "timestamp": TypeTimestamp,
+	} {
+		dt, err := ParseDataType(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, dt, name)
+	}
+	_, err := ParseDataType("blob")
+	assert.EqualError(t, err, "unknown data type blob")
+}
+
+func TestSchemaCoerce(t *testing.T) {
+	schema := &Schema{
+		Name: "sensors",
+		Fields: []SchemaField{
+			{Name: "deviceId", Type: TypeString},
+			{Name: "temperature", Type: TypeFloat},
+			{Name: "count", Type: TypeInt},
+			{Name: "online", Type: TypeBool},
+			{Name: "ts", Type: TypeTimestamp},
+		},
+		TsProp: "ts",
+		Format: FormatJSON,
+	}
+	ts := time.UnixMilli(1744015560000)
+
+	row, err := schema.Coerce(map[string]interface{}{
+		"deviceId":    1001,
+		"temperature": "25.5",
+		"count":       3.0,
+		"online":      "true",
+		"ts":          ts.UnixMilli(),
+		"extra":       "dropped",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"deviceId":    "1001",
+		"temperature": 25.5,
+		"count":       int64(3),
+		"online":      true,
+		"ts":          ts,
+	}, row)
+
+	// JSON 数据先解码，缺失的字段为 nil
+	row, err = schema.Coerce([]byte(`{"deviceId":"aa","temperature":30,"ts":1744015560000}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"deviceId":    "aa",
+		"temperature": 30.0,
+		"count":       nil,
+		"online":      nil,
+		"ts":          ts,
+	}, row)
+
+	_, err = schema.Coerce(map[string]interface{}{"temperature": "hot"})
+	assert.ErrorContains(t, err, "field temperature of stream sensors expects FLOAT")
+	_, err = schema.Coerce(`{"deviceId":`)
+	assert.ErrorContains(t, err, "decode json row of stream sensors")
+	_, err = schema.Coerce(42)
+	assert.EqualError(t, err, "unsupported row type int for stream sensors")
+}
@@ -16,9 +16,60 @@
 
 package streamsql
 
+import (
+	"time"
+
+	"github.com/rulego/streamsql/stream"
+	"github.com/rulego/streamsql/utils/timex"
+)
+
 // Option represents a modification to the default behavior of a streamsql.
 type Option func(*Streamsql)
 
+// WithClock 设置窗口使用的时钟，默认使用系统时钟。
+// 测试时可以传入 timex.NewFakeClock 创建的时钟，通过手动推进时间确定地触发窗口
+func WithClock(clock timex.Clock) Option {
+	return func(s *Streamsql) {
+		s.clock = clock
+	}
+}
+
+// WithErrorHandler 设置错误处理函数，数据过滤、窗口、聚合和输出过程中出错时调用，出错的数据不影响其他数据的处理
+func WithErrorHandler(handler func(*stream.StreamError)) Option {
+	return func(s *Streamsql) {
+		s.errorHandler = handler
+	}
+}
+
+// WithBufferSize 设置数据通道和结果通道的缓冲区大小，默认分别为 stream.DefaultDataBufferSize 和 stream.DefaultResultBufferSize
+func WithBufferSize(dataSize, resultSize int) Option {
+	return func(s *Streamsql) {
+		s.streamOpts = append(s.streamOpts, stream.WithDataBufferSize(dataSize), stream.WithResultBufferSize(resultSize))
+	}
+}
+
+// WithOverflowPolicy 设置数据通道已满时 AddData 的处理策略，默认阻塞直到通道空闲
+func WithOverflowPolicy(policy stream.OverflowPolicy) Option {
+	return func(s *Streamsql) {
+		s.streamOpts = append(s.streamOpts, stream.WithOverflowPolicy(policy))
+	}
+}
+
+// WithResultOverflowPolicy 设置结果通道已满时结果的处理策略，默认阻塞直到通道空闲。
+// 丢弃策略下不读取结果也不会阻塞窗口触发和数据处理，被丢弃的结果作为 result 阶段的错误报告
+func WithResultOverflowPolicy(policy stream.OverflowPolicy) Option {
+	return func(s *Streamsql) {
+		s.streamOpts = append(s.streamOpts, stream.WithResultOverflowPolicy(policy))
+	}
+}
+
+// WithAddTimeout 设置数据通道已满时 AddData 的最长等待时间，超时后返回 stream.ErrAddTimeout
+func WithAddTimeout(timeout time.Duration) Option {
+	return func(s *Streamsql) {
+		s.streamOpts = append(s.streamOpts, stream.WithOverflowPolicy(stream.OverflowBlockWithTimeout), stream.WithAddTimeout(timeout))
+	}
+}
+
 //// WithLocation overrides the timezone of the cron instance.
 //func WithLocation(loc *time.Location) Option {
 //	return func(s *Streamsql) {
@@ -1,30 +1,39 @@
 package parser
 
 import (
-	"github.com/expr-lang/expr"
-	"github.com/expr-lang/expr/vm"
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/rsql"
 )
 
 type Condition interface {
-	Evaluate(env interface{}) bool
+	// Evaluate 判断数据是否满足条件，求值出错时返回错误
+	Evaluate(env interface{}) (bool, error)
 }
 
+// ExprCondition 基于表达式语法树的过滤条件
 type ExprCondition struct {
-	program *vm.Program
+	node expr.Expr
 }
 
+// NewCondition 根据表达式语法树创建过滤条件
+func NewCondition(node expr.Expr) Condition {
+	return &ExprCondition{node: node}
+}
+
+// NewExprCondition 解析条件表达式并创建过滤条件，如 "device == 'aa' && temperature > 10"
 func NewExprCondition(expression string) (Condition, error) {
-	program, err := expr.Compile(expression)
+	node, err := rsql.ParseExpression(expression)
 	if err != nil {
 		return nil, err
 	}
-	return &ExprCondition{program: program}, nil
+	return NewCondition(node), nil
 }
 
-func (ec *ExprCondition) Evaluate(env interface{}) bool {
-	result, err := expr.Run(ec.program, env)
+// Evaluate 对数据求条件表达式的值，只有结果为 true 时数据才满足条件，结果为 nil 时视为不满足
+func (ec *ExprCondition) Evaluate(env interface{}) (bool, error) {
+	result, err := expr.Eval(ec.node, env)
 	if err != nil {
-		return false
+		return false, err
 	}
-	return result.(bool)
+	return expr.Truthy(result), nil
 }
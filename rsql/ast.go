@@ -5,25 +5,79 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
 	"github.com/rulego/streamsql/model"
 	"github.com/rulego/streamsql/window"
-
-	"github.com/rulego/streamsql/aggregator"
 )
 
+// Statement SQL 语句，为 *SelectStatement 或 *CreateStreamStatement
+type Statement interface {
+	statement()
+}
+
+func (*SelectStatement) statement()       {}
+func (*CreateStreamStatement) statement() {}
+
+// CreateStreamStatement CREATE STREAM 语句，声明流的字段和类型
+type CreateStreamStatement struct {
+	Name   string
+	Fields []model.SchemaField
+	// TsProp WITH 子句中的 TIMESTAMP，流的事件时间字段
+	TsProp string
+	// Format WITH 子句中的 FORMAT，默认为 json
+	Format string
+}
+
+// ToSchema 将 CREATE STREAM 语句转换为流结构，检查字段是否重复以及事件时间字段的类型
+func (s *CreateStreamStatement) ToSchema() (*model.Schema, error) {
+	seen := make(map[string]bool, len(s.Fields))
+	for _, f := range s.Fields {
+		if seen[f.Name] {
+			return nil, fmt.Errorf("duplicate field %s in stream %s", f.Name, s.Name)
+		}
+		seen[f.Name] = true
+	}
+	if s.Format != model.FormatJSON {
+		return nil, fmt.Errorf("unsupported FORMAT %s for stream %s", s.Format, s.Name)
+	}
+	schema := &model.Schema{Name: s.Name, Fields: s.Fields, TsProp: s.TsProp, Format: s.Format}
+	if s.TsProp != "" {
+		f, ok := schema.Field(s.TsProp)
+		if !ok {
+			return nil, fmt.Errorf("TIMESTAMP field %s is not declared in stream %s", s.TsProp, s.Name)
+		}
+		if f.Type != model.TypeTimestamp {
+			return nil, fmt.Errorf("TIMESTAMP field %s of stream %s must be TIMESTAMP but is %s", s.TsProp, s.Name, f.Type)
+		}
+	}
+	return schema, nil
+}
+
 type SelectStatement struct {
-	Fields    []Field
-	Source    string
+	Fields []Field
+	Source string
+	// Condition WHERE 条件的规范文本
 	Condition string
-	Window    WindowDefinition
-	GroupBy   []string
-	Context   model.StreamContext
+	// Where WHERE 条件的语法树
+	Where   expr.Expr
+	Window  WindowDefinition
+	GroupBy []expr.Expr
+	// Having HAVING 条件的语法树
+	Having expr.Expr
+	// OrderBy ORDER BY 排序项
+	OrderBy []expr.OrderItem
+	// Limit LIMIT 限制的结果数量，为 0 时不限制
+	Limit   int
+	Context model.StreamContext
 }
 
 type Field struct {
 	Expression string
 	Alias      string
 	AggType    string
+	// Expr 字段表达式的语法树
+	Expr expr.Expr
 }
 
 type WindowDefinition struct {
@@ -31,6 +85,12 @@ type WindowDefinition struct {
 	Params   []interface{}
 	TsProp   string
 	TimeUnit time.Duration
+	// EventTime 是否使用事件时间语义
+	EventTime bool
+	// MaxOutOfOrderness 事件时间语义下允许的最大乱序时间
+	MaxOutOfOrderness time.Duration
+	// AllowedLateness 窗口触发后允许迟到数据更新窗口结果的时间
+	AllowedLateness time.Duration
 }
 
 // ToStreamConfig 将AST转换为Stream配置
@@ -38,8 +98,8 @@ func (s *SelectStatement) ToStreamConfig() (*model.Config, string, error) {
 	if s.Source == "" {
 		return nil, "", fmt.Errorf("missing FROM clause")
 	}
-	// 解析窗口配置
-	windowType := window.TypeTumbling
+	// 解析窗口配置，没有窗口时为逐条处理数据的无窗口查询
+	windowType := ""
 	if strings.ToUpper(s.Window.Type) == "TUMBLINGWINDOW" {
 		windowType = window.TypeTumbling
 	} else if strings.ToUpper(s.Window.Type) == "SLIDINGWINDOW" {
@@ -54,90 +114,271 @@ func (s *SelectStatement) ToStreamConfig() (*model.Config, string, error) {
 	if err != nil {
 		return nil, "", fmt.Errorf("解析窗口参数失败: %w", err)
 	}
-	aggs, fields := buildSelectFields(s.Fields)
+	// 会话窗口的第一个参数为会话超时时间
+	if windowType == window.TypeSession {
+		if size, ok := params["size"]; ok {
+			params["timeout"] = size
+			delete(params, "size")
+		}
+	}
+	timeCharacteristic := model.ProcessingTime
+	if s.Window.EventTime {
+		if s.Window.TsProp == "" {
+			return nil, "", fmt.Errorf("event time requires TIMESTAMP in WITH clause")
+		}
+		timeCharacteristic = model.EventTime
+	}
+	groupBy := extractGroupFields(s)
+	var groupFields []string
+	for _, g := range groupBy {
+		groupFields = append(groupFields, g.Name)
+	}
+	if err := checkFunctions(s); err != nil {
+		return nil, "", err
+	}
+	aggs, err := buildAggregations(s.Fields, s.Having, s.OrderBy)
+	if err != nil {
+		return nil, "", err
+	}
+	projection, having, orderBy := s.Context.Projection, s.Having, s.OrderBy
+	var rowFields []string
+	if windowType == "" {
+		if err := validateNonWindow(s, aggs); err != nil {
+			return nil, "", err
+		}
+	} else {
+		projection, having, orderBy, rowFields = bindGroupResult(s, groupBy)
+	}
 	// 构建Stream配置
 	config := model.Config{
 		WindowConfig: model.WindowConfig{
-			Type:     windowType,
-			Params:   params,
-			TsProp:   s.Window.TsProp,
-			TimeUnit: s.Window.TimeUnit,
+			Type:               windowType,
+			Params:             params,
+			TsProp:             s.Window.TsProp,
+			TimeUnit:           s.Window.TimeUnit,
+			TimeCharacteristic: timeCharacteristic,
+			MaxOutOfOrderness:  s.Window.MaxOutOfOrderness,
+			AllowedLateness:    s.Window.AllowedLateness,
 		},
-		GroupFields:  extractGroupFields(s),
-		SelectFields: aggs,
-		FieldAlias:   fields,
+		GroupFields:  groupFields,
+		GroupBy:      groupBy,
+		Where:        s.Where,
+		Having:       having,
+		OrderBy:      orderBy,
+		Limit:        s.Limit,
+		Projection:   projection,
+		Aggregations: aggs,
+		RowFields:    rowFields,
 	}
 
 	return &config, s.Condition, nil
 }
 
-func extractGroupFields(s *SelectStatement) []string {
-	var fields []string
-	for _, f := range s.GroupBy {
-		if !strings.Contains(f, "(") { // 排除聚合函数
-			fields = append(fields, f)
+// extractGroupFields 将 GROUP BY 中的表达式转换为分组字段。
+// 字段和嵌套字段的路径按名称取值，其他表达式以规范文本为名称写入分组结果，查询字段中相同的表达式直接取该值
+func extractGroupFields(s *SelectStatement) []aggregator.GroupField {
+	var fields []aggregator.GroupField
+	for _, e := range s.GroupBy {
+		if ident, ok := e.(*expr.Ident); ok {
+			fields = append(fields, aggregator.GroupField{Name: ident.Name})
+			continue
 		}
+		fields = append(fields, aggregator.GroupField{Name: e.String(), Expr: e})
 	}
 	return fields
 }
 
-func buildSelectFields(fields []Field) (aggMap map[string]aggregator.AggregateType, fieldMap map[string]string) {
-	selectFields := make(map[string]aggregator.AggregateType)
-	fieldMap = make(map[string]string)
-	for _, f := range fields {
-		if alias := f.Alias; alias != "" {
-			t, n := parseAggregateType(f.Expression)
-			if n != "" {
-				selectFields[n] = t
-				fieldMap[n] = alias
-			} else {
-				selectFields[alias] = t
+// bindGroupResult 把窗口查询的输出字段、HAVING 条件和排序项绑定到分组结果：与分组表达式相同的子表达式替换为对分组结果中该值的引用，
+// 如 GROUP BY temperature/10 时 SELECT temperature/10*2 直接取分组的值再计算；聚合函数之外引用的既不是分组字段也不是输出字段别名的字段
+// 作为非聚合字段返回，分组结果中取分组内最后一条数据的值
+func bindGroupResult(s *SelectStatement, groupBy []aggregator.GroupField) (model.Projection, expr.Expr, []expr.OrderItem, []string) {
+	b := &groupBinder{groups: make(map[string]bool, len(groupBy)), seen: make(map[string]bool)}
+	for _, g := range groupBy {
+		b.groups[g.Name] = true
+	}
+	projection := make(model.Projection, len(s.Context.Projection))
+	for i, field := range s.Context.Projection {
+		node := b.bind(field.Node)
+		// 替换后表达式的规范文本可能变化，没有别名的字段保持原来的输出名称
+		if node != field.Node && field.Alias == "" {
+			field.Alias = field.Node.String()
+		}
+		field.Node = node
+		projection[i] = field
+	}
+	// HAVING 条件和排序项可以引用输出字段的别名
+	b.aliases = make(map[string]bool)
+	for _, f := range s.Fields {
+		if f.Alias != "" {
+			b.aliases[f.Alias] = true
+		}
+	}
+	having := b.bind(s.Having)
+	var orderBy []expr.OrderItem
+	for _, item := range s.OrderBy {
+		orderBy = append(orderBy, expr.OrderItem{Expr: b.bind(item.Expr), Desc: item.Desc})
+	}
+	return projection, having, orderBy, b.rowFields
+}
+
+// groupBinder 把表达式绑定到分组结果
+type groupBinder struct {
+	// groups 分组字段和分组表达式的名称
+	groups map[string]bool
+	// aliases 可以引用的输出字段别名
+	aliases   map[string]bool
+	rowFields []string
+	seen      map[string]bool
+}
+
+func (b *groupBinder) bind(node expr.Expr) expr.Expr {
+	return expr.Rewrite(node, func(n expr.Expr) (expr.Expr, bool) {
+		switch v := n.(type) {
+		case *expr.Literal:
+			return n, true
+		case *expr.Ident:
+			if !b.groups[v.Name] && !b.aliases[v.Name] && !groupPath(v.Name, b.groups) && !b.seen[v.Name] {
+				b.seen[v.Name] = true
+				b.rowFields = append(b.rowFields, v.Name)
+			}
+			return n, true
+		case *expr.CallExpr:
+			// 聚合结果和分析函数的结果由聚合阶段计算
+			if v.Over != nil || isAggregate(v) {
+				return n, true
 			}
 		}
+		if text := n.String(); b.groups[text] {
+			return &expr.Ident{Name: text}, true
+		}
+		return n, false
+	})
+}
+
+// groupPath 判断嵌套字段的路径是否位于某个分组字段之下，如 GROUP BY payload 时的 payload.meta.site
+func groupPath(name string, groups map[string]bool) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' && groups[name[:i]] {
+			return true
+		}
 	}
-	return selectFields, fieldMap
+	return false
 }
 
-func parseAggregateType(expr string) (aggType aggregator.AggregateType, name string) {
-	if strings.Contains(expr, "avg(") {
-		return "avg", extractAggField(expr)
+// validateNonWindow 检查无窗口查询，无窗口查询逐条输出数据，不能使用聚合、分组、排序等需要窗口的子句
+func validateNonWindow(s *SelectStatement, aggs []aggregator.AggregationField) error {
+	switch {
+	case len(aggs) > 0:
+		return fmt.Errorf("aggregate function %s requires a window", aggs[0].OutputAlias)
+	case len(s.GroupBy) > 0:
+		return fmt.Errorf("GROUP BY requires a window")
+	case s.Having != nil:
+		return fmt.Errorf("HAVING requires a window")
+	case len(s.OrderBy) > 0:
+		return fmt.Errorf("ORDER BY requires a window")
+	case s.Limit > 0:
+		return fmt.Errorf("LIMIT requires a window")
 	}
-	if strings.Contains(expr, "sum(") {
-		return "sum", extractAggField(expr)
+	return nil
+}
+
+// isAggregate 判断函数调用是否为聚合函数，带 OVER 子句的调用是分析函数而不是聚合函数
+func isAggregate(call *expr.CallExpr) bool {
+	return call.Over == nil && aggregator.IsAggregate(strings.ToLower(call.Name))
+}
+
+// aggregateType 返回聚合函数调用的聚合类型，count(DISTINCT field) 为 aggregator.CountDistinct
+func aggregateType(call *expr.CallExpr) aggregator.AggregateType {
+	aggType := aggregator.AggregateType(strings.ToLower(call.Name))
+	if aggType == aggregator.Count && call.Distinct {
+		return aggregator.CountDistinct
 	}
-	if strings.Contains(expr, "max(") {
-		return "max", extractAggField(expr)
+	return aggType
+}
+
+// buildAggregations 从查询字段、HAVING 条件和 ORDER BY 排序项的语法树中收集聚合函数调用。
+// 每个不同的聚合调用只计算一次，结果以调用的规范文本为名称写入分组结果，
+// 投影时表达式中的聚合调用通过该名称取得聚合结果，因此 avg(a)+max(b) 这样的表达式可以直接求值
+func buildAggregations(fields []Field, having expr.Expr, orderBy []expr.OrderItem) ([]aggregator.AggregationField, error) {
+	var aggs []aggregator.AggregationField
+	seen := make(map[string]bool)
+	var err error
+	nodes := make([]expr.Expr, 0, len(fields)+1)
+	for _, f := range fields {
+		nodes = append(nodes, f.Expr)
 	}
-	if strings.Contains(expr, "min(") {
-		return "min", extractAggField(expr)
+	if having != nil {
+		nodes = append(nodes, having)
 	}
-	if strings.Contains(expr, "window_start(") {
-		return "window_start", "window_start"
+	for _, item := range orderBy {
+		nodes = append(nodes, item.Expr)
 	}
-	if strings.Contains(expr, "window_end(") {
-		return "window_end", "window_end"
+	for _, node := range nodes {
+		expr.Walk(node, func(node expr.Expr) bool {
+			call, ok := node.(*expr.CallExpr)
+			if !ok || !isAggregate(call) || err != nil {
+				return err == nil
+			}
+			for _, arg := range call.Args {
+				expr.Walk(arg, func(inner expr.Expr) bool {
+					if c, ok := inner.(*expr.CallExpr); ok && isAggregate(c) && err == nil {
+						err = fmt.Errorf("aggregate function calls cannot be nested: %s", call.String())
+					}
+					return err == nil
+				})
+			}
+			name := call.String()
+			if err == nil && !seen[name] {
+				seen[name] = true
+				input, inputExpr, args, params := aggregateInput(call)
+				aggType := aggregateType(call)
+				// 创建一次聚合器以检查常量参数的取值，如 percentile 的比例必须在 0 到 1 之间
+				if _, err = aggregator.CreateAggregator(aggType, params); err != nil {
+					return false
+				}
+				aggs = append(aggs, aggregator.AggregationField{
+					InputField:    input,
+					Expr:          inputExpr,
+					Args:          args,
+					Params:        params,
+					AggregateType: aggType,
+					OutputAlias:   name,
+				})
+			}
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
-	return "", ""
+	return aggs, nil
 }
 
-func extractAggField(expr string) string {
-	start := strings.Index(expr, "(")
-	end := strings.LastIndex(expr, ")")
-	if start >= 0 && end > start {
-		// 提取括号内的内容
-		fieldExpr := strings.TrimSpace(expr[start+1 : end])
-
-		// TODO 后期需完善函数内的运算表达式解析
-		// 如果包含运算符，提取第一个操作数作为字段名，形如 temperature/10 的表达式，应解析出字段temperature
-		for _, op := range []string{"/", "*", "+", "-"} {
-			if opIndex := strings.Index(fieldExpr, op); opIndex > 0 {
-				return strings.TrimSpace(fieldExpr[:opIndex])
+// aggregateInput 返回聚合函数的输入，参数为字段时返回字段名，参数为表达式时返回表达式，
+// 如 avg(temperature/10) 对每条数据计算 temperature/10 后再聚合。
+// 按聚合函数的签名，多参数聚合返回各参数的表达式，如 weighted_avg(value, weight)，
+// 签名中的常量参数返回其值，如 percentile(latency, 0.99) 中的 0.99
+func aggregateInput(call *expr.CallExpr) (string, expr.Expr, []expr.Expr, []interface{}) {
+	rows := call.Args
+	var params []interface{}
+	if sig, ok := aggregator.SignatureOf(call.Name); ok && len(rows) > len(sig.Args) {
+		rows = call.Args[:len(sig.Args)]
+		for _, arg := range call.Args[len(sig.Args):] {
+			if lit, ok := arg.(*expr.Literal); ok {
+				params = append(params, lit.Value)
 			}
 		}
-
-		return fieldExpr
 	}
-	return ""
+	switch {
+	case len(rows) == 0:
+		return "", nil, nil, params
+	case len(rows) > 1:
+		return "", nil, rows, params
+	}
+	if ident, ok := rows[0].(*expr.Ident); ok {
+		return ident.Name, nil, nil, params
+	}
+	return "", rows[0], nil, params
 }
 
 func parseWindowParams(params []interface{}) (map[string]interface{}, error) {
@@ -164,19 +405,3 @@ func parseWindowParams(params []interface{}) (map[string]interface{}, error) {
 
 	return result, nil
 }
-
-func parseAggregateExpression(expr string) string {
-	if strings.Contains(expr, "avg(") {
-		return "avg"
-	}
-	if strings.Contains(expr, "sum(") {
-		return "sum"
-	}
-	if strings.Contains(expr, "max(") {
-		return "max"
-	}
-	if strings.Contains(expr, "min(") {
-		return "min"
-	}
-	return ""
-}
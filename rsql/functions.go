@@ -0,0 +1,106 @@
+package rsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/functions"
+)
+
+// checkFunctions 检查语句中所有的标量函数调用：函数必须已注册，参数个数以及常量和嵌套函数调用的参数类型必须符合函数签名
+func checkFunctions(s *SelectStatement) error {
+	nodes := make([]expr.Expr, 0, len(s.Fields)+len(s.GroupBy)+len(s.OrderBy)+2)
+	for _, f := range s.Fields {
+		nodes = append(nodes, f.Expr)
+	}
+	nodes = append(nodes, s.Where, s.Having)
+	nodes = append(nodes, s.GroupBy...)
+	for _, item := range s.OrderBy {
+		nodes = append(nodes, item.Expr)
+	}
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		if _, err := staticType(node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staticType 推导表达式在编译时可以确定的类型并检查其中的函数调用，只有常量和标量函数调用的类型可以确定
+func staticType(node expr.Expr) (functions.ArgType, error) {
+	switch n := node.(type) {
+	case *expr.Literal:
+		return literalArgType(n.Value), nil
+	case *expr.CallExpr:
+		args := make([]functions.ArgType, len(n.Args))
+		for i, arg := range n.Args {
+			t, err := staticType(arg)
+			if err != nil {
+				return functions.TypeAny, err
+			}
+			args[i] = t
+		}
+		// 只有 count 接受 * 和 DISTINCT
+		if (n.Star || n.Distinct) && (!isAggregate(n) || aggregator.AggregateType(strings.ToLower(n.Name)) != aggregator.Count) {
+			modifier := "*"
+			if n.Distinct {
+				modifier = "DISTINCT"
+			}
+			return functions.TypeAny, fmt.Errorf("%s does not accept %s", n.Name, modifier)
+		}
+		// 分析函数不是标量函数，聚合函数按聚合函数的签名检查
+		if n.Over != nil {
+			return functions.TypeAny, nil
+		}
+		if isAggregate(n) {
+			return checkAggregate(n, args)
+		}
+		return functions.Check(n.Name, args)
+	}
+	var err error
+	expr.Walk(node, func(child expr.Expr) bool {
+		if child == node {
+			return true
+		}
+		if err == nil {
+			_, err = staticType(child)
+		}
+		return false
+	})
+	return functions.TypeAny, err
+}
+
+// checkAggregate 按聚合函数的签名检查参数个数和可以确定的参数类型，常量参数必须是常量，返回聚合结果的类型
+func checkAggregate(n *expr.CallExpr, args []functions.ArgType) (functions.ArgType, error) {
+	if n.Star {
+		return functions.TypeNumber, nil
+	}
+	sig, _ := aggregator.SignatureOf(string(aggregateType(n)))
+	if err := sig.Check(n.Name, args); err != nil {
+		return functions.TypeAny, err
+	}
+	for i, arg := range n.Args {
+		if _, ok := arg.(*expr.Literal); sig.IsParam(i) && !ok {
+			return functions.TypeAny, fmt.Errorf("%s argument %d must be a constant", n.Name, i+1)
+		}
+	}
+	return sig.Result, nil
+}
+
+// literalArgType 返回常量作为函数参数的类型，NULL 可以作为任意类型的参数
+func literalArgType(v interface{}) functions.ArgType {
+	switch v.(type) {
+	case int64, float64:
+		return functions.TypeNumber
+	case string:
+		return functions.TypeString
+	case bool:
+		return functions.TypeBool
+	}
+	return functions.TypeAny
+}
@@ -6,6 +6,7 @@ type TokenType int
 
 const (
 	TokenEOF TokenType = iota
+	TokenIllegal
 	TokenIdent
 	TokenNumber
 	TokenString
@@ -16,13 +17,14 @@ const (
 	TokenMinus
 	TokenAsterisk
 	TokenSlash
+	TokenPercent
 	TokenEQ
-	TokenStrEQ
 	TokenNE
 	TokenGT
 	TokenLT
 	TokenGE
 	TokenLE
+	TokenNOT
 	TokenAND
 	TokenOR
 	TokenSELECT
@@ -31,21 +33,50 @@ const (
 	TokenGROUP
 	TokenBY
 	TokenAS
-	TokenTumbling
-	TokenSliding
-	TokenCounting
-	TokenSession
 	TokenWITH
-	TokenTimestamp
-	TokenTimeUnit
 	TokenOrder
+	TokenHAVING
+	TokenIN
+	TokenIS
+	TokenNULL
+	TokenTRUE
+	TokenFALSE
+	TokenCASE
+	TokenWHEN
+	TokenTHEN
+	TokenELSE
+	TokenEND
+	TokenOVER
+	TokenPARTITION
+	TokenLIMIT
+	TokenDISTINCT
+	// Deprecated: 空白字符被跳过，词法分析器不再产生空白标记
 	TokenSpace
 )
 
+// 以下标记类型已合并到其他标记类型，保留以兼容引用这些常量的代码
+const (
+	// Deprecated: 字符串的相等比较与其他相等比较一样使用 TokenEQ
+	TokenStrEQ = TokenEQ
+	// Deprecated: 窗口函数按普通函数调用解析，函数名为 TokenIdent
+	TokenTumbling = TokenIdent
+	// Deprecated: 窗口函数按普通函数调用解析，函数名为 TokenIdent
+	TokenSliding = TokenIdent
+	// Deprecated: 窗口函数按普通函数调用解析，函数名为 TokenIdent
+	TokenCounting = TokenIdent
+	// Deprecated: 窗口函数按普通函数调用解析，函数名为 TokenIdent
+	TokenSession = TokenIdent
+	// Deprecated: WITH 子句的选项名为 TokenIdent
+	TokenTimestamp = TokenIdent
+	// Deprecated: WITH 子句的选项名为 TokenIdent
+	TokenTimeUnit = TokenIdent
+)
+
 type Token struct {
 	Type  TokenType
 	Value string
-	Pos   int
+	// Pos 标记在输入中的起始位置
+	Pos int
 }
 
 type Lexer struct {
@@ -53,7 +84,6 @@ type Lexer struct {
 	pos     int
 	readPos int
 	ch      byte
-	cuurent Token
 }
 
 func NewLexer(input string) *Lexer {
@@ -62,102 +92,98 @@ func NewLexer(input string) *Lexer {
 	return l
 }
 
+// NextToken 读取下一个标记，空白字符被跳过
 func (l *Lexer) NextToken() Token {
-	// 检查是否有空格，如果有则返回空格标记
-	if isWhitespace(l.ch) {
-		return l.readSpace()
-	}
+	l.skipWhitespace()
+	pos := l.pos
+	tok := l.readToken()
+	tok.Pos = pos
+	return tok
+}
 
+func (l *Lexer) readToken() Token {
 	switch l.ch {
 	case 0:
-		l.cuurent = Token{Type: TokenEOF}
-		return l.cuurent
+		return Token{Type: TokenEOF}
 	case ',':
-		l.readChar()
-		l.cuurent = Token{Type: TokenComma, Value: ","}
-		return l.cuurent
+		return l.single(TokenComma)
 	case '(':
-		l.readChar()
-		l.cuurent = Token{Type: TokenLParen, Value: "("}
-		return l.cuurent
+		return l.single(TokenLParen)
 	case ')':
-		l.readChar()
-		l.cuurent = Token{Type: TokenRParen, Value: ")"}
-		return l.cuurent
+		return l.single(TokenRParen)
 	case '+':
-		l.readChar()
-		l.cuurent = Token{Type: TokenPlus, Value: "+"}
-		return l.cuurent
+		return l.single(TokenPlus)
 	case '-':
-		l.readChar()
-		l.cuurent = Token{Type: TokenMinus, Value: "-"}
-		return l.cuurent
+		return l.single(TokenMinus)
 	case '*':
-		l.readChar()
-		l.cuurent = Token{Type: TokenAsterisk, Value: "*"}
-		return l.cuurent
+		return l.single(TokenAsterisk)
 	case '/':
-		l.readChar()
-		l.cuurent = Token{Type: TokenSlash, Value: "/"}
-		return l.cuurent
+		return l.single(TokenSlash)
+	case '%':
+		return l.single(TokenPercent)
 	case '=':
 		if l.peekChar() == '=' {
-			l.readChar()
-			l.readChar()
-			l.cuurent = Token{Type: TokenEQ, Value: "=="}
-			return l.cuurent
+			return l.double(TokenEQ)
 		}
-		l.readChar()
-		l.cuurent = Token{Type: TokenEQ, Value: "="}
-		return l.cuurent
+		return l.single(TokenEQ)
 	case '>':
 		if l.peekChar() == '=' {
-			l.readChar()
-			l.readChar()
-			l.cuurent = Token{Type: TokenGE, Value: ">="}
-			return l.cuurent
+			return l.double(TokenGE)
 		}
-		l.readChar()
-		l.cuurent = Token{Type: TokenGT, Value: ">"}
-		return l.cuurent
+		return l.single(TokenGT)
 	case '<':
-		if l.peekChar() == '=' {
-			l.readChar()
-			l.readChar()
-			l.cuurent = Token{Type: TokenLE, Value: "<="}
-			return l.cuurent
+		switch l.peekChar() {
+		case '=':
+			return l.double(TokenLE)
+		case '>':
+			return l.double(TokenNE)
 		}
-		l.readChar()
-		l.cuurent = Token{Type: TokenLT, Value: "<"}
-		return l.cuurent
+		return l.single(TokenLT)
 	case '!':
 		if l.peekChar() == '=' {
-			l.readChar()
-			l.readChar()
-			l.cuurent = Token{Type: TokenStrEQ, Value: "!="}
-			return l.cuurent
+			return l.double(TokenNE)
+		}
+		return l.single(TokenNOT)
+	case '&':
+		if l.peekChar() == '&' {
+			return l.double(TokenAND)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			return l.double(TokenOR)
 		}
+	case '\'', '"':
+		return l.readString()
+	case '`':
+		return l.readQuotedIdentifier()
 	}
 
 	if isLetter(l.ch) {
-		ident := l.readIdentifier()
-		l.cuurent = l.lookupIdent(ident)
-		return l.cuurent
+		return l.lookupIdent(l.readIdentifier())
 	}
 
 	if isDigit(l.ch) {
-		l.cuurent = Token{Type: TokenNumber, Value: l.readNumber()}
-		return l.cuurent
+		return Token{Type: TokenNumber, Value: l.readNumber()}
 	}
 
-	if l.ch == '\'' {
-		l.cuurent = Token{Type: TokenString, Value: l.readString()}
-		return l.cuurent
-	}
+	tok := Token{Type: TokenIllegal, Value: string(l.ch)}
+	l.readChar()
+	return tok
+}
 
+// single 读取单字符标记
+func (l *Lexer) single(t TokenType) Token {
+	tok := Token{Type: t, Value: string(l.ch)}
 	l.readChar()
-	l.cuurent = Token{Type: TokenEOF}
-	return l.cuurent
+	return tok
+}
+
+// double 读取双字符标记
+func (l *Lexer) double(t TokenType) Token {
+	tok := Token{Type: t, Value: l.input[l.pos : l.pos+2]}
+	l.readChar()
+	l.readChar()
+	return tok
 }
 
 func (l *Lexer) readChar() {
@@ -177,35 +203,15 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 }
 
+// readIdentifier 读取标识符，点号连接的标识符作为一个整体读取，如 payload.meta.site 表示嵌套字段的路径
 func (l *Lexer) readIdentifier() string {
 	pos := l.pos
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || isDigit(l.ch) || (l.ch == '.' && isLetter(l.peekChar())) {
 		l.readChar()
 	}
 	return l.input[pos:l.pos]
 }
 
-func (l *Lexer) readPreviousIdentifier() string {
-	// 保存当前位置
-	endPos := l.pos
-
-	// 向前移动直到找到非字母字符或到达输入开始
-	startPos := endPos - 1
-	for startPos >= 0 && isLetter(l.input[startPos]) {
-		startPos--
-	}
-
-	// 调整到第一个字母字符的位置
-	startPos++
-
-	// 如果找到有效的标识符，返回它
-	if startPos < endPos {
-		return l.input[startPos:endPos]
-	}
-
-	return ""
-}
-
 func (l *Lexer) readNumber() string {
 	pos := l.pos
 	for isDigit(l.ch) || l.ch == '.' {
@@ -214,64 +220,82 @@ func (l *Lexer) readNumber() string {
 	return l.input[pos:l.pos]
 }
 
-func (l *Lexer) readString() string {
-	pos := l.pos
+// readString 读取单引号或双引号包裹的字符串，连续两个引号表示引号本身。
+// 标记的值为去掉引号后的字符串内容，字符串没有结束引号时返回 TokenIllegal
+func (l *Lexer) readString() Token {
+	quote := l.ch
+	var sb strings.Builder
 	l.readChar()
 	for {
-		if l.ch == '\'' {
+		switch l.ch {
+		case 0:
+			return Token{Type: TokenIllegal, Value: "unterminated string"}
+		case quote:
 			l.readChar()
-			break
+			if l.ch != quote {
+				return Token{Type: TokenString, Value: sb.String()}
+			}
 		}
+		sb.WriteByte(l.ch)
 		l.readChar()
 	}
+}
 
-	str := l.input[pos:l.pos]
-	return str
+// readQuotedIdentifier 读取反引号包裹的标识符，用于包含特殊字符或与关键字同名的字段
+func (l *Lexer) readQuotedIdentifier() Token {
+	l.readChar()
+	pos := l.pos
+	for l.ch != '`' {
+		if l.ch == 0 {
+			return Token{Type: TokenIllegal, Value: "unterminated identifier"}
+		}
+		l.readChar()
+	}
+	ident := l.input[pos:l.pos]
+	l.readChar()
+	return Token{Type: TokenIdent, Value: ident}
 }
 
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for isWhitespace(l.ch) {
 		l.readChar()
 	}
 }
 
+var keywords = map[string]TokenType{
+	"SELECT":    TokenSELECT,
+	"FROM":      TokenFROM,
+	"WHERE":     TokenWHERE,
+	"GROUP":     TokenGROUP,
+	"BY":        TokenBY,
+	"AS":        TokenAS,
+	"OR":        TokenOR,
+	"AND":       TokenAND,
+	"NOT":       TokenNOT,
+	"WITH":      TokenWITH,
+	"ORDER":     TokenOrder,
+	"HAVING":    TokenHAVING,
+	"IN":        TokenIN,
+	"IS":        TokenIS,
+	"NULL":      TokenNULL,
+	"TRUE":      TokenTRUE,
+	"FALSE":     TokenFALSE,
+	"CASE":      TokenCASE,
+	"WHEN":      TokenWHEN,
+	"THEN":      TokenTHEN,
+	"ELSE":      TokenELSE,
+	"END":       TokenEND,
+	"OVER":      TokenOVER,
+	"PARTITION": TokenPARTITION,
+	"LIMIT":     TokenLIMIT,
+	"DISTINCT":  TokenDISTINCT,
+}
+
 func (l *Lexer) lookupIdent(ident string) Token {
-	switch strings.ToUpper(ident) {
-	case "SELECT":
-		return Token{Type: TokenSELECT, Value: ident}
-	case "FROM":
-		return Token{Type: TokenFROM, Value: ident}
-	case "WHERE":
-		return Token{Type: TokenWHERE, Value: ident}
-	case "GROUP":
-		return Token{Type: TokenGROUP, Value: ident}
-	case "BY":
-		return Token{Type: TokenBY, Value: ident}
-	case "AS":
-		return Token{Type: TokenAS, Value: ident}
-	case "OR":
-		return Token{Type: TokenOR, Value: ident}
-	case "AND":
-		return Token{Type: TokenAND, Value: ident}
-	case "TUMBLINGWINDOW":
-		return Token{Type: TokenTumbling, Value: ident}
-	case "SLIDINGWINDOW":
-		return Token{Type: TokenSliding, Value: ident}
-	case "COUNTINGWINDOW":
-		return Token{Type: TokenCounting, Value: ident}
-	case "SESSIONWINDOW":
-		return Token{Type: TokenSession, Value: ident}
-	case "WITH":
-		return Token{Type: TokenWITH, Value: ident}
-	case "TIMESTAMP":
-		return Token{Type: TokenTimestamp, Value: ident}
-	case "TIMEUNIT":
-		return Token{Type: TokenTimeUnit, Value: ident}
-	case "ORDER":
-		return Token{Type: TokenOrder, Value: ident}
-	default:
-		return Token{Type: TokenIdent, Value: ident}
+	if t, ok := keywords[strings.ToUpper(ident)]; ok {
+		return Token{Type: t, Value: ident}
 	}
+	return Token{Type: TokenIdent, Value: ident}
 }
 
 func isLetter(ch byte) bool {
@@ -286,15 +310,3 @@ func isDigit(ch byte) bool {
 func isWhitespace(ch byte) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
 }
-
-// 新增方法：读取并压缩连续空格
-func (l *Lexer) readSpace() Token {
-	// 读取所有连续的空白字符
-	for isWhitespace(l.ch) {
-		l.readChar()
-	}
-
-	// 无论有多少连续空格，都返回一个单空格标记
-	l.cuurent = Token{Type: TokenSpace, Value: " "}
-	return l.cuurent
-}
@@ -2,23 +2,138 @@ package rsql
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/expr-lang/expr"
-	"github.com/expr-lang/expr/ast"
+	"github.com/rulego/streamsql/expr"
 	"github.com/rulego/streamsql/model"
 )
 
+// windowFunctions 可以出现在 GROUP BY 中的窗口函数
+var windowFunctions = map[string]bool{
+	"TUMBLINGWINDOW": true,
+	"SLIDINGWINDOW":  true,
+	"COUNTINGWINDOW": true,
+	"SESSIONWINDOW":  true,
+}
+
+var overClauseRegex = regexp.MustCompile(`(?i)\s*OVER\s*\(.*\)\s*$`)
+
+// Parser 递归下降的 SQL 解析器，将 SQL 语句解析为 SelectStatement，表达式解析为 expr 语法树
 type Parser struct {
 	lexer *Lexer
+	input string
+	// tok 当前标记
+	tok Token
+	// end 当前标记的结束位置
+	end int
+	// prevEnd 上一个标记的结束位置
+	prevEnd int
 }
 
 func NewParser(input string) *Parser {
-	return &Parser{
+	p := &Parser{
 		lexer: NewLexer(input),
+		input: input,
+	}
+	p.next()
+	return p
+}
+
+// ParseExpression 将表达式文本解析为语法树，如 "deviceId == 'aa' && temperature > 10"
+func ParseExpression(input string) (expr.Expr, error) {
+	p := NewParser(input)
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
 	}
+	if p.tok.Type != TokenEOF {
+		return nil, p.unexpected()
+	}
+	return node, nil
+}
+
+// ParseStatement 解析 SELECT 查询语句或 CREATE STREAM 语句
+func (p *Parser) ParseStatement() (Statement, error) {
+	if p.tok.Type == TokenIdent && strings.EqualFold(p.tok.Value, "CREATE") {
+		return p.ParseCreateStream()
+	}
+	return p.Parse()
+}
+
+// ParseCreateStream 解析 CREATE STREAM 语句，如
+// CREATE STREAM sensors (deviceId STRING, temperature FLOAT, ts TIMESTAMP) WITH (TIMESTAMP='ts', FORMAT='json')
+func (p *Parser) ParseCreateStream() (*CreateStreamStatement, error) {
+	if err := p.expectWord("CREATE"); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("STREAM"); err != nil {
+		return nil, err
+	}
+	if p.tok.Type != TokenIdent {
+		return nil, fmt.Errorf("expected stream name but got %s at position %d", describe(p.tok), p.tok.Pos)
+	}
+	stmt := &CreateStreamStatement{Name: p.tok.Value, Format: model.FormatJSON}
+	p.next()
+	if err := p.expect(TokenLParen, "("); err != nil {
+		return nil, err
+	}
+	for {
+		if !isName(p.tok) {
+			return nil, fmt.Errorf("expected field name but got %s at position %d", describe(p.tok), p.tok.Pos)
+		}
+		field := model.SchemaField{Name: p.tok.Value}
+		p.next()
+		if !isName(p.tok) {
+			return nil, fmt.Errorf("expected data type of field %s but got %s at position %d", field.Name, describe(p.tok), p.tok.Pos)
+		}
+		dt, err := model.ParseDataType(p.tok.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w at position %d", err, p.tok.Pos)
+		}
+		field.Type = dt
+		p.next()
+		stmt.Fields = append(stmt.Fields, field)
+		if p.tok.Type != TokenComma {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect(TokenRParen, ")"); err != nil {
+		return nil, err
+	}
+	if p.tok.Type == TokenWITH {
+		err := p.parseWithOptions(func(key, value string) error {
+			switch key {
+			case "TIMESTAMP":
+				stmt.TsProp = value
+			case "FORMAT":
+				stmt.Format = strings.ToLower(value)
+			default:
+				return fmt.Errorf("unknown WITH option %s", key)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.tok.Type != TokenEOF {
+		return nil, p.unexpected()
+	}
+	return stmt, nil
+}
+
+// expectWord 检查当前标记是否为不区分大小写的单词 word 并读取下一个标记，用于不作为关键字的 CREATE、STREAM
+func (p *Parser) expectWord(word string) error {
+	if p.tok.Type != TokenIdent || !strings.EqualFold(p.tok.Value, word) {
+		return fmt.Errorf("expected %s but got %s at position %d", word, describe(p.tok), p.tok.Pos)
+	}
+	p.next()
+	return nil
 }
 
 func (p *Parser) Parse() (*SelectStatement, error) {
@@ -36,302 +151,769 @@ func (p *Parser) Parse() (*SelectStatement, error) {
 		return nil, err
 	}
 
-	// 解析WHERE子句
-	if err := p.parseWhere(stmt); err != nil {
-		return nil, err
+	// 解析其余子句，窗口函数可以直接跟在 FROM、WHERE 子句之后
+	for p.tok.Type != TokenEOF {
+		var err error
+		switch p.tok.Type {
+		case TokenWHERE:
+			err = p.parseWhere(stmt)
+		case TokenGROUP:
+			err = p.parseGroupBy(stmt)
+		case TokenHAVING:
+			err = p.parseHaving(stmt)
+		case TokenOrder:
+			err = p.parseOrderBy(stmt)
+		case TokenLIMIT:
+			err = p.parseLimit(stmt)
+		case TokenWITH:
+			err = p.parseWith(stmt)
+		case TokenIdent:
+			err = p.parseWindow(stmt)
+		default:
+			err = p.unexpected()
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// 解析GROUP BY子句
-	if err := p.parseGroupBy(stmt); err != nil {
-		return nil, err
+	return stmt, nil
+}
+
+// next 读取下一个标记
+func (p *Parser) next() {
+	p.prevEnd = p.end
+	p.tok = p.lexer.NextToken()
+	p.end = p.lexer.pos
+	if p.end > len(p.input) {
+		p.end = len(p.input)
 	}
+}
 
-	if err := p.parseWith(stmt); err != nil {
-		return nil, err
+// expect 检查当前标记的类型并读取下一个标记
+func (p *Parser) expect(t TokenType, what string) error {
+	if p.tok.Type != t {
+		return fmt.Errorf("expected %s but got %s at position %d", what, describe(p.tok), p.tok.Pos)
 	}
+	p.next()
+	return nil
+}
 
-	return stmt, nil
+// unexpected 返回当前标记不符合语法的错误
+func (p *Parser) unexpected() error {
+	if p.tok.Type == TokenIllegal {
+		return fmt.Errorf("illegal token %s at position %d", describe(p.tok), p.tok.Pos)
+	}
+	return fmt.Errorf("unexpected %s at position %d", describe(p.tok), p.tok.Pos)
 }
+
+func describe(tok Token) string {
+	if tok.Type == TokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(tok.Value)
+}
+
+// isName 判断标记是否可以作为名称使用，别名和 WITH 参数名允许与关键字同名，如 window_end() as end
+func isName(tok Token) bool {
+	if tok.Type == TokenIdent {
+		return true
+	}
+	_, ok := keywords[strings.ToUpper(tok.Value)]
+	return ok && tok.Value != ""
+}
+
 func (p *Parser) parseSelect(stmt *SelectStatement) error {
-	p.lexer.NextToken() // 跳过SELECT
-	currentToken := p.lexer.NextToken()
+	if err := p.expect(TokenSELECT, "SELECT"); err != nil {
+		return err
+	}
 	proj := make(model.Projection, 0)
 	for {
-		var expr strings.Builder
-		parenBalance := 0 // 用于跟踪当前表达式片段的括号平衡
-		for {
-			// 更新括号平衡计数器
-			if currentToken.Type == TokenLParen {
-				parenBalance++
-			} else if currentToken.Type == TokenRParen {
-				parenBalance--
-			}
-			if currentToken.Type == TokenFROM ||
-				(currentToken.Type == TokenComma && parenBalance == 0) ||
-				currentToken.Type == TokenAS {
-				break
-			}
-			expr.WriteString(currentToken.Value)
-			currentToken = p.lexer.NextToken()
+		start := p.tok.Pos
+		node, err := p.parseExpr()
+		if err != nil {
+			return err
+		}
+		field := Field{
+			Expression: compact(p.input[start:p.prevEnd]),
+			Expr:       node,
+		}
+		if call, ok := node.(*expr.CallExpr); ok && isAggregate(call) {
+			field.AggType = strings.ToLower(call.Name)
 		}
-		field := Field{Expression: strings.TrimSpace(expr.String())}
 
 		// 处理别名
-		if currentToken.Type == TokenAS {
-			next := p.lexer.NextToken()
-			if next.Type == TokenSpace {
-				next = p.lexer.NextToken()
+		if p.tok.Type == TokenAS {
+			p.next()
+			if !isName(p.tok) {
+				return fmt.Errorf("expected alias after AS but got %s at position %d", describe(p.tok), p.tok.Pos)
 			}
-			field.Alias = next.Value
+			field.Alias = p.tok.Value
+			p.next()
 		}
 		stmt.Fields = append(stmt.Fields, field)
-		if len(field.Expression) > 0 {
-			meta := model.ExprMeta{
-				Expression: field.Expression,
-				Type:       determineExprType(field.Expression),
-				Name:       field.Expression,
-				Alias:      field.Alias,
-			}
-			meta.ParseArgs()
-			proj = append(proj, meta)
-		}
-		if currentToken.Type == TokenFROM {
+		proj = append(proj, newExprMeta(node, field.Expression, field.Alias))
+		if p.tok.Type != TokenComma {
 			break
 		}
-		currentToken = p.lexer.NextToken()
+		p.next()
 	}
 	stmt.Context.Projection = proj
 	return nil
 }
 
-func (p *Parser) parseWhere(stmt *SelectStatement) error {
-	var conditions []string
-	current := p.lexer.NextToken() // 跳过WHERE
-	if current.Type != TokenWHERE {
-		return nil
+// newExprMeta 根据表达式语法树创建投影字段的元数据
+func newExprMeta(node expr.Expr, text string, alias string) model.ExprMeta {
+	meta := model.ExprMeta{
+		Expression: text,
+		Name:       text,
+		Alias:      alias,
+		Type:       exprType(node),
+		Node:       node,
 	}
-	for {
-		tok := p.lexer.NextToken()
-		if tok.Type == TokenGROUP || tok.Type == TokenEOF || tok.Type == TokenSliding ||
-			tok.Type == TokenTumbling || tok.Type == TokenCounting || tok.Type == TokenSession {
-			break
+	call, ok := node.(*expr.CallExpr)
+	if !ok || meta.Type != model.Func {
+		return meta
+	}
+	meta.Name = overClauseRegex.ReplaceAllString(text, "")
+	meta.Args = make([]any, len(call.Args))
+	for i, arg := range call.Args {
+		if lit, ok := arg.(*expr.Literal); ok {
+			if s, ok := lit.Value.(string); ok {
+				meta.Args[i] = s
+				continue
+			}
 		}
-		switch tok.Type {
-		case TokenIdent, TokenNumber:
-			conditions = append(conditions, tok.Value)
-		case TokenString:
-			conditions = append(conditions, "'"+tok.Value+"'")
-		case TokenEQ:
-			conditions = append(conditions, "==")
-		case TokenAND:
-			conditions = append(conditions, "&&")
-		case TokenOR:
-			conditions = append(conditions, "||")
-		default:
-			// 处理字符串值的引号
-			if len(conditions) > 0 && conditions[len(conditions)-1] == "'" {
-				conditions[len(conditions)-1] = conditions[len(conditions)-1] + tok.Value
-			} else {
-				conditions = append(conditions, tok.Value)
+		meta.Args[i] = arg.String()
+	}
+	if call.Over != nil {
+		meta.OverClause = &model.OverClause{}
+		for _, e := range call.Over.PartitionBy {
+			meta.OverClause.PartitionBy = append(meta.OverClause.PartitionBy, newExprMeta(e, e.String(), ""))
+		}
+		for _, item := range call.Over.OrderBy {
+			orderMeta := newExprMeta(item.Expr, item.Expr.String(), "")
+			if item.Desc {
+				orderMeta.Sort = model.DESC
 			}
+			meta.OverClause.OrderBy = append(meta.OverClause.OrderBy, orderMeta)
 		}
+	}
+	return meta
+}
+
+// compact 将连续的空白字符压缩为一个空格
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
 
+func (p *Parser) parseFrom(stmt *SelectStatement) error {
+	if err := p.expect(TokenFROM, "FROM"); err != nil {
+		return err
+	}
+	if p.tok.Type != TokenIdent {
+		return errors.New("expected source identifier after FROM")
 	}
-	stmt.Condition = strings.Join(conditions, " ")
+	stmt.Source = p.tok.Value
+	p.next()
 	return nil
 }
 
-func (p *Parser) parseWindowFunction(stmt *SelectStatement, winType string) error {
-	p.lexer.NextToken() // 跳过(
-	var params []interface{}
+func (p *Parser) parseWhere(stmt *SelectStatement) error {
+	p.next() // 跳过WHERE
+	node, err := p.parseExpr()
+	if err != nil {
+		return err
+	}
+	stmt.Where = node
+	stmt.Condition = node.String()
+	return nil
+}
 
-	for p.lexer.peekChar() != ')' {
-		valTok := p.lexer.NextToken()
-		if valTok.Type == TokenRParen || valTok.Type == TokenEOF {
-			break
+func (p *Parser) parseGroupBy(stmt *SelectStatement) error {
+	p.next() // 跳过GROUP
+	if err := p.expect(TokenBY, "BY"); err != nil {
+		return err
+	}
+	for {
+		node, err := p.parseExpr()
+		if err != nil {
+			return err
 		}
-		if valTok.Type == TokenComma {
-			continue
+		if call, ok := node.(*expr.CallExpr); ok && windowFunctions[strings.ToUpper(call.Name)] {
+			if err := setWindow(stmt, call); err != nil {
+				return err
+			}
+		} else {
+			stmt.GroupBy = append(stmt.GroupBy, node)
 		}
-		//valTok := p.lexer.NextToken()
-		// 处理引号包裹的值
-		if strings.HasPrefix(valTok.Value, "'") && strings.HasSuffix(valTok.Value, "'") {
-			valTok.Value = strings.Trim(valTok.Value, "'")
+		if p.tok.Type != TokenComma {
+			return nil
 		}
-		params = append(params, convertValue(valTok.Value))
+		p.next()
+	}
+}
+
+// parseWindow 解析直接跟在 FROM、WHERE 子句之后的窗口函数
+func (p *Parser) parseWindow(stmt *SelectStatement) error {
+	if !windowFunctions[strings.ToUpper(p.tok.Value)] {
+		return p.unexpected()
 	}
+	node, err := p.parsePrimary()
+	if err != nil {
+		return err
+	}
+	call, ok := node.(*expr.CallExpr)
+	if !ok {
+		return fmt.Errorf("expected window function %s(...)", node.String())
+	}
+	return setWindow(stmt, call)
+}
 
-	if &stmt.Window != nil {
-		stmt.Window.Params = params
-		stmt.Window.Type = winType
-	} else {
-		stmt.Window = WindowDefinition{
-			Type:   winType,
-			Params: params,
+// setWindow 根据窗口函数设置窗口定义，窗口函数的参数必须为常量
+func setWindow(stmt *SelectStatement, call *expr.CallExpr) error {
+	params := make([]interface{}, 0, len(call.Args))
+	for _, arg := range call.Args {
+		lit, ok := arg.(*expr.Literal)
+		if !ok {
+			return fmt.Errorf("window function %s only accepts constant arguments, got %s", call.Name, arg.String())
 		}
+		v := lit.Value
+		if i, ok := v.(int64); ok {
+			v = int(i)
+		}
+		params = append(params, v)
 	}
+	stmt.Window.Type = call.Name
+	stmt.Window.Params = params
 	return nil
 }
 
-func convertValue(s string) interface{} {
-	if s == "true" {
-		return true
+func (p *Parser) parseHaving(stmt *SelectStatement) error {
+	p.next() // 跳过HAVING
+	start := p.tok.Pos
+	node, err := p.parseExpr()
+	if err != nil {
+		return err
 	}
-	if s == "false" {
-		return false
+	stmt.Having = node
+	stmt.Context.Having = model.Having{newExprMeta(node, compact(p.input[start:p.prevEnd]), "")}
+	return nil
+}
+
+func (p *Parser) parseOrderBy(stmt *SelectStatement) error {
+	p.next() // 跳过ORDER
+	if err := p.expect(TokenBY, "BY"); err != nil {
+		return err
 	}
-	if i, err := strconv.Atoi(s); err == nil {
-		return i
+	items, err := p.parseOrderItems()
+	if err != nil {
+		return err
 	}
-	if f, err := strconv.ParseFloat(s, 64); err == nil {
-		return f
+	stmt.OrderBy = items
+	orderBy := make(model.OrderBy, 0, len(items))
+	for _, item := range items {
+		meta := newExprMeta(item.Expr, item.Expr.String(), "")
+		if item.Desc {
+			meta.Sort = model.DESC
+		}
+		orderBy = append(orderBy, meta)
 	}
-	// 处理引号包裹的字符串
-	if strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") {
-		return strings.Trim(s, "'")
+	stmt.Context.OrderBy = orderBy
+	return nil
+}
+
+func (p *Parser) parseLimit(stmt *SelectStatement) error {
+	p.next() // 跳过LIMIT
+	limit, err := strconv.Atoi(p.tok.Value)
+	if p.tok.Type != TokenNumber || err != nil || limit <= 0 {
+		return fmt.Errorf("LIMIT expects a positive integer but got %s at position %d", describe(p.tok), p.tok.Pos)
 	}
-	return s
+	stmt.Limit = limit
+	p.next()
+	return nil
 }
 
-func (p *Parser) parseFrom(stmt *SelectStatement) error {
-	p.lexer.skipWhitespace()
-	tok := p.lexer.NextToken()
-	if tok.Type != TokenIdent {
-		return errors.New("expected source identifier after FROM")
+// parseOrderItems 解析以逗号分隔的排序项，每项可以跟 ASC 或 DESC
+func (p *Parser) parseOrderItems() ([]expr.OrderItem, error) {
+	var items []expr.OrderItem
+	for {
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := expr.OrderItem{Expr: node}
+		if p.tok.Type == TokenIdent {
+			switch strings.ToUpper(p.tok.Value) {
+			case "DESC":
+				item.Desc = true
+				p.next()
+			case "ASC":
+				p.next()
+			}
+		}
+		items = append(items, item)
+		if p.tok.Type != TokenComma {
+			return items, nil
+		}
+		p.next()
+	}
+}
+
+func (p *Parser) parseWith(stmt *SelectStatement) error {
+	return p.parseWithOptions(func(key, value string) error {
+		return applyWithOption(stmt, key, value)
+	})
+}
+
+// parseWithOptions 解析 WITH (key=value, ...) 子句，参数名转换为大写后交给 apply 处理
+func (p *Parser) parseWithOptions(apply func(key, value string) error) error {
+	p.next() // 跳过WITH
+	if err := p.expect(TokenLParen, "("); err != nil {
+		return err
+	}
+	for p.tok.Type != TokenRParen {
+		if !isName(p.tok) {
+			return p.unexpected()
+		}
+		key := strings.ToUpper(p.tok.Value)
+		p.next()
+		if err := p.expect(TokenEQ, "="); err != nil {
+			return err
+		}
+		switch p.tok.Type {
+		case TokenString, TokenNumber, TokenIdent, TokenTRUE, TokenFALSE:
+		default:
+			return p.unexpected()
+		}
+		value := p.tok.Value
+		p.next()
+		if err := apply(key, value); err != nil {
+			return err
+		}
+		if p.tok.Type != TokenComma {
+			break
+		}
+		p.next()
+	}
+	return p.expect(TokenRParen, ")")
+}
+
+// applyWithOption 设置 WITH 子句中的参数
+func applyWithOption(stmt *SelectStatement, key, value string) error {
+	switch key {
+	case "TIMESTAMP":
+		stmt.Window.TsProp = value
+	case "TIMEUNIT":
+		timeUnit := time.Minute
+		switch value {
+		case "dd":
+			timeUnit = 24 * time.Hour
+		case "hh":
+			timeUnit = time.Hour
+		case "mi":
+			timeUnit = time.Minute
+		case "ss":
+			timeUnit = time.Second
+		case "ms":
+			timeUnit = time.Millisecond
+		default:
+
+		}
+		stmt.Window.TimeUnit = timeUnit
+	case "EVENTTIME":
+		eventTime, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid EVENTTIME value %s: %w", value, err)
+		}
+		stmt.Window.EventTime = eventTime
+	case "MAXOUTOFORDERNESS":
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid MAXOUTOFORDERNESS duration %s: %w", value, err)
+		}
+		stmt.Window.MaxOutOfOrderness = dur
+	case "ALLOWED_LATENESS":
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid ALLOWED_LATENESS duration %s: %w", value, err)
+		}
+		stmt.Window.AllowedLateness = dur
+	default:
+		return fmt.Errorf("unknown WITH option %s", key)
 	}
-	stmt.Source = tok.Value
 	return nil
 }
 
-func (p *Parser) parseGroupBy(stmt *SelectStatement) error {
-	tok := p.lexer.lookupIdent(p.lexer.readPreviousIdentifier())
-	if tok.Type == TokenTumbling || tok.Type == TokenSliding || tok.Type == TokenCounting || tok.Type == TokenSession {
-		p.parseWindowFunction(stmt, tok.Value)
+// parseExpr 解析表达式，运算符优先级从低到高依次为：
+// OR、AND、NOT、比较运算（含 IN、IS NULL）、加减、乘除取模、一元负号
+func (p *Parser) parseExpr() (expr.Expr, error) {
+	return p.parseOr()
+}
+
+func (p *Parser) parseOr() (expr.Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
 	}
-	if tok.Type == TokenGROUP {
-		p.lexer.NextToken() // 跳过BY
+	for p.tok.Type == TokenOR {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.BinaryExpr{Op: expr.OpOr, Left: left, Right: right}
 	}
+	return left, nil
+}
 
-	for {
-		p.lexer.skipWhitespace()
-		tok := p.lexer.NextToken()
-		if tok.Type == TokenWITH || tok.Type == TokenOrder || tok.Type == TokenEOF {
+func (p *Parser) parseAnd() (expr.Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.Type == TokenAND {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.BinaryExpr{Op: expr.OpAnd, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseNot() (expr.Expr, error) {
+	if p.tok.Type == TokenNOT {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.UnaryExpr{Op: expr.OpNot, X: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisonOps = map[TokenType]expr.Operator{
+	TokenEQ: expr.OpEq,
+	TokenNE: expr.OpNe,
+	TokenLT: expr.OpLt,
+	TokenLE: expr.OpLe,
+	TokenGT: expr.OpGt,
+	TokenGE: expr.OpGe,
+}
+
+func (p *Parser) parseComparison() (expr.Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if op, ok := comparisonOps[p.tok.Type]; ok {
+		p.next()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.BinaryExpr{Op: op, Left: left, Right: right}, nil
+	}
+	switch p.tok.Type {
+	case TokenIN:
+		p.next()
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.InExpr{X: left, List: list}, nil
+	case TokenNOT:
+		p.next()
+		if err := p.expect(TokenIN, "IN"); err != nil {
+			return nil, err
+		}
+		list, err := p.parseList()
+		if err != nil {
+			return nil, err
+		}
+		return &expr.InExpr{X: left, List: list, Not: true}, nil
+	case TokenIS:
+		p.next()
+		not := false
+		if p.tok.Type == TokenNOT {
+			not = true
+			p.next()
+		}
+		if err := p.expect(TokenNULL, "NULL"); err != nil {
+			return nil, err
+		}
+		return &expr.IsNullExpr{X: left, Not: not}, nil
+	}
+	return left, nil
+}
+
+// parseList 解析括号包裹、逗号分隔的表达式列表
+func (p *Parser) parseList() ([]expr.Expr, error) {
+	if err := p.expect(TokenLParen, "("); err != nil {
+		return nil, err
+	}
+	var list []expr.Expr
+	for p.tok.Type != TokenRParen {
+		item, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+		if p.tok.Type != TokenComma {
 			break
 		}
-		if tok.Type == TokenComma {
-			continue
+		p.next()
+	}
+	if err := p.expect(TokenRParen, ")"); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (p *Parser) parseAdditive() (expr.Expr, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.Type == TokenPlus || p.tok.Type == TokenMinus {
+		op := expr.OpAdd
+		if p.tok.Type == TokenMinus {
+			op = expr.OpSub
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &expr.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) parseMultiplicative() (expr.Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op expr.Operator
+		switch p.tok.Type {
+		case TokenAsterisk:
+			op = expr.OpMul
+		case TokenSlash:
+			op = expr.OpDiv
+		case TokenPercent:
+			op = expr.OpMod
+		default:
+			return left, nil
 		}
-		if tok.Type == TokenTumbling || tok.Type == TokenSliding || tok.Type == TokenCounting || tok.Type == TokenSession {
-			p.parseWindowFunction(stmt, tok.Value)
-			continue
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
 		}
+		left = &expr.BinaryExpr{Op: op, Left: left, Right: right}
+	}
+}
 
-		stmt.GroupBy = append(stmt.GroupBy, tok.Value)
+func (p *Parser) parseUnary() (expr.Expr, error) {
+	switch p.tok.Type {
+	case TokenMinus:
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		// 负数常量直接折叠为字面量
+		if lit, ok := x.(*expr.Literal); ok {
+			switch v := lit.Value.(type) {
+			case int64:
+				return &expr.Literal{Value: -v}, nil
+			case float64:
+				return &expr.Literal{Value: -v}, nil
+			}
+		}
+		return &expr.UnaryExpr{Op: expr.OpSub, X: x}, nil
+	case TokenPlus:
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePrimary()
+}
 
-		//if p.lexer.NextToken().Type != TokenComma {
-		//	break
-		//}
+func (p *Parser) parsePrimary() (expr.Expr, error) {
+	tok := p.tok
+	switch tok.Type {
+	case TokenNumber:
+		p.next()
+		if i, err := strconv.ParseInt(tok.Value, 10, 64); err == nil {
+			return &expr.Literal{Value: i}, nil
+		}
+		f, err := strconv.ParseFloat(tok.Value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s at position %d", tok.Value, tok.Pos)
+		}
+		return &expr.Literal{Value: f}, nil
+	case TokenString:
+		p.next()
+		return &expr.Literal{Value: tok.Value}, nil
+	case TokenTRUE, TokenFALSE:
+		p.next()
+		return &expr.Literal{Value: tok.Type == TokenTRUE}, nil
+	case TokenNULL:
+		p.next()
+		return &expr.Literal{Value: nil}, nil
+	case TokenLParen:
+		p.next()
+		node, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(TokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case TokenCASE:
+		return p.parseCase()
+	case TokenIdent:
+		p.next()
+		if p.tok.Type == TokenLParen {
+			return p.parseCall(tok.Value)
+		}
+		return &expr.Ident{Name: tok.Value}, nil
 	}
-	return nil
+	return nil, p.unexpected()
 }
 
-func (p *Parser) parseWith(stmt *SelectStatement) error {
-	p.lexer.NextToken() // 跳过(
-	for p.lexer.peekChar() != ')' {
-		valTok := p.lexer.NextToken()
-		if valTok.Type == TokenRParen || valTok.Type == TokenEOF {
+// parseCall 解析函数调用的参数列表和 OVER 子句，当前标记为左括号。
+// 参数可以是 *，如 count(*)，参数列表前可以有 DISTINCT，如 count(DISTINCT deviceId)
+func (p *Parser) parseCall(name string) (expr.Expr, error) {
+	p.next() // 跳过(
+	call := &expr.CallExpr{Name: name}
+	if p.tok.Type == TokenAsterisk {
+		call.Star = true
+		p.next()
+	} else if p.tok.Type == TokenDISTINCT {
+		call.Distinct = true
+		p.next()
+	}
+	for !call.Star && p.tok.Type != TokenRParen {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		if p.tok.Type != TokenComma {
 			break
 		}
-		if valTok.Type == TokenComma {
-			continue
-		}
-
-		if valTok.Type == TokenTimestamp {
-			next := p.lexer.NextToken()
-			if next.Type == TokenEQ {
-				next = p.lexer.NextToken()
-				if strings.HasPrefix(next.Value, "'") && strings.HasSuffix(next.Value, "'") {
-					next.Value = strings.Trim(next.Value, "'")
-				}
-				// 检查Window是否已初始化，如果未初始化则创建新的WindowDefinition
-				if stmt.Window.Type == "" {
-					stmt.Window = WindowDefinition{
-						TsProp: next.Value,
-					}
-				} else {
-					stmt.Window.TsProp = next.Value
-				}
-			}
+		p.next()
+	}
+	if err := p.expect(TokenRParen, ")"); err != nil {
+		return nil, err
+	}
+	if p.tok.Type != TokenOVER {
+		return call, nil
+	}
+	p.next()
+	if err := p.expect(TokenLParen, "("); err != nil {
+		return nil, err
+	}
+	call.Over = &expr.OverClause{}
+	if p.tok.Type == TokenPARTITION {
+		p.next()
+		if err := p.expect(TokenBY, "BY"); err != nil {
+			return nil, err
 		}
-		if valTok.Type == TokenTimeUnit {
-			timeUnit := time.Minute
-			next := p.lexer.NextToken()
-			if next.Type == TokenEQ {
-				next = p.lexer.NextToken()
-				if strings.HasPrefix(next.Value, "'") && strings.HasSuffix(next.Value, "'") {
-					next.Value = strings.Trim(next.Value, "'")
-				}
-				switch next.Value {
-				case "dd":
-					timeUnit = 24 * time.Hour
-				case "hh":
-					timeUnit = time.Hour
-				case "mi":
-					timeUnit = time.Minute
-				case "ss":
-					timeUnit = time.Second
-				case "ms":
-					timeUnit = time.Millisecond
-				default:
-
-				}
-				// 检查Window是否已初始化，如果未初始化则创建新的WindowDefinition
-				if stmt.Window.Type == "" {
-					stmt.Window = WindowDefinition{
-						TimeUnit: timeUnit,
-					}
-				} else {
-					stmt.Window.TimeUnit = timeUnit
-				}
+		for {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
 			}
+			call.Over.PartitionBy = append(call.Over.PartitionBy, e)
+			if p.tok.Type != TokenComma {
+				break
+			}
+			p.next()
 		}
 	}
-
-	return nil
+	if p.tok.Type == TokenOrder {
+		p.next()
+		if err := p.expect(TokenBY, "BY"); err != nil {
+			return nil, err
+		}
+		items, err := p.parseOrderItems()
+		if err != nil {
+			return nil, err
+		}
+		call.Over.OrderBy = items
+	}
+	if err := p.expect(TokenRParen, ")"); err != nil {
+		return nil, err
+	}
+	return call, nil
 }
 
-func determineExprType(exprStr string) model.ExprType {
-	// 如果包含 OVER 子句，先提取函数部分
-	if strings.Contains(strings.ToUpper(exprStr), "OVER") {
-		parts := strings.Split(exprStr, "OVER")
-		exprStr = strings.TrimSpace(parts[0]) // 只取 OVER 前面的部分进行判断
+// parseCase 解析 CASE [operand] WHEN ... THEN ... [ELSE ...] END
+func (p *Parser) parseCase() (expr.Expr, error) {
+	p.next() // 跳过CASE
+	c := &expr.CaseExpr{}
+	if p.tok.Type != TokenWHEN {
+		operand, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.Operand = operand
 	}
-	program, err := expr.Compile(exprStr)
-	if err != nil {
-		return model.Field // 默认作为字段处理
+	for p.tok.Type == TokenWHEN {
+		p.next()
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(TokenTHEN, "THEN"); err != nil {
+			return nil, err
+		}
+		result, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.Whens = append(c.Whens, expr.When{Cond: cond, Result: result})
+	}
+	if len(c.Whens) == 0 {
+		return nil, fmt.Errorf("expected WHEN but got %s at position %d", describe(p.tok), p.tok.Pos)
+	}
+	if p.tok.Type == TokenELSE {
+		p.next()
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		c.Else = e
+	}
+	if err := p.expect(TokenEND, "END"); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
 
-	switch node := program.Node().(type) {
-	case *ast.IdentifierNode:
+// exprType 根据语法树判断表达式的类型
+func exprType(node expr.Expr) model.ExprType {
+	switch n := node.(type) {
+	case *expr.Ident:
 		// 处理简单字段，如 deviceId
 		return model.Field
-
-	case *ast.CallNode:
+	case *expr.CallExpr:
 		// 检查是否是窗口函数
-		windowTypes := []string{"TumblingWindow", "SlidingWindow", "SessionWindow"}
-		for _, wt := range windowTypes {
-			if node.Callee.(*ast.IdentifierNode).Value == wt {
-				return model.Win
-			}
+		if windowFunctions[strings.ToUpper(n.Name)] {
+			return model.Win
 		}
 		// 其他函数调用，如 format_time(), avg() 等
 		return model.Func
-
-	case *ast.BinaryNode:
+	default:
 		// 处理表达式，如 temperature/10, a+b+c/d
 		return model.Expr
-
-	default:
-		return model.Field
 	}
 }
@@ -5,9 +5,12 @@ import (
 	"time"
 
 	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/functions"
 	"github.com/rulego/streamsql/model"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseSQL(t *testing.T) {
@@ -26,11 +29,10 @@ func TestParseSQL(t *testing.T) {
 					},
 				},
 				GroupFields: []string{"deviceId"},
-				SelectFields: map[string]aggregator.AggregateType{
-					"temperature": "avg",
-				},
-				FieldAlias: map[string]string{
-					"temperature": "aa",
+				Aggregations: []aggregator.AggregationField{
+					{AggregateType: aggregator.Avg, OutputAlias: "avg(temperature / 10)", Expr: &expr.BinaryExpr{
+						Op: expr.OpDiv, Left: &expr.Ident{Name: "temperature"}, Right: &expr.Literal{Value: int64(10)},
+					}},
 				},
 			},
 			condition: "deviceId == 'aa'",
@@ -46,9 +48,9 @@ func TestParseSQL(t *testing.T) {
 					},
 				},
 				GroupFields: []string{"type"},
-				SelectFields: map[string]aggregator.AggregateType{
-					"humidity":    "max",
-					"temperature": "min",
+				Aggregations: []aggregator.AggregationField{
+					{InputField: "humidity", AggregateType: aggregator.Max, OutputAlias: "max(humidity)"},
+					{InputField: "temperature", AggregateType: aggregator.Min, OutputAlias: "min(temperature)"},
 				},
 			},
 			condition: "",
@@ -64,11 +66,10 @@ func TestParseSQL(t *testing.T) {
 					TsProp: "ts",
 				},
 				GroupFields: []string{"deviceId"},
-				SelectFields: map[string]aggregator.AggregateType{
-					"temperature": "avg",
-				},
-				FieldAlias: map[string]string{
-					"temperature": "aa",
+				Aggregations: []aggregator.AggregationField{
+					{AggregateType: aggregator.Avg, OutputAlias: "avg(temperature / 10)", Expr: &expr.BinaryExpr{
+						Op: expr.OpDiv, Left: &expr.Ident{Name: "temperature"}, Right: &expr.Literal{Value: int64(10)},
+					}},
 				},
 			},
 			condition: "deviceId == 'aa'",
@@ -83,11 +84,10 @@ func TestParseSQL(t *testing.T) {
 					},
 					TsProp: "ts",
 				},
-				SelectFields: map[string]aggregator.AggregateType{
-					"temperature": "avg",
-				},
-				FieldAlias: map[string]string{
-					"temperature": "aa",
+				Aggregations: []aggregator.AggregationField{
+					{AggregateType: aggregator.Avg, OutputAlias: "avg(temperature / 10)", Expr: &expr.BinaryExpr{
+						Op: expr.OpDiv, Left: &expr.Ident{Name: "temperature"}, Right: &expr.Literal{Value: int64(10)},
+					}},
 				},
 			},
 			condition: "deviceId == 'aa' && temperature > 0",
@@ -105,7 +105,7 @@ func TestParseSQL(t *testing.T) {
 		assert.Equal(t, tt.expected.WindowConfig.Type, config.WindowConfig.Type)
 		assert.Equal(t, tt.expected.WindowConfig.Params["size"], config.WindowConfig.Params["size"])
 		assert.Equal(t, tt.expected.GroupFields, config.GroupFields)
-		assert.Equal(t, tt.expected.SelectFields, config.SelectFields)
+		assert.Equal(t, tt.expected.Aggregations, config.Aggregations)
 		assert.Equal(t, tt.condition, cond)
 		if tt.expected.WindowConfig.TsProp != "" {
 			assert.Equal(t, tt.expected.WindowConfig.TsProp, config.WindowConfig.TsProp)
@@ -122,7 +122,7 @@ func TestWindowParamParsing(t *testing.T) {
 
 func TestConditionParsing(t *testing.T) {
 	sql := "select cpu,mem from metrics where cpu > 80 or (mem < 20 and disk == '/dev/sda')"
-	expected := "cpu > 80 || ( mem < 20 && disk == '/dev/sda' )"
+	expected := "cpu > 80 || (mem < 20 && disk == '/dev/sda')"
 
 	parser := NewParser(sql)
 	stmt, err := parser.Parse()
@@ -130,7 +130,7 @@ func TestConditionParsing(t *testing.T) {
 	assert.Equal(t, expected, stmt.Condition)
 }
 
-func TestDetermineExprType(t *testing.T) {
+func TestExprType(t *testing.T) {
 	tests := []struct {
 		name     string
 		exprStr  string
@@ -190,7 +190,9 @@ func TestDetermineExprType(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := determineExprType(tt.exprStr)
+			node, err := ParseExpression(tt.exprStr)
+			require.NoError(t, err)
+			result := exprType(node)
 			assert.Equal(t, tt.expected, result, "表达式 '%s' 的类型判断错误", tt.exprStr)
 		})
 	}
@@ -270,3 +272,470 @@ func TestParseProjection(t *testing.T) {
 
 	}
 }
+
+func TestParseSessionWindow(t *testing.T) {
+	sql := "select deviceId, avg(temperature) as avg_temp from Input group by deviceId, SessionWindow('30s')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "session", config.WindowConfig.Type)
+	assert.Equal(t, 30*time.Second, config.WindowConfig.Params["timeout"])
+	assert.Equal(t, []string{"deviceId"}, config.GroupFields)
+}
+
+func TestParseGroupByExpression(t *testing.T) {
+	sql := "select region, payload.meta.site, count(deviceId) as cnt from Input group by region, floor(temperature/10), payload.meta.site, TumblingWindow('1m')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"region", "floor(temperature / 10)", "payload.meta.site"}, config.GroupFields)
+	require.Len(t, config.GroupBy, 3)
+	assert.Nil(t, config.GroupBy[0].Expr)
+	assert.Equal(t, "floor(temperature / 10)", config.GroupBy[1].Expr.String())
+	// 嵌套字段的路径作为一个字段读取
+	assert.Equal(t, "payload.meta.site", config.GroupBy[2].Name)
+	assert.Nil(t, config.GroupBy[2].Expr)
+}
+
+func TestParseGroupResultBinding(t *testing.T) {
+	sql := "select deviceId, avg(temperature)*1.8+32 as f, deviceId + '-x' as k, 'x' as lit, 2*(temperature/10), status from Input " +
+		"group by deviceId, temperature/10, TumblingWindow('1m') having f > 0 and humidity > 1 order by temperature/10, status"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	require.Len(t, config.Projection, 6)
+	// 与分组表达式相同的子表达式从分组结果中取值，输出名称保持不变
+	bucket := config.Projection[4]
+	assert.Equal(t, "2 * (temperature / 10)", bucket.Alias)
+	assert.Equal(t, &expr.BinaryExpr{Op: expr.OpMul, Left: &expr.Literal{Value: int64(2)}, Right: &expr.Ident{Name: "temperature / 10"}}, bucket.Node)
+	assert.Equal(t, &expr.Ident{Name: "temperature / 10"}, config.OrderBy[0].Expr)
+	// 语句本身的语法树不被修改
+	assert.Equal(t, "2 * (temperature / 10)", stmt.Fields[4].Expr.String())
+	// 既不分组也不聚合的字段取分组内最后一条数据的值，HAVING 中的别名不是非聚合字段
+	assert.Equal(t, []string{"status", "humidity"}, config.RowFields)
+
+	// GROUP BY payload 时其中的嵌套字段直接从分组值中取得
+	stmt, err = NewParser("select payload.meta.site, count(temperature) as cnt from Input group by payload, TumblingWindow('1m')").Parse()
+	require.NoError(t, err)
+	config, _, err = stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Nil(t, config.RowFields)
+}
+
+func TestParseKeywordFields(t *testing.T) {
+	// 与关键字同名的字段需要用反引号包裹
+	sql := "select `end`, max(`in`) as m from Input where `is` is not null group by `end`, TumblingWindow('1m')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"end"}, config.GroupFields)
+	assert.Equal(t, &expr.Ident{Name: "end"}, config.Projection[0].Node)
+	assert.Equal(t, &expr.IsNullExpr{X: &expr.Ident{Name: "is"}, Not: true}, config.Where)
+	require.Len(t, config.Aggregations, 1)
+	assert.Equal(t, "in", config.Aggregations[0].InputField)
+
+	for _, sql := range []string{
+		"select end from Input",
+		"select deviceId from Input where in > 1",
+		"select case from Input",
+	} {
+		_, err := NewParser(sql).Parse()
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestParseFunctions(t *testing.T) {
+	// 标量函数可以用于查询字段、WHERE、GROUP BY、HAVING 和 ORDER BY
+	sql := "select floor(temperature/10) as bucket, upper(concat(deviceId, '-', site)) as name, avg(round(temperature, 1)) as avg_temp " +
+		"from Input where regexp_match(deviceId, '^dev') and coalesce(status, 'ok') = 'ok' " +
+		"group by floor(temperature/10), upper(concat(deviceId, '-', site)), TumblingWindow('1m') " +
+		"having if(avg_temp > 10, true, false) order by lower(name)"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	_, _, err = stmt.ToStreamConfig()
+	require.NoError(t, err)
+
+	for sql, msg := range map[string]string{
+		"select unknown_fn(a) from Input":                      "unknown function unknown_fn",
+		"select pow(a) from Input":                             "pow expects 2 arguments but got 1",
+		"select a from Input where upper(1) = 'A'":             "upper argument 1 expects STRING but got NUMBER",
+		"select substring(lower(a), 'x') from Input":           "substring argument 2 expects NUMBER but got STRING",
+		"select abs(upper(a)) from Input":                      "abs argument 1 expects NUMBER but got STRING",
+		"select avg(abs('x')) from Input TumblingWindow('1m')": "abs argument 1 expects NUMBER but got STRING",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.EqualError(t, err, msg, sql)
+	}
+}
+
+func TestParseCustomAggregates(t *testing.T) {
+	number := functions.TypeNumber
+	create := func([]interface{}) (aggregator.AggregatorFunction, error) {
+		return aggregator.CreateBuiltinAggregator(aggregator.Avg)
+	}
+	aggregator.RegisterWithSignature("weighted_avg", aggregator.Signature{Args: []functions.ArgType{number, number}, Result: number}, create)
+	aggregator.RegisterWithSignature("quantile", aggregator.Signature{Args: []functions.ArgType{number}, Params: []functions.ArgType{number}, Result: number}, create)
+
+	sql := "select deviceId, weighted_avg(temperature, humidity/100) as w, quantile(temperature, 0.99) as q from Input " +
+		"group by deviceId, TumblingWindow('1m') having weighted_avg(temperature, humidity/100) > 10"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	// 同一个聚合调用只计算一次，多参数聚合的参数写入 Args，常量参数写入 Params
+	assert.Equal(t, []aggregator.AggregationField{
+		{
+			AggregateType: "weighted_avg",
+			OutputAlias:   "weighted_avg(temperature, humidity / 100)",
+			Args: []expr.Expr{&expr.Ident{Name: "temperature"}, &expr.BinaryExpr{
+				Op: expr.OpDiv, Left: &expr.Ident{Name: "humidity"}, Right: &expr.Literal{Value: int64(100)},
+			}},
+		},
+		{InputField: "temperature", AggregateType: "quantile", OutputAlias: "quantile(temperature, 0.99)", Params: []interface{}{0.99}},
+	}, config.Aggregations)
+
+	for sql, msg := range map[string]string{
+		"select weighted_avg(a) from Input TumblingWindow('1m')":         "weighted_avg expects 2 arguments but got 1",
+		"select weighted_avg(a, 'x') from Input TumblingWindow('1m')":    "weighted_avg argument 2 expects NUMBER but got STRING",
+		"select quantile(a, b) from Input TumblingWindow('1m')":          "quantile argument 2 must be a constant",
+		"select quantile(a, '0.9') from Input TumblingWindow('1m')":      "quantile argument 2 expects NUMBER but got STRING",
+		"select quantile(upper(a), 0.9) from Input TumblingWindow('1m')": "quantile argument 1 expects NUMBER but got STRING",
+		"select sum(a, b) from Input TumblingWindow('1m')":               "sum expects 1 arguments but got 2",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.EqualError(t, err, msg, sql)
+	}
+}
+
+func TestParsePercentile(t *testing.T) {
+	sql := "select percentile(latency) as p95, percentile(latency, 0.99) as p99, percentile_cont(latency, 0.5) as p50, " +
+		"percentile_disc(latency, 0.5) as d50 from Input TumblingWindow('1m')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []aggregator.AggregationField{
+		{InputField: "latency", AggregateType: aggregator.Percentile, OutputAlias: "percentile(latency)"},
+		{InputField: "latency", AggregateType: aggregator.Percentile, OutputAlias: "percentile(latency, 0.99)", Params: []interface{}{0.99}},
+		{InputField: "latency", AggregateType: aggregator.PercentileCont, OutputAlias: "percentile_cont(latency, 0.5)", Params: []interface{}{0.5}},
+		{InputField: "latency", AggregateType: aggregator.PercentileDisc, OutputAlias: "percentile_disc(latency, 0.5)", Params: []interface{}{0.5}},
+	}, config.Aggregations)
+
+	for sql, msg := range map[string]string{
+		"select percentile_cont(latency) from Input TumblingWindow('1m')":       "percentile_cont expects 2 arguments but got 1",
+		"select percentile(latency, 99) from Input TumblingWindow('1m')":        "percentile: percentile must be between 0 and 1 but got 99",
+		"select percentile_disc(latency, -0.1) from Input TumblingWindow('1m')": "percentile_disc: percentile must be between 0 and 1 but got -0.1",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.EqualError(t, err, msg, sql)
+	}
+}
+
+func TestParseCount(t *testing.T) {
+	sql := "select site, count(*) as total, count(deviceId) as reports, count(DISTINCT deviceId) as devices, " +
+		"approx_count_distinct(deviceId, 12) as approx from Input group by site, TumblingWindow('1m') having count(*) > 1"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []aggregator.AggregationField{
+		{AggregateType: aggregator.Count, OutputAlias: "count(*)"},
+		{InputField: "deviceId", AggregateType: aggregator.Count, OutputAlias: "count(deviceId)"},
+		{InputField: "deviceId", AggregateType: aggregator.CountDistinct, OutputAlias: "count(DISTINCT deviceId)"},
+		{InputField: "deviceId", AggregateType: aggregator.ApproxCountDistinct, OutputAlias: "approx_count_distinct(deviceId, 12)", Params: []interface{}{int64(12)}},
+	}, config.Aggregations)
+
+	for sql, msg := range map[string]string{
+		"select sum(*) from Input TumblingWindow('1m')":                           "sum does not accept *",
+		"select avg(DISTINCT a) from Input TumblingWindow('1m')":                  "avg does not accept DISTINCT",
+		"select upper(DISTINCT a) from Input":                                     "upper does not accept DISTINCT",
+		"select count(DISTINCT a, b) from Input TumblingWindow('1m')":             "count expects 1 arguments but got 2",
+		"select approx_count_distinct(a, 30) from Input TumblingWindow('1m')":     "approx_count_distinct: precision must be between 4 and 18 but got 30",
+		"select approx_count_distinct(a, 'high') from Input TumblingWindow('1m')": "approx_count_distinct argument 2 expects NUMBER but got STRING",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.EqualError(t, err, msg, sql)
+	}
+}
+
+func TestParseTopK(t *testing.T) {
+	sql := "select gateway, topk(deviceId, 3) as noisy, topk_by(deviceId, bytes / 1024, 3) as heavy from Input group by gateway, TumblingWindow('5m')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []aggregator.AggregationField{
+		{InputField: "deviceId", AggregateType: aggregator.TopK, OutputAlias: "topk(deviceId, 3)", Params: []interface{}{int64(3)}},
+		{
+			AggregateType: aggregator.TopKBy,
+			OutputAlias:   "topk_by(deviceId, bytes / 1024, 3)",
+			Args: []expr.Expr{&expr.Ident{Name: "deviceId"}, &expr.BinaryExpr{
+				Op: expr.OpDiv, Left: &expr.Ident{Name: "bytes"}, Right: &expr.Literal{Value: int64(1024)},
+			}},
+			Params: []interface{}{int64(3)},
+		},
+	}, config.Aggregations)
+
+	for sql, msg := range map[string]string{
+		"select topk(deviceId) from Input TumblingWindow('1m')":              "topk expects 2 arguments but got 1",
+		"select topk(deviceId, 0) from Input TumblingWindow('1m')":           "topk: k must be greater than 0 but got 0",
+		"select topk(deviceId, 2.5) from Input TumblingWindow('1m')":         "topk: k must be an integer but got 2.5",
+		"select topk_by(deviceId, 'x', 3) from Input TumblingWindow('1m')":   "topk_by argument 2 expects NUMBER but got STRING",
+		"select topk_by(deviceId, bytes, n) from Input TumblingWindow('1m')": "topk_by argument 3 must be a constant",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.EqualError(t, err, msg, sql)
+	}
+}
+
+func TestParseEventTime(t *testing.T) {
+	sql := "select deviceId, avg(temperature) as avg_temp from Input group by deviceId, TumblingWindow('10s') with (TIMESTAMP='ts', EVENTTIME=true, MAXOUTOFORDERNESS='5s')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "ts", config.WindowConfig.TsProp)
+	assert.Equal(t, model.EventTime, config.WindowConfig.TimeCharacteristic)
+	assert.Equal(t, 5*time.Second, config.WindowConfig.MaxOutOfOrderness)
+
+	// 事件时间语义必须指定时间戳字段
+	stmt, err = NewParser("select avg(temperature) as avg_temp from Input TumblingWindow('10s') with (EVENTTIME=true)").Parse()
+	require.NoError(t, err)
+	_, _, err = stmt.ToStreamConfig()
+	assert.Error(t, err)
+}
+
+func TestParseAllowedLateness(t *testing.T) {
+	sql := "select avg(temperature) as avg_temp from Input TumblingWindow('10s') with (TIMESTAMP='ts', EVENTTIME=true, ALLOWED_LATENESS='1m')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, config.WindowConfig.AllowedLateness)
+}
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a + b * c", "a + b * c"},
+		{"(a + b) * c", "(a + b) * c"},
+		{"deviceId = 'aa' AND temperature >= -5", "deviceId == 'aa' && temperature >= -5"},
+		{"device == 'aa' && temperature > 10", "device == 'aa' && temperature > 10"},
+		{"a <> 1 or not b", "a != 1 || !b"},
+		{"avg(a) + max(b)", "avg(a) + max(b)"},
+		{"round(avg(temperature / 10), 2)", "round(avg(temperature / 10), 2)"},
+		{"status IN ('on', 'idle') and code NOT IN (1, 2)", "status IN ('on', 'idle') && code NOT IN (1, 2)"},
+		{"case when t > 30 then 'hot' when t > 20 then 'warm' else 'cold' end", "CASE WHEN t > 30 THEN 'hot' WHEN t > 20 THEN 'warm' ELSE 'cold' END"},
+		{"case level when 1 then 'low' end", "CASE level WHEN 1 THEN 'low' END"},
+		{"x is not null", "x IS NOT NULL"},
+		{"`sum(total)` > 1", "sum(total) > 1"},
+		{"count(*) + count( distinct deviceId )", "count(*) + count(DISTINCT deviceId)"},
+	}
+	for _, tt := range tests {
+		node, err := ParseExpression(tt.input)
+		require.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, node.String(), tt.input)
+	}
+
+	for _, input := range []string{"a +", "(a", "a b", "case end", "'abc", "a & b", "count(*, a)"} {
+		_, err := ParseExpression(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestParseExpressionTree(t *testing.T) {
+	node, err := ParseExpression("avg(a) + max(b) * 2")
+	require.NoError(t, err)
+	add, ok := node.(*expr.BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, expr.OpAdd, add.Op)
+	assert.Equal(t, &expr.CallExpr{Name: "avg", Args: []expr.Expr{&expr.Ident{Name: "a"}}}, add.Left)
+	mul, ok := add.Right.(*expr.BinaryExpr)
+	require.True(t, ok)
+	assert.Equal(t, expr.OpMul, mul.Op)
+	assert.Equal(t, &expr.Literal{Value: int64(2)}, mul.Right)
+}
+
+func TestParseAggregateExpressions(t *testing.T) {
+	sql := "select deviceId, avg(temperature) + max(humidity) as score, max(temperature) - min(temperature) as spread, " +
+		"sum_total from Input group by deviceId, TumblingWindow('10s')"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []aggregator.AggregationField{
+		{InputField: "temperature", AggregateType: aggregator.Avg, OutputAlias: "avg(temperature)"},
+		{InputField: "humidity", AggregateType: aggregator.Max, OutputAlias: "max(humidity)"},
+		{InputField: "temperature", AggregateType: aggregator.Max, OutputAlias: "max(temperature)"},
+		{InputField: "temperature", AggregateType: aggregator.Min, OutputAlias: "min(temperature)"},
+	}, config.Aggregations)
+	require.Len(t, config.Projection, 4)
+	assert.Equal(t, model.Expr, config.Projection[1].Type)
+	assert.Equal(t, "sum_total", config.Projection[3].Name)
+
+	// 聚合函数不能嵌套
+	stmt, err = NewParser("select max(avg(temperature)) from Input TumblingWindow('10s')").Parse()
+	require.NoError(t, err)
+	_, _, err = stmt.ToStreamConfig()
+	assert.Error(t, err)
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	for _, sql := range []string{
+		"select from Input",
+		"select a Input",
+		"select a from Input where",
+		"select a from Input group deviceId",
+		"select a from Input TumblingWindow(size)",
+		"select a from Input with (UNKNOWN='x')",
+	} {
+		_, err := NewParser(sql).Parse()
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestParseHaving(t *testing.T) {
+	sql := "select deviceId, avg(temperature) as avg_temp from Input group by deviceId, TumblingWindow('1m') " +
+		"having avg_temp > 30 and max(humidity) < 80"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	require.Len(t, stmt.Context.Having, 1)
+	assert.Equal(t, "avg_temp > 30 and max(humidity) < 80", stmt.Context.Having[0].Expression)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "avg_temp > 30 && max(humidity) < 80", config.Having.String())
+	// 只在 HAVING 中出现的聚合也需要计算
+	assert.Equal(t, []aggregator.AggregationField{
+		{InputField: "temperature", AggregateType: aggregator.Avg, OutputAlias: "avg(temperature)"},
+		{InputField: "humidity", AggregateType: aggregator.Max, OutputAlias: "max(humidity)"},
+	}, config.Aggregations)
+}
+
+func TestParseOrderByLimit(t *testing.T) {
+	sql := "select deviceId, avg(temperature) as avg_temp from Input group by deviceId, TumblingWindow('1m') " +
+		"order by avg_temp desc, max(humidity), deviceId asc limit 10"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	require.Len(t, stmt.Context.OrderBy, 3)
+	assert.Equal(t, model.DESC, stmt.Context.OrderBy[0].Sort)
+	assert.Equal(t, model.ASC, stmt.Context.OrderBy[1].Sort)
+	assert.Equal(t, model.OrderType(1), model.DESC)
+
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 10, config.Limit)
+	require.Len(t, config.OrderBy, 3)
+	assert.Equal(t, "avg_temp DESC", config.OrderBy[0].String())
+	assert.Equal(t, "max(humidity)", config.OrderBy[1].String())
+	assert.Equal(t, "deviceId", config.OrderBy[2].String())
+	// 只在 ORDER BY 中出现的聚合也需要计算
+	assert.Len(t, config.Aggregations, 2)
+
+	for _, sql := range []string{
+		"select a from Input TumblingWindow('1m') limit 0",
+		"select a from Input TumblingWindow('1m') limit x",
+		"select a from Input TumblingWindow('1m') order a",
+	} {
+		_, err := NewParser(sql).Parse()
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestParseNonWindow(t *testing.T) {
+	sql := "select deviceId, temperature*1.8+32 as f from Input where temperature > 40"
+	stmt, err := NewParser(sql).Parse()
+	require.NoError(t, err)
+	config, condition, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "", config.WindowConfig.Type)
+	assert.Equal(t, "temperature > 40", condition)
+	assert.Empty(t, config.Aggregations)
+	require.Len(t, config.Projection, 2)
+	assert.Equal(t, "f", config.Projection[1].Alias)
+
+	// 聚合、分组、排序等子句需要窗口
+	for _, sql := range []string{
+		"select avg(temperature) from Input",
+		"select deviceId from Input group by deviceId",
+		"select deviceId from Input having deviceId == 'a'",
+		"select deviceId from Input order by deviceId",
+		"select deviceId from Input limit 10",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		_, _, err = stmt.ToStreamConfig()
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestParseCreateStream(t *testing.T) {
+	sql := "CREATE STREAM sensors (deviceId STRING, temperature FLOAT, count BIGINT, ts TIMESTAMP) WITH (TIMESTAMP='ts', FORMAT='json')"
+	parsed, err := NewParser(sql).ParseStatement()
+	require.NoError(t, err)
+	create, ok := parsed.(*CreateStreamStatement)
+	require.True(t, ok)
+	schema, err := create.ToSchema()
+	require.NoError(t, err)
+	assert.Equal(t, &model.Schema{
+		Name: "sensors",
+		Fields: []model.SchemaField{
+			{Name: "deviceId", Type: model.TypeString},
+			{Name: "temperature", Type: model.TypeFloat},
+			{Name: "count", Type: model.TypeInt},
+			{Name: "ts", Type: model.TypeTimestamp},
+		},
+		TsProp: "ts",
+		Format: model.FormatJSON,
+	}, schema)
+
+	// 没有 WITH 子句时默认为 json 格式
+	create, err = NewParser("create stream s (a int)").ParseCreateStream()
+	require.NoError(t, err)
+	assert.Equal(t, model.FormatJSON, create.Format)
+
+	// SELECT 语句仍然按查询解析
+	parsed, err = NewParser("select a from s").ParseStatement()
+	require.NoError(t, err)
+	assert.IsType(t, &SelectStatement{}, parsed)
+
+	for sql, msg := range map[string]string{
+		"CREATE TABLE s (a INT)":                              `expected STREAM but got "TABLE" at position 7`,
+		"CREATE STREAM s (a BLOB)":                            "unknown data type BLOB at position 19",
+		"CREATE STREAM s (a INT, b)":                          `expected data type of field b but got ")" at position 25`,
+		"CREATE STREAM s (a INT) WITH (PARTITIONS=2)":         "unknown WITH option PARTITIONS",
+		"CREATE STREAM s (a INT) extra":                       `unexpected "extra" at position 24`,
+		"CREATE STREAM s (a INT, a STRING)":                   "duplicate field a in stream s",
+		"CREATE STREAM s (a INT) WITH (FORMAT='csv')":         "unsupported FORMAT csv for stream s",
+		"CREATE STREAM s (a INT) WITH (TIMESTAMP='ts')":       "TIMESTAMP field ts is not declared in stream s",
+		"CREATE STREAM s (ts INT) WITH (TIMESTAMP='ts')":      "TIMESTAMP field ts of stream s must be TIMESTAMP but is INT",
+		"CREATE STREAM s (ts STRING) WITH (TIMESTAMP = 'ts')": "TIMESTAMP field ts of stream s must be TIMESTAMP but is STRING",
+	} {
+		create, err := NewParser(sql).ParseCreateStream()
+		if err == nil {
+			_, err = create.ToSchema()
+		}
+		assert.EqualError(t, err, msg, sql)
+	}
+}
@@ -0,0 +1,351 @@
+package rsql
+
+import (
+	"fmt"
+
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
+	"github.com/rulego/streamsql/functions"
+	"github.com/rulego/streamsql/model"
+)
+
+// BindSchema 根据 FROM 子句对应的流结构检查查询：引用的字段必须在流中声明，
+// 运算、比较和聚合的操作数类型必须匹配。查询未指定 TIMESTAMP 时使用流的事件时间字段
+func (s *SelectStatement) BindSchema(schema *model.Schema) error {
+	if s.Window.TsProp == "" {
+		s.Window.TsProp = schema.TsProp
+	} else if f, ok := schema.Field(s.Window.TsProp); !ok {
+		return fmt.Errorf("unknown TIMESTAMP field %s in stream %s", s.Window.TsProp, schema.Name)
+	} else if f.Type != model.TypeTimestamp {
+		return fmt.Errorf("TIMESTAMP field %s must be TIMESTAMP but is %s", f.Name, f.Type)
+	}
+
+	c := &typeChecker{schema: schema}
+	aliases := make(map[string]model.DataType)
+	for _, f := range s.Fields {
+		t, err := c.check(f.Expr)
+		if err != nil {
+			return err
+		}
+		if f.Alias != "" {
+			aliases[f.Alias] = t
+		}
+	}
+	if s.Where != nil {
+		if err := c.checkCondition("WHERE", s.Where); err != nil {
+			return err
+		}
+	}
+	for _, e := range s.GroupBy {
+		if _, err := c.check(e); err != nil {
+			return err
+		}
+	}
+	// HAVING 条件和排序项可以引用输出字段的别名
+	c.aliases = aliases
+	if s.Having != nil {
+		if err := c.checkCondition("HAVING", s.Having); err != nil {
+			return err
+		}
+	}
+	for _, item := range s.OrderBy {
+		if _, err := c.check(item.Expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeChecker 按流结构推导表达式的类型，类型为空表示无法在解析时确定
+type typeChecker struct {
+	schema  *model.Schema
+	aliases map[string]model.DataType
+}
+
+// checkCondition 检查条件表达式，结果必须为布尔值
+func (c *typeChecker) checkCondition(clause string, node expr.Expr) error {
+	t, err := c.check(node)
+	if err != nil {
+		return err
+	}
+	if t != "" && t != model.TypeBool {
+		return fmt.Errorf("%s condition %s must be BOOL but is %s", clause, node, t)
+	}
+	return nil
+}
+
+func (c *typeChecker) check(node expr.Expr) (model.DataType, error) {
+	switch n := node.(type) {
+	case *expr.Literal:
+		return literalType(n.Value), nil
+	case *expr.Ident:
+		if f, ok := c.schema.Field(n.Name); ok {
+			return f.Type, nil
+		}
+		if t, ok := c.aliases[n.Name]; ok {
+			return t, nil
+		}
+		return "", fmt.Errorf("unknown field %s in stream %s", n.Name, c.schema.Name)
+	case *expr.UnaryExpr:
+		t, err := c.check(n.X)
+		if err != nil {
+			return "", err
+		}
+		if n.Op == expr.OpNot {
+			if t != "" && t != model.TypeBool {
+				return "", fmt.Errorf("invalid operation %s: operand is %s, expected BOOL", n, t)
+			}
+			return model.TypeBool, nil
+		}
+		if t != "" && !t.Numeric() {
+			return "", fmt.Errorf("invalid operation %s: operand is %s, expected a number", n, t)
+		}
+		return t, nil
+	case *expr.BinaryExpr:
+		return c.checkBinary(n)
+	case *expr.CallExpr:
+		return c.checkCall(n)
+	case *expr.CaseExpr:
+		return c.checkCase(n)
+	case *expr.InExpr:
+		t, err := c.check(n.X)
+		if err != nil {
+			return "", err
+		}
+		for _, item := range n.List {
+			it, err := c.check(item)
+			if err != nil {
+				return "", err
+			}
+			if !comparableTypes(t, it) {
+				return "", fmt.Errorf("invalid operation %s: mismatched types %s and %s", n, t, it)
+			}
+		}
+		return model.TypeBool, nil
+	case *expr.IsNullExpr:
+		if _, err := c.check(n.X); err != nil {
+			return "", err
+		}
+		return model.TypeBool, nil
+	}
+	return "", nil
+}
+
+func (c *typeChecker) checkBinary(n *expr.BinaryExpr) (model.DataType, error) {
+	lt, err := c.check(n.Left)
+	if err != nil {
+		return "", err
+	}
+	rt, err := c.check(n.Right)
+	if err != nil {
+		return "", err
+	}
+	switch n.Op {
+	case expr.OpAnd, expr.OpOr:
+		if (lt != "" && lt != model.TypeBool) || (rt != "" && rt != model.TypeBool) {
+			return "", fmt.Errorf("invalid operation %s: operands must be BOOL but are %s and %s", n, typeName(lt), typeName(rt))
+		}
+		return model.TypeBool, nil
+	case expr.OpEq, expr.OpNe, expr.OpLt, expr.OpLe, expr.OpGt, expr.OpGe:
+		if !comparableTypes(lt, rt) {
+			return "", fmt.Errorf("invalid operation %s: mismatched types %s and %s", n, lt, rt)
+		}
+		return model.TypeBool, nil
+	}
+	// 字符串可以用 + 拼接
+	if n.Op == expr.OpAdd && lt == model.TypeString && rt == model.TypeString {
+		return model.TypeString, nil
+	}
+	if (lt != "" && !lt.Numeric()) || (rt != "" && !rt.Numeric()) {
+		return "", fmt.Errorf("invalid operation %s: operands must be numbers but are %s and %s", n, typeName(lt), typeName(rt))
+	}
+	switch {
+	case lt == "" || rt == "":
+		return "", nil
+	case lt == model.TypeInt && rt == model.TypeInt && n.Op != expr.OpDiv:
+		return model.TypeInt, nil
+	default:
+		return model.TypeFloat, nil
+	}
+}
+
+// checkCall 检查函数调用的参数，聚合函数和标量函数的参数类型必须符合签名，数值参数不能是非数值字段
+func (c *typeChecker) checkCall(n *expr.CallExpr) (model.DataType, error) {
+	argTypes := make([]model.DataType, len(n.Args))
+	for i, arg := range n.Args {
+		t, err := c.check(arg)
+		if err != nil {
+			return "", err
+		}
+		argTypes[i] = t
+	}
+	if n.Over != nil {
+		for _, e := range n.Over.PartitionBy {
+			if _, err := c.check(e); err != nil {
+				return "", err
+			}
+		}
+		for _, item := range n.Over.OrderBy {
+			if _, err := c.check(item.Expr); err != nil {
+				return "", err
+			}
+		}
+	}
+	if !isAggregate(n) {
+		return checkFunction(n, argTypes)
+	}
+	if n.Star {
+		return model.TypeInt, nil
+	}
+	aggType := aggregateType(n)
+	sig, _ := aggregator.SignatureOf(string(aggType))
+	args := make([]functions.ArgType, len(argTypes))
+	for i, t := range argTypes {
+		args[i] = argTypeOf(t)
+		if i < len(sig.Args) && sig.Args[i] == functions.TypeNumber && t != "" && !t.Numeric() {
+			return "", fmt.Errorf("%s requires a numeric argument but %s is %s", n.Name, n.Args[i], t)
+		}
+	}
+	if err := sig.Check(n.Name, args); err != nil {
+		return "", err
+	}
+	switch aggType {
+	case aggregator.Count, aggregator.CountDistinct, aggregator.ApproxCountDistinct:
+		return model.TypeInt, nil
+	case aggregator.Min, aggregator.Max:
+		// 只能比较数值、字符串和时间
+		if argTypes[0] == model.TypeBool {
+			return "", fmt.Errorf("%s requires a number, string or timestamp argument but %s is %s", n.Name, n.Args[0], argTypes[0])
+		}
+		return argTypes[0], nil
+	case aggregator.FirstValue, aggregator.LastValue, aggregator.Mode:
+		// 结果为输入中的某个值，类型与参数相同
+		return argTypes[0], nil
+	}
+	return dataTypeOf(sig.Result), nil
+}
+
+// checkFunction 按函数签名检查标量函数调用的参数类型，返回函数返回值的类型，分析函数不做检查
+func checkFunction(n *expr.CallExpr, argTypes []model.DataType) (model.DataType, error) {
+	if n.Over != nil {
+		return "", nil
+	}
+	args := make([]functions.ArgType, len(argTypes))
+	for i, t := range argTypes {
+		args[i] = argTypeOf(t)
+	}
+	result, err := functions.Check(n.Name, args)
+	if err != nil {
+		return "", err
+	}
+	return dataTypeOf(result), nil
+}
+
+// dataTypeOf 返回函数返回值类型对应的字段类型，类型不确定时返回空字符串
+func dataTypeOf(result functions.ArgType) model.DataType {
+	switch result {
+	case functions.TypeNumber:
+		return model.TypeFloat
+	case functions.TypeString:
+		return model.TypeString
+	case functions.TypeBool:
+		return model.TypeBool
+	case functions.TypeTime:
+		return model.TypeTimestamp
+	}
+	return ""
+}
+
+// argTypeOf 返回字段类型对应的函数参数类型
+func argTypeOf(t model.DataType) functions.ArgType {
+	switch {
+	case t.Numeric():
+		return functions.TypeNumber
+	case t == model.TypeString:
+		return functions.TypeString
+	case t == model.TypeBool:
+		return functions.TypeBool
+	case t == model.TypeTimestamp:
+		return functions.TypeTime
+	}
+	return functions.TypeAny
+}
+
+// checkCase 检查 CASE 表达式，所有分支的结果类型相同时作为表达式的类型
+func (c *typeChecker) checkCase(n *expr.CaseExpr) (model.DataType, error) {
+	var operand model.DataType
+	if n.Operand != nil {
+		t, err := c.check(n.Operand)
+		if err != nil {
+			return "", err
+		}
+		operand = t
+	}
+	var result model.DataType
+	mixed := false
+	addResult := func(t model.DataType) {
+		switch {
+		case result == "":
+			result = t
+		case t != "" && t != result:
+			mixed = true
+		}
+	}
+	for _, w := range n.Whens {
+		if n.Operand != nil {
+			t, err := c.check(w.Cond)
+			if err != nil {
+				return "", err
+			}
+			if !comparableTypes(operand, t) {
+				return "", fmt.Errorf("invalid operation %s: mismatched types %s and %s", n, operand, t)
+			}
+		} else if err := c.checkCondition("WHEN", w.Cond); err != nil {
+			return "", err
+		}
+		t, err := c.check(w.Result)
+		if err != nil {
+			return "", err
+		}
+		addResult(t)
+	}
+	if n.Else != nil {
+		t, err := c.check(n.Else)
+		if err != nil {
+			return "", err
+		}
+		addResult(t)
+	}
+	if mixed {
+		return "", nil
+	}
+	return result, nil
+}
+
+// comparableTypes 判断两种类型的值能否比较，数值类型之间可以比较，类型未知时不做检查
+func comparableTypes(a, b model.DataType) bool {
+	return a == "" || b == "" || a == b || (a.Numeric() && b.Numeric())
+}
+
+// literalType 返回常量的类型，NULL 的类型未知
+func literalType(v interface{}) model.DataType {
+	switch v.(type) {
+	case int64:
+		return model.TypeInt
+	case float64:
+		return model.TypeFloat
+	case string:
+		return model.TypeString
+	case bool:
+		return model.TypeBool
+	}
+	return ""
+}
+
+// typeName 返回类型名称，类型未知时为 UNKNOWN
+func typeName(t model.DataType) string {
+	if t == "" {
+		return "UNKNOWN"
+	}
+	return string(t)
+}
@@ -0,0 +1,69 @@
+package rsql
+
+import (
+	"testing"
+
+	"github.com/rulego/streamsql/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindSchema(t *testing.T) {
+	schema := &model.Schema{
+		Name: "sensors",
+		Fields: []model.SchemaField{
+			{Name: "deviceId", Type: model.TypeString},
+			{Name: "temperature", Type: model.TypeFloat},
+			{Name: "count", Type: model.TypeInt},
+			{Name: "online", Type: model.TypeBool},
+			{Name: "ts", Type: model.TypeTimestamp},
+		},
+		TsProp: "ts",
+	}
+
+	for _, sql := range []string{
+		"SELECT deviceId, avg(temperature) AS avg_temp FROM sensors WHERE online AND count > 1 GROUP BY deviceId, TumblingWindow('1m') HAVING avg_temp > 20 ORDER BY avg_temp DESC",
+		"SELECT deviceId + '-' + deviceId AS name, count * 2 + temperature FROM sensors WHERE deviceId IN ('a', 'b') AND ts IS NOT NULL",
+		"SELECT CASE WHEN temperature > 30 THEN 'hot' ELSE 'cold' END AS level, count(deviceId) FROM sensors GROUP BY deviceId, TumblingWindow('1m')",
+		"SELECT deviceId FROM sensors WHERE NOT online",
+		"SELECT upper(deviceId) AS name, round(temperature, 1) FROM sensors WHERE date_diff('second', ts, now()) < 60 AND lower(deviceId) = 'aa'",
+		"SELECT deviceId, last_value(deviceId) AS s, max(ts) AS latest, min(deviceId) AS m FROM sensors GROUP BY deviceId, TumblingWindow('1m') HAVING s = 'on' AND m > 'a'",
+		"SELECT count(*) AS n, count(DISTINCT deviceId) AS d, approx_count_distinct(deviceId) AS a FROM sensors TumblingWindow('1m') HAVING n > 1 AND d + a > 2",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		assert.NoError(t, stmt.BindSchema(schema), sql)
+	}
+
+	// 查询未指定 TIMESTAMP 时使用流的事件时间字段
+	stmt, err := NewParser("SELECT count(deviceId) FROM sensors TumblingWindow('1m') WITH (EVENTTIME='true')").Parse()
+	require.NoError(t, err)
+	require.NoError(t, stmt.BindSchema(schema))
+	config, _, err := stmt.ToStreamConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "ts", config.WindowConfig.TsProp)
+	assert.Equal(t, model.EventTime, config.WindowConfig.TimeCharacteristic)
+
+	for sql, msg := range map[string]string{
+		"SELECT humidity FROM sensors":                                                                               "unknown field humidity in stream sensors",
+		"SELECT deviceId FROM sensors WHERE deviceId > 10":                                                           "invalid operation deviceId > 10: mismatched types STRING and INT",
+		"SELECT deviceId * 2 FROM sensors":                                                                           "invalid operation deviceId * 2: operands must be numbers but are STRING and INT",
+		"SELECT avg(deviceId) FROM sensors TumblingWindow('1m')":                                                     "avg requires a numeric argument but deviceId is STRING",
+		"SELECT deviceId FROM sensors WHERE temperature":                                                             "WHERE condition temperature must be BOOL but is FLOAT",
+		"SELECT deviceId FROM sensors WHERE online AND count":                                                        "invalid operation online && count: operands must be BOOL but are BOOL and INT",
+		"SELECT -deviceId FROM sensors":                                                                              "invalid operation -deviceId: operand is STRING, expected a number",
+		"SELECT deviceId FROM sensors WITH (TIMESTAMP='count')":                                                      "TIMESTAMP field count must be TIMESTAMP but is INT",
+		"SELECT deviceId FROM sensors WITH (TIMESTAMP='time')":                                                       "unknown TIMESTAMP field time in stream sensors",
+		"SELECT deviceId, max(temperature) AS m FROM sensors GROUP BY deviceId, TumblingWindow('1m') HAVING m > 'x'": "invalid operation m > 'x': mismatched types FLOAT and STRING",
+		"SELECT upper(temperature) FROM sensors":                                                                     "upper argument 1 expects STRING but got NUMBER",
+		"SELECT deviceId FROM sensors WHERE upper(deviceId) > 1":                                                     "invalid operation upper(deviceId) > 1: mismatched types STRING and INT",
+		"SELECT format_time(online) FROM sensors":                                                                    "format_time argument 1 expects TIME but got BOOL",
+		"SELECT max(online) FROM sensors TumblingWindow('1m')":                                                       "max requires a number, string or timestamp argument but online is BOOL",
+		"SELECT first_value(deviceId) AS f FROM sensors TumblingWindow('1m') HAVING f > 1":                           "invalid operation f > 1: mismatched types STRING and INT",
+		"SELECT count(DISTINCT deviceId) AS d FROM sensors TumblingWindow('1m') HAVING d = 'x'":                      "invalid operation d == 'x': mismatched types INT and STRING",
+	} {
+		stmt, err := NewParser(sql).Parse()
+		require.NoError(t, err, sql)
+		assert.EqualError(t, stmt.BindSchema(schema), msg, sql)
+	}
+}
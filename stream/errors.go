@@ -0,0 +1,62 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rulego/streamsql/aggregator"
+)
+
+// Stage 流处理的阶段
+type Stage string
+
+const (
+	// StageIngest 数据写入流的数据通道，如引擎发布数据时查询的数据通道已满
+	StageIngest Stage = "ingest"
+	// StageFilter 按 WHERE 条件过滤数据
+	StageFilter Stage = "filter"
+	// StageWindow 数据写入窗口
+	StageWindow Stage = "window"
+	// StageAggregate 窗口数据分组聚合
+	StageAggregate Stage = "aggregate"
+	// StageProject 计算输出字段和排序项
+	StageProject Stage = "project"
+	// StageHaving 按 HAVING 条件过滤分组，求值出错的分组被丢弃
+	StageHaving Stage = "having"
+	// StageResult 结果写入结果通道，如结果通道已满时按 WithResultOverflowPolicy 丢弃结果
+	StageResult Stage = "result"
+	// StageSink 调用 Sink 函数输出结果
+	StageSink Stage = "sink"
+)
+
+// StreamError 流处理过程中的错误，出错的数据被丢弃，不影响其他数据的处理
+type StreamError struct {
+	// Stage 出错的处理阶段
+	Stage Stage
+	// Field 出错的字段或表达式，无法确定时为空
+	Field string
+	// Data 出错的数据：ingest、filter、window、aggregate 阶段为输入数据，project、having 阶段为分组结果，result、sink 阶段为输出结果
+	Data interface{}
+	Err  error
+}
+
+func (e *StreamError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s error: field %s: %v, data: %v", e.Stage, e.Field, e.Err, e.Data)
+	}
+	return fmt.Sprintf("%s error: %v, data: %v", e.Stage, e.Err, e.Data)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// newStreamError 创建流处理错误，错误中带有字段信息时使用该字段
+func newStreamError(stage Stage, field string, data interface{}, err error) *StreamError {
+	var fieldErr *aggregator.FieldError
+	if errors.As(err, &fieldErr) {
+		field = fieldErr.Field
+		err = fieldErr.Err
+	}
+	return &StreamError{Stage: stage, Field: field, Data: data, Err: err}
+}
@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultDataBufferSize 默认的数据通道缓冲区大小
+	DefaultDataBufferSize = 1000
+	// DefaultResultBufferSize 默认的结果通道缓冲区大小
+	DefaultResultBufferSize = 10
+)
+
+var (
+	// ErrStreamClosed 流已经停止，数据被丢弃
+	ErrStreamClosed = errors.New("stream closed")
+	// ErrBufferFull 数据通道已满，数据被拒绝；或结果通道已满，结果被丢弃
+	ErrBufferFull = errors.New("stream buffer full")
+	// ErrAddTimeout 等待数据通道空闲超时，数据被拒绝
+	ErrAddTimeout = errors.New("stream add data timeout")
+)
+
+// OverflowPolicy 数据通道已满时 AddData 的处理策略，也用于结果通道已满时结果的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞直到数据通道空闲或流停止，默认策略
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest 丢弃新添加的数据，AddData 立即返回 ErrBufferFull
+	OverflowDropNewest
+	// OverflowDropOldest 丢弃数据通道中最早的数据，为新添加的数据腾出空间
+	OverflowDropOldest
+	// OverflowBlockWithTimeout 阻塞直到数据通道空闲，超过 WithAddTimeout 设置的时间后返回 ErrAddTimeout
+	OverflowBlockWithTimeout
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	case OverflowBlockWithTimeout:
+		return "block_with_timeout"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// Option 流的配置项
+type Option func(*options)
+
+type options struct {
+	dataBufferSize   int
+	resultBufferSize int
+	overflow         OverflowPolicy
+	addTimeout       time.Duration
+	// resultOverflow 结果通道已满时的处理策略
+	resultOverflow OverflowPolicy
+}
+
+func defaultOptions() options {
+	return options{
+		dataBufferSize:   DefaultDataBufferSize,
+		resultBufferSize: DefaultResultBufferSize,
+		overflow:         OverflowBlock,
+		resultOverflow:   OverflowBlock,
+	}
+}
+
+// validate 检查配置项是否有效
+func (o options) validate() error {
+	if o.dataBufferSize < 0 {
+		return fmt.Errorf("invalid data buffer size: %d", o.dataBufferSize)
+	}
+	if o.resultBufferSize < 0 {
+		return fmt.Errorf("invalid result buffer size: %d", o.resultBufferSize)
+	}
+	switch o.overflow {
+	case OverflowBlock, OverflowDropNewest:
+	case OverflowDropOldest:
+		if o.dataBufferSize == 0 {
+			return fmt.Errorf("overflow policy %s requires a data buffer", o.overflow)
+		}
+	case OverflowBlockWithTimeout:
+		if o.addTimeout <= 0 {
+			return fmt.Errorf("overflow policy %s requires a positive add timeout", o.overflow)
+		}
+	default:
+		return fmt.Errorf("unsupported overflow policy: %s", o.overflow)
+	}
+	switch o.resultOverflow {
+	case OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+	case OverflowBlockWithTimeout:
+		if o.addTimeout <= 0 {
+			return fmt.Errorf("result overflow policy %s requires a positive add timeout", o.resultOverflow)
+		}
+	default:
+		return fmt.Errorf("unsupported result overflow policy: %s", o.resultOverflow)
+	}
+	return nil
+}
+
+// WithDataBufferSize 设置数据通道的缓冲区大小，默认为 DefaultDataBufferSize
+func WithDataBufferSize(size int) Option {
+	return func(o *options) {
+		o.dataBufferSize = size
+	}
+}
+
+// WithResultBufferSize 设置结果通道的缓冲区大小，默认为 DefaultResultBufferSize
+func WithResultBufferSize(size int) Option {
+	return func(o *options) {
+		o.resultBufferSize = size
+	}
+}
+
+// WithOverflowPolicy 设置数据通道已满时 AddData 的处理策略，默认为 OverflowBlock
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.overflow = policy
+	}
+}
+
+// WithResultOverflowPolicy 设置结果通道已满时结果的处理策略，默认为 OverflowBlock。
+// OverflowDropNewest 丢弃新的结果，OverflowDropOldest 丢弃结果通道中最早的结果，
+// OverflowBlockWithTimeout 等待 WithAddTimeout 设置的时间后丢弃新的结果，被丢弃的结果按 result 阶段报告错误 ErrBufferFull
+func WithResultOverflowPolicy(policy OverflowPolicy) Option {
+	return func(o *options) {
+		o.resultOverflow = policy
+	}
+}
+
+// WithAddTimeout 设置 OverflowBlockWithTimeout 策略下 AddData 和结果输出的最长等待时间
+func WithAddTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.addTimeout = timeout
+	}
+}
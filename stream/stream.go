@@ -1,16 +1,27 @@
 package stream
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	aggregator2 "github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
 	"github.com/rulego/streamsql/model"
 	"github.com/rulego/streamsql/parser"
 	"github.com/rulego/streamsql/window"
 )
 
 type Stream struct {
+	// dropped 数据通道已满时被丢弃的数据条数，droppedResults 结果通道已满时被丢弃的结果条数，放在首位保证原子操作的 64 位对齐
+	dropped        uint64
+	droppedResults uint64
+
 	dataChan   chan interface{}
 	filter     parser.Condition
 	Window     window.Window
@@ -18,19 +29,94 @@ type Stream struct {
 	config     model.Config
 	sinks      []func(interface{})
 	resultChan chan interface{} // 结果通道
+	lateSinks  []func(interface{})
+	lateChan   chan interface{}  // 迟到数据通道
+	errorChan  chan *StreamError // 错误通道
+	// errorHandlers 处理错误的函数
+	errorHandlers []func(*StreamError)
+	// mu 保护 closed，停止接收数据时与 AddData 互斥
+	mu     sync.RWMutex
+	closed bool
+	// done 关闭时放弃处理剩余的数据和未输出的结果
+	done      chan struct{}
+	abortOnce sync.Once
+	// wg 等待数据处理协程和窗口处理协程退出
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	opts     options
 }
 
-func NewStream(config model.Config) (*Stream, error) {
-	win, err := window.CreateWindow(config.WindowConfig)
-	if err != nil {
+// NewStream 根据配置创建流，opts 设置通道缓冲区大小和数据通道已满时的处理策略
+func NewStream(config model.Config, opts ...Option) (*Stream, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := o.validate(); err != nil {
 		return nil, err
 	}
-	return &Stream{
-		dataChan:   make(chan interface{}, 1000),
+	if config.WindowConfig.GroupFields == nil {
+		config.WindowConfig.GroupFields = config.GroupFields
+	}
+	if config.WindowConfig.GroupBy == nil {
+		config.WindowConfig.GroupBy = config.GroupBy
+	}
+	// 没有配置窗口类型时为无窗口查询，每条数据过滤、投影后直接输出
+	var win window.Window
+	if config.WindowConfig.Type != "" {
+		var err error
+		win, err = window.CreateWindow(config.WindowConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s := &Stream{
+		dataChan:   make(chan interface{}, o.dataBufferSize),
 		config:     config,
 		Window:     win,
-		resultChan: make(chan interface{}, 10),
-	}, nil
+		resultChan: make(chan interface{}, o.resultBufferSize),
+		lateChan:   make(chan interface{}, 100),
+		errorChan:  make(chan *StreamError, 100),
+		done:       make(chan struct{}),
+		opts:       o,
+	}
+	if config.Where != nil {
+		s.filter = parser.NewCondition(config.Where)
+	}
+	if win != nil {
+		agg, err := newAggregator(config)
+		if err != nil {
+			return nil, err
+		}
+		s.aggregator = agg
+		// 所有聚合器都可以合并时，滑动窗口按分片预聚合，触发时直接输出分组聚合结果
+		if sw, ok := win.(*window.SlidingWindow); ok {
+			sw.SetPaneAggregator(agg, func(data interface{}, err error) {
+				s.reportError(newStreamError(StageAggregate, "", data, err))
+			})
+		}
+	}
+	if lw, ok := win.(window.LateDataWindow); ok {
+		lw.SetLateDataCallback(s.handleLateData)
+	}
+	return s, nil
+}
+
+// newAggregator 根据配置创建窗口的分组聚合器
+func newAggregator(config model.Config) (*aggregator2.GroupAggregator, error) {
+	if len(config.Aggregations) == 0 && len(config.Projection) == 0 {
+		return aggregator2.NewGroupAggregator(config.GroupFields, config.SelectFields, config.FieldAlias)
+	}
+	groups := config.GroupBy
+	if len(groups) == 0 {
+		groups = aggregator2.GroupFieldsOf(config.GroupFields)
+	}
+	agg, err := aggregator2.NewGroupAggregatorWithGroups(groups, config.Aggregations)
+	if err != nil {
+		return nil, err
+	}
+	agg.SetRowFields(config.RowFields)
+	return agg, nil
 }
 
 func (s *Stream) RegisterFilter(condition string) error {
@@ -46,57 +132,566 @@ func (s *Stream) RegisterFilter(condition string) error {
 }
 
 func (s *Stream) Start() {
+	if s.Window == nil {
+		s.wg.Add(1)
+		go s.process()
+		return
+	}
+	// 启动窗口处理协程
+	s.Window.Start()
+
+	s.wg.Add(2)
 	go s.process()
+	go s.processWindow()
 }
 
+// process 接收输入数据，过滤后写入窗口，无窗口查询则直接投影并输出。
+// 事件时间语义下窗口可能在写入数据时触发，因此窗口输出由 processWindow 在独立的协程中处理。
+// 数据通道关闭后触发所有未触发的窗口，然后停止窗口，processWindow 随窗口输出通道关闭而退出
 func (s *Stream) process() {
-	s.aggregator = aggregator2.NewGroupAggregator(s.config.GroupFields, s.config.SelectFields, s.config.FieldAlias)
+	defer s.wg.Done()
+	for {
+		select {
+		case data, ok := <-s.dataChan:
+			if !ok {
+				if s.Window != nil {
+					s.Window.Flush()
+					s.Window.Stop()
+				}
+				return
+			}
+			if !s.accept(data) {
+				continue
+			}
+			if s.Window == nil {
+				s.emit(s.projectRow(data))
+				continue
+			}
+			if err := window.ValidateTimestamp(data, s.config.WindowConfig); err != nil {
+				s.reportError(newStreamError(StageWindow, s.config.WindowConfig.TsProp, data, err))
+				continue
+			}
+			s.Window.Add(data)
+		case <-s.done:
+			if s.Window != nil {
+				s.Window.Stop()
+			}
+			return
+		}
+	}
+}
 
-	// 启动窗口处理协程
-	s.Window.Start()
+// accept 判断数据是否满足过滤条件，求值出错的数据被丢弃
+func (s *Stream) accept(data interface{}) bool {
+	if s.filter == nil {
+		return true
+	}
+	ok, err := s.filter.Evaluate(data)
+	if err != nil {
+		s.reportError(newStreamError(StageFilter, "", data, err))
+		return false
+	}
+	return ok
+}
+
+// projectRow 无窗口查询按输出字段的语法树计算单条数据的输出结果，没有配置输出字段时原样输出
+func (s *Stream) projectRow(data interface{}) interface{} {
+	if len(s.config.Projection) == 0 {
+		return data
+	}
+	row := make(map[string]interface{}, len(s.config.Projection))
+	for _, field := range s.config.Projection {
+		name := field.Alias
+		if name == "" {
+			name = field.Node.String()
+		}
+		val, err := expr.Eval(field.Node, data)
+		if err != nil {
+			s.reportError(newStreamError(StageProject, name, data, err))
+		}
+		row[name] = val
+	}
+	return row
+}
+
+// emit 将结果发送到结果通道并交给 Sink 函数，流已停止时丢弃结果。
+// 注册了 Sink 函数时结果通道已满不再等待，结果只交给 Sink 函数，避免只订阅结果而不读取结果通道时窗口停止触发；
+// 没有 Sink 函数时结果通道已满按 WithResultOverflowPolicy 设置的策略处理
+func (s *Stream) emit(result interface{}) {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if len(s.sinks) > 0 {
+		select {
+		case s.resultChan <- result:
+		default:
+		}
+	} else if !s.sendResult(result) {
+		return
+	}
+	for _, sink := range s.sinks {
+		s.callSink(sink, result)
+	}
+}
+
+// sendResult 按结果通道的溢出策略将结果写入结果通道，流已停止时返回 false
+func (s *Stream) sendResult(result interface{}) bool {
+	switch s.opts.resultOverflow {
+	case OverflowDropNewest:
+		select {
+		case s.resultChan <- result:
+		default:
+			s.dropResult(result)
+		}
+	case OverflowDropOldest:
+		s.pushResult(result)
+	case OverflowBlockWithTimeout:
+		timer := time.NewTimer(s.opts.addTimeout)
+		defer timer.Stop()
+		select {
+		case s.resultChan <- result:
+		case <-s.done:
+			return false
+		case <-timer.C:
+			s.dropResult(result)
+		}
+	default:
+		select {
+		case s.resultChan <- result:
+		case <-s.done:
+			return false
+		}
+	}
+	return true
+}
 
+// pushResult 写入结果通道，通道已满时丢弃通道中最早的结果后重试。无缓冲的结果通道没有可丢弃的结果，丢弃新的结果
+func (s *Stream) pushResult(result interface{}) {
 	for {
 		select {
-		case data := <-s.dataChan:
-			if s.filter == nil || s.filter.Evaluate(data) {
-				s.Window.Add(data)
-				// fmt.Printf("add data to win : %v \n", data)
+		case s.resultChan <- result:
+			return
+		default:
+		}
+		select {
+		case old := <-s.resultChan:
+			s.dropResult(old)
+		default:
+			if cap(s.resultChan) == 0 {
+				s.dropResult(result)
+				return
 			}
-		case batch := <-s.Window.OutputChan():
-			// 处理窗口批数据
-			for _, item := range batch {
-				s.aggregator.Put("window_start", item.Slot.WindowStart())
-				s.aggregator.Put("window_end", item.Slot.WindowEnd())
-				if err := s.aggregator.Add(item.Data); err != nil {
-					fmt.Printf("aggregate error: %v\n", err)
-				}
+		}
+	}
+}
+
+// dropResult 记录并按 result 阶段报告被丢弃的结果
+func (s *Stream) dropResult(result interface{}) {
+	atomic.AddUint64(&s.droppedResults, 1)
+	s.reportError(newStreamError(StageResult, "", result, ErrBufferFull))
+}
+
+// callSink 调用 Sink 函数，Sink 函数 panic 时作为错误处理，不影响后续结果的输出
+func (s *Stream) callSink(sink func(interface{}), result interface{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.reportError(newStreamError(StageSink, "", result, fmt.Errorf("sink panic: %v", r)))
+		}
+	}()
+	sink(result)
+}
+
+// reportError 发送错误到错误通道和错误处理函数，错误通道已满时丢弃通道中的这条错误，避免阻塞数据处理
+func (s *Stream) reportError(err *StreamError) {
+	select {
+	case s.errorChan <- err:
+	default:
+	}
+	for _, handler := range s.errorHandlers {
+		handler(err)
+	}
+}
+
+// processWindow 接收窗口触发的批数据，进行聚合并输出结果。
+func (s *Stream) processWindow() {
+	defer s.wg.Done()
+	for batch := range s.Window.OutputChan() {
+		// 流已停止时只排空窗口输出，避免窗口阻塞
+		if s.aborted() {
+			continue
+		}
+		// 按分片聚合的滑动窗口已经在窗口中完成聚合
+		if len(batch) == 1 {
+			if results, ok := batch[0].Data.(window.PaneResults); ok {
+				s.emit(s.project(results))
+				continue
 			}
+		}
+		// 处理窗口批数据
+		for _, item := range batch {
+			s.aggregator.Put("window_start", item.Slot.WindowStart())
+			s.aggregator.Put("window_end", item.Slot.WindowEnd())
+			s.aggregator.Put(aggregator2.EventTimeKey, item.Timestamp)
+			if err := s.aggregator.Add(item.Data); err != nil {
+				s.reportError(newStreamError(StageAggregate, "", item.Data, err))
+			}
+		}
 
-			// 获取并发送聚合结果
-			if results, err := s.aggregator.GetResults(); err == nil {
-				// 发送结果到结果通道和 Sink 函数
-				s.resultChan <- results
-				for _, sink := range s.sinks {
-					sink(results)
+		// 获取并发送聚合结果
+		if results, err := s.aggregator.GetResults(); err == nil {
+			s.emit(s.project(results))
+			s.aggregator.Reset()
+		}
+	}
+}
+
+// project 按输出字段的语法树计算每个分组的输出结果，用 HAVING 条件过滤分组，再按 ORDER BY 排序并截取 LIMIT 条结果。
+// 分组结果中包含分组字段和以聚合调用文本为名称的聚合结果，输出字段中的聚合调用从中取值。
+// HAVING 条件和排序项既可以引用输出字段的别名，也可以直接使用聚合表达式
+func (s *Stream) project(results []map[string]interface{}) []map[string]interface{} {
+	cfg := s.config
+	if len(cfg.Projection) == 0 && cfg.Having == nil && len(cfg.OrderBy) == 0 && cfg.Limit <= 0 {
+		return results
+	}
+	rows := make([]map[string]interface{}, 0, len(results))
+	envs := make([]map[string]interface{}, 0, len(results))
+	for _, group := range results {
+		row := group
+		if len(cfg.Projection) > 0 {
+			row = make(map[string]interface{}, len(cfg.Projection))
+			for _, field := range cfg.Projection {
+				name := field.Alias
+				if name == "" {
+					name = field.Node.String()
 				}
-				s.aggregator.Reset()
+				val, err := expr.Eval(field.Node, expr.GroupEnv(group))
+				if err != nil {
+					s.reportError(newStreamError(StageProject, name, group, err))
+				}
+				row[name] = val
+			}
+		}
+		env := mergeEnv(group, row)
+		if cfg.Having != nil && !s.having(env) {
+			continue
+		}
+		rows = append(rows, row)
+		envs = append(envs, env)
+	}
+	if len(cfg.OrderBy) > 0 {
+		rows = s.sortRows(rows, envs, cfg.OrderBy)
+	}
+	if cfg.Limit > 0 && len(rows) > cfg.Limit {
+		rows = rows[:cfg.Limit]
+	}
+	return rows
+}
+
+// mergeEnv 合并分组结果和输出结果，作为 HAVING 条件和排序项的求值环境，输出字段的别名优先于分组结果中的同名字段
+func mergeEnv(group, row map[string]interface{}) map[string]interface{} {
+	env := make(map[string]interface{}, len(group)+len(row))
+	for k, v := range group {
+		env[k] = v
+	}
+	for k, v := range row {
+		env[k] = v
+	}
+	return env
+}
+
+// having 判断分组是否满足 HAVING 条件，求值出错时按 having 阶段报告错误并丢弃该分组
+func (s *Stream) having(env map[string]interface{}) bool {
+	val, err := expr.Eval(s.config.Having, expr.GroupEnv(env))
+	if err != nil {
+		s.reportError(newStreamError(StageHaving, s.config.Having.String(), env, err))
+		return false
+	}
+	return expr.Truthy(val)
+}
+
+// sortRows 按排序项对输出结果进行稳定排序。
+// nil 视为最大值，即升序时排在最后、降序时排在最前；无法比较的值保持原有顺序
+func (s *Stream) sortRows(rows, envs []map[string]interface{}, orderBy []expr.OrderItem) []map[string]interface{} {
+	keys := make([][]interface{}, len(rows))
+	for i, env := range envs {
+		keys[i] = make([]interface{}, len(orderBy))
+		for j, item := range orderBy {
+			val, err := expr.Eval(item.Expr, expr.GroupEnv(env))
+			if err != nil {
+				s.reportError(newStreamError(StageProject, item.Expr.String(), env, err))
+			}
+			keys[i][j] = val
+		}
+	}
+	index := make([]int, len(rows))
+	for i := range index {
+		index[i] = i
+	}
+	sort.SliceStable(index, func(a, b int) bool {
+		for j, item := range orderBy {
+			c := compareValues(keys[index[a]][j], keys[index[b]][j])
+			if c == 0 {
+				continue
+			}
+			if item.Desc {
+				return c > 0
 			}
+			return c < 0
+		}
+		return false
+	})
+	sorted := make([]map[string]interface{}, len(rows))
+	for i, idx := range index {
+		sorted[i] = rows[idx]
+	}
+	return sorted
+}
+
+// compareValues 比较两个排序键，nil 视为最大值
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+	c, err := expr.Compare(a, b)
+	if err != nil {
+		return 0
+	}
+	return c
+}
+
+// AddData 添加流数据，数据通道已满时按配置的 OverflowPolicy 处理。
+// 流停止后添加的数据被丢弃并返回 ErrStreamClosed；数据被拒绝时返回 ErrBufferFull 或 ErrAddTimeout
+func (s *Stream) AddData(data interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	switch s.opts.overflow {
+	case OverflowDropNewest:
+		return s.tryAdd(data)
+	case OverflowDropOldest:
+		return s.addDropOldest(data)
+	case OverflowBlockWithTimeout:
+		timer := time.NewTimer(s.opts.addTimeout)
+		defer timer.Stop()
+		select {
+		case s.dataChan <- data:
+			return nil
+		case <-s.done:
+			return ErrStreamClosed
+		case <-timer.C:
+			atomic.AddUint64(&s.dropped, 1)
+			return ErrAddTimeout
+		}
+	default:
+		select {
+		case s.dataChan <- data:
+			return nil
+		case <-s.done:
+			return ErrStreamClosed
+		}
+	}
+}
+
+// TryAddData 不阻塞地添加流数据，数据通道已满时拒绝数据并返回 ErrBufferFull，流停止后返回 ErrStreamClosed
+func (s *Stream) TryAddData(data interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	return s.tryAdd(data)
+}
+
+// OfferData 不阻塞地添加流数据，供不能被单个流阻塞的调用方使用，如引擎按流名称向多个查询发布数据。
+// OverflowDropOldest 策略丢弃数据通道中最早的数据；其他策略在数据通道已满时拒绝数据并返回 ErrBufferFull，
+// 同时按 ingest 阶段报告被丢弃的数据。流停止后返回 ErrStreamClosed
+func (s *Stream) OfferData(data interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if s.opts.overflow == OverflowDropOldest {
+		return s.addDropOldest(data)
+	}
+	err := s.tryAdd(data)
+	if errors.Is(err, ErrBufferFull) {
+		s.reportError(newStreamError(StageIngest, "", data, err))
+	}
+	return err
+}
+
+// tryAdd 不阻塞地写入数据通道，调用方需持有读锁
+func (s *Stream) tryAdd(data interface{}) error {
+	select {
+	case s.dataChan <- data:
+		return nil
+	case <-s.done:
+		return ErrStreamClosed
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+		return ErrBufferFull
+	}
+}
+
+// addDropOldest 写入数据通道，通道已满时丢弃通道中最早的数据后重试，调用方需持有读锁
+func (s *Stream) addDropOldest(data interface{}) error {
+	for {
+		select {
+		case s.dataChan <- data:
+			return nil
+		case <-s.done:
+			return ErrStreamClosed
+		default:
+		}
+		select {
+		case <-s.dataChan:
+			atomic.AddUint64(&s.dropped, 1)
+		default:
 		}
 	}
 }
 
-func (s *Stream) AddData(data interface{}) {
-	s.dataChan <- data
+// Dropped 返回数据通道已满时被丢弃或拒绝的数据条数
+func (s *Stream) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// DroppedResults 返回结果通道已满时按 WithResultOverflowPolicy 被丢弃的结果条数
+func (s *Stream) DroppedResults() uint64 {
+	return atomic.LoadUint64(&s.droppedResults)
+}
+
+// Stop 立即停止流处理：不再接收数据，丢弃尚未处理的数据和未触发的窗口，
+// 等待所有协程退出后关闭结果通道和迟到数据通道
+func (s *Stream) Stop() {
+	s.abort()
+	s.closeIntake()
+	s.wg.Wait()
+	s.closeOutput()
+}
+
+// Close 优雅地停止流处理：不再接收数据，处理完已接收的数据，触发所有未触发的窗口并输出结果，
+// 然后关闭结果通道和迟到数据通道。ctx 结束时放弃剩余的处理，按 Stop 的方式停止并返回 ctx 的错误
+func (s *Stream) Close(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		s.closeIntake()
+		s.wg.Wait()
+		close(finished)
+	}()
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+		s.abort()
+		<-finished
+	}
+	s.closeOutput()
+	return err
+}
+
+// closeIntake 停止接收数据并关闭数据通道
+func (s *Stream) closeIntake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.dataChan)
+}
+
+// closeOutput 关闭结果通道、迟到数据通道和错误通道，调用方需保证所有协程已经退出
+func (s *Stream) closeOutput() {
+	s.stopOnce.Do(func() {
+		if s.Window != nil {
+			s.Window.Stop()
+		}
+		close(s.resultChan)
+		close(s.lateChan)
+		close(s.errorChan)
+	})
+}
+
+// abort 放弃处理剩余的数据和未输出的结果
+func (s *Stream) abort() {
+	s.abortOnce.Do(func() {
+		close(s.done)
+	})
 }
 
+// aborted 判断流是否已经放弃处理
+func (s *Stream) aborted() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// AddSink 添加 Sink 函数，每个结果都会交给 Sink 函数，注册了 Sink 函数后结果通道已满时不再阻塞结果的输出
 func (s *Stream) AddSink(sink func(interface{})) {
 	s.sinks = append(s.sinks, sink)
 }
 
+// AddSinkE 添加返回错误的 Sink 函数，返回的错误作为 sink 阶段的错误报告
+func (s *Stream) AddSinkE(sink func(interface{}) error) {
+	s.AddSink(func(result interface{}) {
+		if err := sink(result); err != nil {
+			s.reportError(newStreamError(StageSink, "", result, err))
+		}
+	})
+}
+
+// GetResultsChan 返回结果通道。没有 Sink 函数时结果通道已满按 WithResultOverflowPolicy 处理，默认阻塞结果的输出，注册了 Sink 函数时通道已满的结果不再写入通道
 func (s *Stream) GetResultsChan() <-chan interface{} {
 	return s.resultChan
 }
 
+// handleLateData 处理超过允许迟到时间的数据，发送到迟到数据通道和迟到数据 Sink 函数。
+// 迟到数据通道已满时丢弃通道中的这条数据，避免阻塞窗口处理。
+func (s *Stream) handleLateData(row model.Row) {
+	select {
+	case s.lateChan <- row.Data:
+	default:
+	}
+	for _, sink := range s.lateSinks {
+		sink(row.Data)
+	}
+}
+
+// AddErrorHandler 添加错误处理函数，过滤、窗口、聚合、输出等阶段出错时调用
+func (s *Stream) AddErrorHandler(handler func(*StreamError)) {
+	s.errorHandlers = append(s.errorHandlers, handler)
+}
+
+// GetErrorChan 获取错误通道，通道已满时新的错误不会写入通道
+func (s *Stream) GetErrorChan() <-chan *StreamError {
+	return s.errorChan
+}
+
+// AddLateSink 添加迟到数据的 Sink 函数，超过允许迟到时间的数据不再参与窗口计算，而是交给这些函数
+func (s *Stream) AddLateSink(sink func(interface{})) {
+	s.lateSinks = append(s.lateSinks, sink)
+}
+
+// GetLateDataChan 获取迟到数据通道，通道已满时新的迟到数据不会写入通道
+func (s *Stream) GetLateDataChan() <-chan interface{} {
+	return s.lateChan
+}
+
 func NewStreamProcessor() (*Stream, error) {
 	return NewStream(model.Config{})
 }
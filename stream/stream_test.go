@@ -3,11 +3,14 @@ package stream
 import (
 	"context"
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/expr"
 	"github.com/rulego/streamsql/model"
+	"github.com/rulego/streamsql/window"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -311,3 +314,497 @@ func TestWindowSlotAgg(t *testing.T) {
 		assert.True(t, found, fmt.Sprintf("Expected result for device %v not found", expectedResult["device"]))
 	}
 }
+
+func TestStreamLateData(t *testing.T) {
+	config := model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:               "tumbling",
+			Params:             map[string]interface{}{"size": 2 * time.Second},
+			TsProp:             "ts",
+			TimeCharacteristic: model.EventTime,
+			AllowedLateness:    time.Second,
+		},
+		GroupFields: []string{"device"},
+		SelectFields: map[string]aggregator.AggregateType{
+			"temperature": aggregator.Sum,
+		},
+	}
+
+	strm, err := NewStream(config)
+	require.NoError(t, err)
+	lateSinkChan := make(chan interface{}, 10)
+	strm.AddLateSink(func(data interface{}) {
+		lateSinkChan <- data
+	})
+	strm.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	nextResult := func() []map[string]interface{} {
+		select {
+		case result := <-strm.GetResultsChan():
+			return result.([]map[string]interface{})
+		case <-time.After(time.Second):
+			t.Fatal("No results received within timeout")
+		}
+		return nil
+	}
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 10.0, "ts": baseTime})
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 20.0, "ts": baseTime.Add(2 * time.Second)})
+	assert.InDelta(t, 10.0, nextResult()[0]["temperature_sum"].(float64), 0.0001)
+
+	// 允许迟到时间内的迟到数据使窗口 [0s,2s) 输出更新后的结果
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 5.0, "ts": baseTime.Add(time.Second)})
+	assert.InDelta(t, 15.0, nextResult()[0]["temperature_sum"].(float64), 0.0001)
+
+	// 超过允许迟到时间的数据输出到迟到数据通道和 Sink 函数
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 30.0, "ts": baseTime.Add(4 * time.Second)})
+	nextResult()
+	lateData := map[string]interface{}{"device": "aa", "temperature": 1.0, "ts": baseTime.Add(500 * time.Millisecond)}
+	strm.AddData(lateData)
+	select {
+	case data := <-strm.GetLateDataChan():
+		assert.Equal(t, lateData, data)
+	case <-time.After(time.Second):
+		t.Fatal("No late data received within timeout")
+	}
+	assert.Equal(t, lateData, <-lateSinkChan)
+}
+
+func TestSortRows(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"device": "aa", "value": 2.0},
+		{"device": "bb", "value": nil},
+		{"device": "cc", "value": 5},
+		{"device": "dd", "value": 2},
+	}
+	asc := (&Stream{}).sortRows(rows, rows, []expr.OrderItem{{Expr: &expr.Ident{Name: "value"}}})
+	assert.Equal(t, []interface{}{"aa", "dd", "cc", "bb"}, devices(asc))
+
+	desc := (&Stream{}).sortRows(rows, rows, []expr.OrderItem{
+		{Expr: &expr.Ident{Name: "value"}, Desc: true},
+		{Expr: &expr.Ident{Name: "device"}, Desc: true},
+	})
+	assert.Equal(t, []interface{}{"bb", "cc", "dd", "aa"}, devices(desc))
+}
+
+func devices(rows []map[string]interface{}) []interface{} {
+	result := make([]interface{}, len(rows))
+	for i, row := range rows {
+		result[i] = row["device"]
+	}
+	return result
+}
+
+func TestStreamClose(t *testing.T) {
+	config := model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:   "tumbling",
+			Params: map[string]interface{}{"size": time.Minute},
+		},
+		GroupFields: []string{"device"},
+		SelectFields: map[string]aggregator.AggregateType{
+			"temperature": aggregator.Sum,
+		},
+	}
+	strm, err := NewStream(config)
+	require.NoError(t, err)
+	strm.Start()
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0})
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 30.0})
+
+	// Close 处理完已接收的数据并输出未触发的窗口后关闭结果通道
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, strm.Close(ctx))
+	var results []interface{}
+	for result := range strm.GetResultsChan() {
+		results = append(results, result)
+	}
+	require.Len(t, results, 1)
+	rows := results[0].([]map[string]interface{})
+	require.Len(t, rows, 1)
+	assert.InDelta(t, 55.0, rows[0]["temperature_sum"], 0.0001)
+
+	// 停止后添加的数据被丢弃，重复停止是安全的
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 30.0})
+	strm.Stop()
+	require.NoError(t, strm.Close(ctx))
+}
+
+func TestStreamStop(t *testing.T) {
+	strm, err := NewStream(model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:   "tumbling",
+			Params: map[string]interface{}{"size": time.Minute},
+		},
+	})
+	require.NoError(t, err)
+	strm.Start()
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0})
+
+	// Stop 丢弃未触发的窗口
+	strm.Stop()
+	_, ok := <-strm.GetResultsChan()
+	assert.False(t, ok)
+	_, ok = <-strm.GetLateDataChan()
+	assert.False(t, ok)
+}
+
+func TestStreamCloseTimeout(t *testing.T) {
+	strm, err := NewStream(model.Config{})
+	require.NoError(t, err)
+	strm.Start()
+	// 结果通道已满且没有读取方时，Close 在 ctx 结束后放弃剩余的处理
+	for i := 0; i < cap(strm.resultChan)+1; i++ {
+		strm.AddData(map[string]interface{}{"temperature": i})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, strm.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestStreamErrors(t *testing.T) {
+	strm, err := NewStream(model.Config{
+		Where: &expr.BinaryExpr{Op: expr.OpGt, Left: &expr.Ident{Name: "temperature"}, Right: &expr.Literal{Value: int64(40)}},
+	})
+	require.NoError(t, err)
+	var handled []*StreamError
+	strm.AddErrorHandler(func(err *StreamError) {
+		handled = append(handled, err)
+	})
+	strm.AddSink(func(result interface{}) {
+		if result.(map[string]interface{})["device"] == "bb" {
+			panic("sink failed")
+		}
+	})
+	strm.Start()
+
+	// 无法比较的数据在过滤阶段出错，Sink 函数 panic 时作为错误处理，都不影响后续数据
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": "hot"})
+	strm.AddData(map[string]interface{}{"device": "bb", "temperature": 45})
+	strm.AddData(map[string]interface{}{"device": "cc", "temperature": 50})
+	require.NoError(t, strm.Close(context.Background()))
+
+	var results []interface{}
+	for result := range strm.GetResultsChan() {
+		results = append(results, result)
+	}
+	assert.Len(t, results, 2)
+	var errs []*StreamError
+	for e := range strm.GetErrorChan() {
+		errs = append(errs, e)
+	}
+	require.Len(t, errs, 2)
+	assert.Equal(t, handled, errs)
+	assert.Equal(t, StageFilter, errs[0].Stage)
+	assert.Equal(t, map[string]interface{}{"device": "aa", "temperature": "hot"}, errs[0].Data)
+	assert.Equal(t, StageSink, errs[1].Stage)
+}
+
+func TestStreamWindowErrors(t *testing.T) {
+	strm, err := NewStream(model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:   "tumbling",
+			Params: map[string]interface{}{"size": time.Minute},
+			TsProp: "ts",
+		},
+		GroupFields:  []string{"device"},
+		SelectFields: map[string]aggregator.AggregateType{"temperature": aggregator.Sum},
+	})
+	require.NoError(t, err)
+	strm.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	// 时间戳类型错误的数据在写入窗口前被丢弃，非数值字段在聚合时出错
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0, "ts": "16:46"})
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": "hot", "ts": baseTime})
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 30.0, "ts": baseTime})
+	require.NoError(t, strm.Close(context.Background()))
+
+	var errs []*StreamError
+	for e := range strm.GetErrorChan() {
+		errs = append(errs, e)
+	}
+	require.Len(t, errs, 2)
+	assert.Equal(t, StageWindow, errs[0].Stage)
+	assert.Equal(t, "ts", errs[0].Field)
+	assert.Equal(t, StageAggregate, errs[1].Stage)
+	assert.Equal(t, "temperature", errs[1].Field)
+	assert.Contains(t, errs[1].Error(), "aggregate error: field temperature")
+
+	_, err = NewStream(model.Config{
+		WindowConfig: model.WindowConfig{Type: "tumbling", Params: map[string]interface{}{"size": time.Minute}},
+		SelectFields: map[string]aggregator.AggregateType{"temperature": "unknown"},
+	})
+	assert.Error(t, err)
+}
+
+func TestStreamHavingErrors(t *testing.T) {
+	// HAVING temperature_sum / 0 > 1
+	having := &expr.BinaryExpr{
+		Op:    expr.OpGt,
+		Left:  &expr.BinaryExpr{Op: expr.OpDiv, Left: &expr.Ident{Name: "temperature_sum"}, Right: &expr.Literal{Value: int64(0)}},
+		Right: &expr.Literal{Value: int64(1)},
+	}
+	strm, err := NewStream(model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:   "tumbling",
+			Params: map[string]interface{}{"size": time.Minute},
+			TsProp: "ts",
+		},
+		GroupFields:  []string{"device"},
+		SelectFields: map[string]aggregator.AggregateType{"temperature": aggregator.Sum},
+		Having:       having,
+	})
+	require.NoError(t, err)
+	strm.Start()
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0, "ts": time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)})
+	require.NoError(t, strm.Close(context.Background()))
+
+	// HAVING 求值出错的分组被丢弃，错误与输出字段的错误区分开
+	for result := range strm.GetResultsChan() {
+		assert.Empty(t, result)
+	}
+	var errs []*StreamError
+	for e := range strm.GetErrorChan() {
+		errs = append(errs, e)
+	}
+	require.Len(t, errs, 1)
+	assert.Equal(t, StageHaving, errs[0].Stage)
+	assert.Equal(t, having.String(), errs[0].Field)
+	assert.EqualError(t, errs[0].Err, "division by zero")
+}
+
+func TestStreamOverflowPolicy(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithDataBufferSize(2), WithOverflowPolicy(OverflowDropNewest))
+		require.NoError(t, err)
+		defer s.Stop()
+		assert.NoError(t, s.AddData(1))
+		assert.NoError(t, s.AddData(2))
+		assert.ErrorIs(t, s.AddData(3), ErrBufferFull)
+		assert.Equal(t, uint64(1), s.Dropped())
+
+		s.Start()
+		assert.Equal(t, 1, <-s.GetResultsChan())
+		assert.Equal(t, 2, <-s.GetResultsChan())
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithDataBufferSize(2), WithOverflowPolicy(OverflowDropOldest))
+		require.NoError(t, err)
+		defer s.Stop()
+		for i := 1; i <= 4; i++ {
+			assert.NoError(t, s.AddData(i))
+		}
+		assert.Equal(t, uint64(2), s.Dropped())
+
+		s.Start()
+		assert.Equal(t, 3, <-s.GetResultsChan())
+		assert.Equal(t, 4, <-s.GetResultsChan())
+	})
+
+	t.Run("block with timeout", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithDataBufferSize(1),
+			WithOverflowPolicy(OverflowBlockWithTimeout), WithAddTimeout(10*time.Millisecond))
+		require.NoError(t, err)
+		defer s.Stop()
+		assert.NoError(t, s.AddData(1))
+		start := time.Now()
+		assert.ErrorIs(t, s.AddData(2), ErrAddTimeout)
+		assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+		assert.Equal(t, uint64(1), s.Dropped())
+	})
+
+	t.Run("result drop oldest", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithResultBufferSize(2), WithResultOverflowPolicy(OverflowDropOldest))
+		require.NoError(t, err)
+		defer s.Stop()
+		s.Start()
+		for i := 1; i <= 5; i++ {
+			assert.NoError(t, s.AddData(i))
+		}
+		// 不读取结果时数据处理不阻塞，结果通道只保留最新的结果
+		require.Eventually(t, func() bool { return s.DroppedResults() == 3 }, time.Second, time.Millisecond)
+		assert.Equal(t, 4, <-s.GetResultsChan())
+		assert.Equal(t, 5, <-s.GetResultsChan())
+		for i := 1; i <= 3; i++ {
+			streamErr := <-s.GetErrorChan()
+			assert.Equal(t, StageResult, streamErr.Stage)
+			assert.Equal(t, i, streamErr.Data)
+			assert.ErrorIs(t, streamErr, ErrBufferFull)
+		}
+	})
+
+	t.Run("result block with timeout", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithResultBufferSize(1),
+			WithResultOverflowPolicy(OverflowBlockWithTimeout), WithAddTimeout(10*time.Millisecond))
+		require.NoError(t, err)
+		defer s.Stop()
+		s.Start()
+		for i := 1; i <= 3; i++ {
+			assert.NoError(t, s.AddData(i))
+		}
+		require.Eventually(t, func() bool { return s.DroppedResults() == 2 }, time.Second, time.Millisecond)
+		assert.Equal(t, 1, <-s.GetResultsChan())
+		assert.Equal(t, uint64(0), s.Dropped())
+	})
+
+	t.Run("try add", func(t *testing.T) {
+		s, err := NewStream(model.Config{}, WithDataBufferSize(1))
+		require.NoError(t, err)
+		assert.NoError(t, s.TryAddData(1))
+		assert.ErrorIs(t, s.TryAddData(2), ErrBufferFull)
+		s.Stop()
+		assert.ErrorIs(t, s.TryAddData(3), ErrStreamClosed)
+		assert.ErrorIs(t, s.AddData(3), ErrStreamClosed)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewStream(model.Config{}, WithDataBufferSize(-1))
+		assert.Error(t, err)
+		_, err = NewStream(model.Config{}, WithOverflowPolicy(OverflowBlockWithTimeout))
+		assert.EqualError(t, err, "overflow policy block_with_timeout requires a positive add timeout")
+		_, err = NewStream(model.Config{}, WithDataBufferSize(0), WithOverflowPolicy(OverflowDropOldest))
+		assert.Error(t, err)
+		_, err = NewStream(model.Config{}, WithOverflowPolicy(OverflowPolicy(9)))
+		assert.EqualError(t, err, "unsupported overflow policy: OverflowPolicy(9)")
+		_, err = NewStream(model.Config{}, WithResultOverflowPolicy(OverflowBlockWithTimeout))
+		assert.EqualError(t, err, "result overflow policy block_with_timeout requires a positive add timeout")
+		_, err = NewStream(model.Config{}, WithResultOverflowPolicy(OverflowPolicy(9)))
+		assert.EqualError(t, err, "unsupported result overflow policy: OverflowPolicy(9)")
+	})
+}
+
+func TestStreamSlidingPanes(t *testing.T) {
+	base := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	var data []interface{}
+	for i, item := range []struct {
+		device string
+		offset time.Duration
+		value  int
+	}{
+		{"aa", 0, 10}, {"bb", 1500 * time.Millisecond, 4}, {"aa", 500 * time.Millisecond, 7},
+		{"aa", 2200 * time.Millisecond, 3}, {"bb", 3100 * time.Millisecond, 9}, {"aa", 2900 * time.Millisecond, 1},
+		{"bb", 4 * time.Second, 6}, {"aa", 5500 * time.Millisecond, 12},
+		// 迟到数据使已触发的窗口重新输出
+		{"bb", time.Second, 5},
+		{"aa", 6200 * time.Millisecond, 2}, {"bb", 8 * time.Second, 8}, {"aa", 7500 * time.Millisecond, 11},
+	} {
+		data = append(data, map[string]interface{}{"device": item.device, "value": item.value, "seq": i, "ts": base.Add(item.offset)})
+	}
+
+	run := func(t *testing.T, fields []aggregator.AggregationField, usePanes bool) []interface{} {
+		config := model.Config{
+			WindowConfig: model.WindowConfig{
+				Type:               "sliding",
+				Params:             map[string]interface{}{"size": 3 * time.Second, "slide": 2 * time.Second},
+				TsProp:             "ts",
+				TimeCharacteristic: model.EventTime,
+				MaxOutOfOrderness:  time.Second,
+				AllowedLateness:    2 * time.Second,
+			},
+			GroupFields:  []string{"device"},
+			Aggregations: fields,
+		}
+		strm, err := NewStream(config)
+		require.NoError(t, err)
+		sw := strm.Window.(*window.SlidingWindow)
+		if !usePanes {
+			require.False(t, sw.SetPaneAggregator(nil, nil))
+		}
+		// 按分片聚合时窗口每次触发只输出分组聚合结果，不输出原始数据
+		sw.SetCallback(func(rows []model.Row) {
+			if !usePanes {
+				return
+			}
+			assert.LessOrEqual(t, len(rows), 1)
+			for _, row := range rows {
+				assert.IsType(t, window.PaneResults{}, row.Data)
+			}
+		})
+		strm.Start()
+		for _, item := range data {
+			require.NoError(t, strm.AddData(item))
+		}
+		require.NoError(t, strm.Close(context.Background()))
+		var results []interface{}
+		for result := range strm.GetResultsChan() {
+			rows := result.([]map[string]interface{})
+			sort.Slice(rows, func(i, j int) bool { return rows[i]["device"].(string) < rows[j]["device"].(string) })
+			results = append(results, rows)
+		}
+		return results
+	}
+
+	tests := []struct {
+		name   string
+		fields []aggregator.AggregationField
+	}{
+		{"retractable", []aggregator.AggregationField{
+			{InputField: "value", AggregateType: aggregator.Sum, OutputAlias: "sum"},
+			{InputField: "value", AggregateType: aggregator.Count, OutputAlias: "count"},
+			{InputField: "value", AggregateType: aggregator.Avg, OutputAlias: "avg"},
+			{AggregateType: aggregator.WindowStart, OutputAlias: "start"},
+		}},
+		{"mergeable", []aggregator.AggregationField{
+			{InputField: "value", AggregateType: aggregator.Max, OutputAlias: "max"},
+			{InputField: "value", AggregateType: aggregator.PercentileDisc, OutputAlias: "p50", Params: []interface{}{0.5}},
+			{InputField: "seq", AggregateType: aggregator.FirstValue, OutputAlias: "first"},
+			{InputField: "seq", AggregateType: aggregator.LastValue, OutputAlias: "last"},
+			{InputField: "value", AggregateType: aggregator.CountDistinct, OutputAlias: "distinct"},
+			{AggregateType: aggregator.WindowEnd, OutputAlias: "end"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected := run(t, tt.fields, false)
+			require.NotEmpty(t, expected)
+			// 按分片聚合的结果与重新聚合窗口内所有数据的结果相同
+			assert.Equal(t, expected, run(t, tt.fields, true))
+		})
+	}
+
+	// 包含不能合并的聚合时不按分片聚合
+	config := model.Config{
+		WindowConfig: model.WindowConfig{
+			Type:   "sliding",
+			Params: map[string]interface{}{"size": 3 * time.Second, "slide": 2 * time.Second},
+		},
+		Aggregations: []aggregator.AggregationField{
+			{InputField: "value", AggregateType: aggregator.Sum, OutputAlias: "sum"},
+			{InputField: "value", AggregateType: "pane_unmergeable", OutputAlias: "custom"},
+		},
+	}
+	aggregator.Register("pane_unmergeable", func() aggregator.AggregatorFunction { return &unmergeable{} })
+	strm, err := NewStream(config)
+	require.NoError(t, err)
+	var batches [][]model.Row
+	strm.Window.SetCallback(func(rows []model.Row) {
+		batches = append(batches, rows)
+	})
+	strm.Start()
+	require.NoError(t, strm.AddData(map[string]interface{}{"value": 5}))
+	require.NoError(t, strm.Close(context.Background()))
+	require.NotEmpty(t, batches)
+	assert.Equal(t, map[string]interface{}{"value": 5}, batches[0][0].Data)
+	result := <-strm.GetResultsChan()
+	assert.Equal(t, []map[string]interface{}{{"sum": float64(5), "custom": float64(5)}}, result)
+}
+
+// unmergeable 不能合并的聚合器
+type unmergeable struct {
+	sum float64
+}
+
+func (u *unmergeable) New() aggregator.AggregatorFunction {
+	return &unmergeable{}
+}
+
+func (u *unmergeable) Add(v interface{}) {
+	u.sum += aggregator.ConvertToFloat64(v, 0)
+}
+
+func (u *unmergeable) Result() interface{} {
+	return u.sum
+}
@@ -17,59 +17,152 @@
 package streamsql
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/rulego/streamsql/model"
 	"github.com/rulego/streamsql/rsql"
 	"github.com/rulego/streamsql/stream"
+	"github.com/rulego/streamsql/utils/timex"
 )
 
 // Streamsql 流式SQL，用于对流式数据进行SQL查询和计算
 type Streamsql struct {
 	stream *stream.Stream
+	// source FROM 子句中的流名称
+	source string
+	// clock 窗口使用的时钟，为空时使用系统时钟
+	clock timex.Clock
+	// errorHandler 处理流处理过程中的错误
+	errorHandler func(*stream.StreamError)
+	// streamOpts 创建流时使用的配置项，设置通道缓冲区大小和数据通道已满时的处理策略
+	streamOpts []stream.Option
+	// schemas 通过 CREATE STREAM 声明的流结构，按流名称索引
+	schemas map[string]*model.Schema
+	// schema 查询的 FROM 子句对应的流结构，不为空时添加的数据按其校验和转换
+	schema *model.Schema
 }
 
 // New returns a new Streamsql job runner, modified by the given options.
 func New(opts ...Option) *Streamsql {
-	return &Streamsql{}
+	s := &Streamsql{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Execute 执行SQ
-// 如果执行成功，则返回nil，否则返回错误信息
+// 如果执行成功，则返回nil，否则返回错误信息。
+// CREATE STREAM 语句声明流结构，之后执行的 FROM 该流的查询按流结构检查字段类型，添加的数据按流结构校验和转换
 func (s *Streamsql) Execute(sql string) error {
 	var err error
 	//根据sql初始stream，并启动stream
-	stmt, err := rsql.NewParser(sql).Parse()
+	parsed, err := rsql.NewParser(sql).ParseStatement()
 	if err != nil {
 		return err
 	}
-	config, condition, err := stmt.ToStreamConfig()
-	if err != nil {
-		return err
+	if create, ok := parsed.(*rsql.CreateStreamStatement); ok {
+		return s.createStream(create)
+	}
+	stmt := parsed.(*rsql.SelectStatement)
+	if schema, ok := s.schemas[stmt.Source]; ok {
+		if err := stmt.BindSchema(schema); err != nil {
+			return err
+		}
+		s.schema = schema
 	}
-	s.stream, err = stream.NewStream(*config)
+	config, _, err := stmt.ToStreamConfig()
 	if err != nil {
 		return err
 	}
-	err = s.stream.RegisterFilter(condition)
+	s.source = stmt.Source
+	config.WindowConfig.Clock = s.clock
+	s.stream, err = stream.NewStream(*config, s.streamOpts...)
 	if err != nil {
 		return err
 	}
+	if s.errorHandler != nil {
+		s.stream.AddErrorHandler(s.errorHandler)
+	}
 	//开始接收和处理数据
 	s.stream.Start()
 	return nil
 
 }
 
-// Stop 停止接收和处理数据
+// createStream 根据 CREATE STREAM 语句声明流结构，同名的流只能声明一次
+func (s *Streamsql) createStream(create *rsql.CreateStreamStatement) error {
+	schema, err := create.ToSchema()
+	if err != nil {
+		return err
+	}
+	if _, ok := s.schemas[schema.Name]; ok {
+		return fmt.Errorf("stream %s already exists", schema.Name)
+	}
+	if s.schemas == nil {
+		s.schemas = make(map[string]*model.Schema)
+	}
+	s.schemas[schema.Name] = schema
+	return nil
+}
+
+// Stop 立即停止接收和处理数据，丢弃尚未处理的数据和未触发的窗口，关闭结果通道
 func (s *Streamsql) Stop() {
+	if s.stream != nil {
+		s.stream.Stop()
+	}
 }
 
-// GetResult 获取结果
+// Close 停止接收数据，处理完已接收的数据并输出所有未触发窗口的结果后关闭结果通道。
+// ctx 结束时放弃剩余的处理并返回 ctx 的错误
+func (s *Streamsql) Close(ctx context.Context) error {
+	if s.stream == nil {
+		return nil
+	}
+	return s.stream.Close(ctx)
+}
+
+// GetResult 获取结果，通过 Subscribe 或 Sink 函数接收结果时通道已满的结果不再写入通道
 func (s *Streamsql) GetResult() <-chan interface{} {
 	return s.stream.GetResultsChan()
 }
 
-// AddData 添加流数据
-func (s *Streamsql) AddData(data interface{}) {
-	s.stream.AddData(data)
+// ErrorChan 获取错误通道，数据过滤、窗口、聚合和输出过程中的错误写入该通道，通道已满时新的错误被丢弃
+func (s *Streamsql) ErrorChan() <-chan *stream.StreamError {
+	return s.stream.GetErrorChan()
+}
+
+// AddData 添加流数据，数据通道已满时按 WithOverflowPolicy 设置的策略处理，数据被拒绝或流已停止时返回错误。
+// 查询的流声明了结构时，数据先按流结构校验和转换，不符合流结构的数据返回错误
+func (s *Streamsql) AddData(data interface{}) error {
+	row, err := s.coerce(data)
+	if err != nil {
+		return err
+	}
+	return s.stream.AddData(row)
+}
+
+// TryAddData 不阻塞地添加流数据，数据通道已满时返回 stream.ErrBufferFull
+func (s *Streamsql) TryAddData(data interface{}) error {
+	row, err := s.coerce(data)
+	if err != nil {
+		return err
+	}
+	return s.stream.TryAddData(row)
+}
+
+// coerce 按查询的流结构校验和转换数据，没有声明流结构时原样返回
+func (s *Streamsql) coerce(data interface{}) (interface{}, error) {
+	if s.schema == nil {
+		return data, nil
+	}
+	return s.schema.Coerce(data)
+}
+
+// Source 返回 FROM 子句中的流名称
+func (s *Streamsql) Source() string {
+	return s.source
 }
 
 func (s *Streamsql) Stream() *stream.Stream {
@@ -9,6 +9,10 @@ import (
 
 	"math/rand"
 
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/functions"
+	"github.com/rulego/streamsql/stream"
+	"github.com/rulego/streamsql/utils/timex"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -201,3 +205,631 @@ func TestStreamsqlWithoutGroupBy(t *testing.T) {
 		//assert.True(t, found, fmt.Sprintf("Expected result for device %v not found", expectedResult["device"]))
 	}
 }
+
+func TestStreamsqlSessionWindow(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device,max(temperature) as max_temp,window_start() as start,window_end() as end FROM stream group by device,SessionWindow('500ms') with (TIMESTAMP='Ts')"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	testData := []interface{}{
+		map[string]interface{}{"device": "aa", "temperature": 25.0, "Ts": baseTime},
+		map[string]interface{}{"device": "aa", "temperature": 30.0, "Ts": baseTime.Add(200 * time.Millisecond)},
+		map[string]interface{}{"device": "bb", "temperature": 22.0, "Ts": baseTime},
+	}
+	// 捕获结果
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+	for _, data := range testData {
+		strm.AddData(data)
+	}
+
+	actual := make(map[interface{}]map[string]interface{})
+	timeout := time.After(3 * time.Second)
+	for len(actual) < 2 {
+		select {
+		case result := <-resultChan:
+			for _, resultMap := range result.([]map[string]interface{}) {
+				actual[resultMap["device"]] = resultMap
+			}
+		case <-timeout:
+			t.Fatal("Timeout waiting for results")
+		}
+	}
+
+	assert.InEpsilon(t, 30.0, actual["aa"]["max_temp"].(float64), 0.0001)
+	assert.Equal(t, baseTime.UnixNano(), actual["aa"]["start"].(int64))
+	assert.Equal(t, baseTime.Add(700*time.Millisecond).UnixNano(), actual["aa"]["end"].(int64))
+	assert.InEpsilon(t, 22.0, actual["bb"]["max_temp"].(float64), 0.0001)
+	assert.Equal(t, baseTime.Add(500*time.Millisecond).UnixNano(), actual["bb"]["end"].(int64))
+}
+
+func TestStreamsqlEventTime(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device,avg(temperature) as avg_temp,window_start() as start FROM stream group by device,TumblingWindow('10s') with (TIMESTAMP='Ts',EVENTTIME=true,MAXOUTOFORDERNESS='2s')"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+
+	// 回放历史数据，窗口只由数据中的事件时间触发
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	for i := 0; i < 30; i++ {
+		strm.AddData(map[string]interface{}{
+			"device":      "aa",
+			"temperature": float64(i),
+			"Ts":          baseTime.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	// 水位线为 27s，[0s,10s) 与 [10s,20s) 两个窗口触发
+	for i := 0; i < 2; i++ {
+		select {
+		case result := <-resultChan:
+			resultSlice := result.([]map[string]interface{})
+			require.Len(t, resultSlice, 1)
+			assert.InEpsilon(t, float64(i*10)+4.5, resultSlice[0]["avg_temp"].(float64), 0.0001)
+			assert.Equal(t, baseTime.Add(time.Duration(i*10)*time.Second).UnixNano(), resultSlice[0]["start"].(int64))
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for results")
+		}
+	}
+	select {
+	case result := <-resultChan:
+		t.Fatalf("unexpected result %v", result)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestStreamsqlAggregateExpression(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device, max(temperature) - min(temperature) as spread, avg(temperature) + max(humidity) as score, " +
+		"case when avg(temperature) > 20 then 'hot' else 'cold' end as level " +
+		"FROM stream where humidity IN (50, 60) and device <> 'cc' group by device,TumblingWindow('10s') with (TIMESTAMP='Ts',EVENTTIME=true)"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	testData := []map[string]interface{}{
+		{"device": "aa", "temperature": 25.0, "humidity": 50, "Ts": baseTime},
+		{"device": "aa", "temperature": 15.0, "humidity": 60, "Ts": baseTime.Add(time.Second)},
+		{"device": "aa", "temperature": 99.0, "humidity": 70, "Ts": baseTime.Add(2 * time.Second)},
+		{"device": "cc", "temperature": 99.0, "humidity": 50, "Ts": baseTime.Add(3 * time.Second)},
+		// 推进水位线，触发第一个窗口
+		{"device": "aa", "temperature": 0.0, "humidity": 50, "Ts": baseTime.Add(10 * time.Second)},
+	}
+	for _, data := range testData {
+		strm.AddData(data)
+	}
+
+	select {
+	case result := <-resultChan:
+		resultSlice := result.([]map[string]interface{})
+		require.Len(t, resultSlice, 1)
+		assert.Equal(t, map[string]interface{}{
+			"device": "aa",
+			"spread": 10.0,
+			"score":  80.0,
+			"level":  "cold",
+		}, resultSlice[0])
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlAggregateArgumentExpression(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device, avg(temperature/10) as avg_temp, sum(price*qty) as amount, max(abs(delta)) as max_delta " +
+		"FROM stream group by device,TumblingWindow('10s') with (TIMESTAMP='Ts',EVENTTIME=true)"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	testData := []map[string]interface{}{
+		{"device": "aa", "temperature": 250.0, "price": 2.5, "qty": 4, "delta": -7.0, "Ts": baseTime},
+		{"device": "aa", "temperature": 150.0, "price": 1.0, "qty": 3, "delta": 5.0, "Ts": baseTime.Add(time.Second)},
+		// 推进水位线，触发第一个窗口
+		{"device": "bb", "temperature": 0.0, "price": 0.0, "qty": 0, "delta": 0.0, "Ts": baseTime.Add(10 * time.Second)},
+	}
+	for _, data := range testData {
+		strm.AddData(data)
+	}
+
+	select {
+	case result := <-resultChan:
+		resultSlice := result.([]map[string]interface{})
+		require.Len(t, resultSlice, 1)
+		assert.InEpsilon(t, 20.0, resultSlice[0]["avg_temp"].(float64), 0.0001)
+		assert.InEpsilon(t, 13.0, resultSlice[0]["amount"].(float64), 0.0001)
+		assert.InEpsilon(t, 7.0, resultSlice[0]["max_delta"].(float64), 0.0001)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlHaving(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device, avg(temperature) as avg_temp FROM stream group by device,TumblingWindow('10s') " +
+		"having avg_temp > 20 and max(humidity) < 80 with (TIMESTAMP='Ts',EVENTTIME=true)"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	testData := []map[string]interface{}{
+		{"device": "aa", "temperature": 25.0, "humidity": 60.0, "Ts": baseTime},
+		// bb 的平均温度不满足条件
+		{"device": "bb", "temperature": 15.0, "humidity": 60.0, "Ts": baseTime},
+		// cc 的最大湿度不满足条件
+		{"device": "cc", "temperature": 30.0, "humidity": 90.0, "Ts": baseTime},
+		// 推进水位线，触发第一个窗口
+		{"device": "aa", "temperature": 0.0, "humidity": 0.0, "Ts": baseTime.Add(10 * time.Second)},
+	}
+	for _, data := range testData {
+		strm.AddData(data)
+	}
+
+	select {
+	case result := <-resultChan:
+		assert.Equal(t, []map[string]interface{}{
+			{"device": "aa", "avg_temp": 25.0},
+		}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlOrderByLimit(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT device, avg(temperature) as avg_temp FROM stream group by device,TumblingWindow('10s') " +
+		"order by avg_temp desc, device limit 3 with (TIMESTAMP='Ts',EVENTTIME=true)"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+	strm := streamsql.stream
+	resultChan := make(chan interface{}, 10)
+	strm.AddSink(func(result interface{}) {
+		resultChan <- result
+	})
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	temperatures := map[string]float64{"aa": 21, "bb": 35, "cc": 28, "dd": 35, "ee": 10}
+	for device, temperature := range temperatures {
+		strm.AddData(map[string]interface{}{"device": device, "temperature": temperature, "Ts": baseTime})
+	}
+	// 推进水位线，触发第一个窗口
+	strm.AddData(map[string]interface{}{"device": "aa", "temperature": 0.0, "Ts": baseTime.Add(10 * time.Second)})
+
+	select {
+	case result := <-resultChan:
+		assert.Equal(t, []map[string]interface{}{
+			{"device": "bb", "avg_temp": 35.0},
+			{"device": "dd", "avg_temp": 35.0},
+			{"device": "cc", "avg_temp": 28.0},
+		}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlNonWindow(t *testing.T) {
+	streamsql := New()
+	var rsql = "SELECT deviceId, temperature*1.8+32 AS f FROM stream WHERE temperature > 40"
+	err := streamsql.Execute(rsql)
+	require.Nil(t, err)
+
+	testData := []map[string]interface{}{
+		{"deviceId": "aa", "temperature": 45.0},
+		// 不满足过滤条件
+		{"deviceId": "bb", "temperature": 20.0},
+		{"deviceId": "cc", "temperature": 50},
+	}
+	for _, data := range testData {
+		streamsql.AddData(data)
+	}
+
+	// 无窗口查询每条数据单独输出
+	for _, expected := range []map[string]interface{}{
+		{"deviceId": "aa", "f": 113.0},
+		{"deviceId": "cc", "f": 122.0},
+	} {
+		select {
+		case result := <-streamsql.GetResult():
+			require.IsType(t, map[string]interface{}{}, result)
+			row := result.(map[string]interface{})
+			assert.Equal(t, expected["deviceId"], row["deviceId"])
+			assert.InDelta(t, expected["f"], row["f"], 0.0001)
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for results")
+		}
+	}
+}
+
+func TestStreamsqlNonWindowCallTextField(t *testing.T) {
+	streamsql := New()
+	err := streamsql.Execute("SELECT abs(x) AS a FROM stream WHERE abs(x) > 1")
+	require.Nil(t, err)
+
+	// 数据中与调用文本同名的字段不会替代函数调用的结果
+	streamsql.AddData(map[string]interface{}{"x": -0.5, "abs(x)": 99.0})
+	streamsql.AddData(map[string]interface{}{"x": -2.0, "abs(x)": 0.0})
+	select {
+	case result := <-streamsql.GetResult():
+		assert.Equal(t, map[string]interface{}{"a": 2.0}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+	select {
+	case result := <-streamsql.GetResult():
+		t.Fatalf("unexpected result %v", result)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamsqlClose(t *testing.T) {
+	streamsql := New()
+	err := streamsql.Execute("SELECT device, max(temperature) as max_temp FROM stream group by device, TumblingWindow('1m')")
+	require.Nil(t, err)
+	streamsql.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0})
+	streamsql.AddData(map[string]interface{}{"device": "aa", "temperature": 30.0})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+	var results []interface{}
+	for result := range streamsql.GetResult() {
+		results = append(results, result)
+	}
+	assert.Equal(t, []interface{}{
+		[]map[string]interface{}{{"device": "aa", "max_temp": 30.0}},
+	}, results)
+}
+
+func TestStreamsqlWithClock(t *testing.T) {
+	clock := timex.NewFakeClock(time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC))
+	streamsql := New(WithClock(clock))
+	err := streamsql.Execute("SELECT device, max(temperature) as max_temp FROM stream group by device, TumblingWindow('10s')")
+	require.Nil(t, err)
+	defer streamsql.Stop()
+
+	// 没有时间戳字段的数据使用时钟的当前时间
+	streamsql.AddData(map[string]interface{}{"device": "aa", "temperature": 25.0})
+	// 窗口收到第一条数据后创建定时器，推进时间触发窗口，不需要真实等待 10 秒
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Second)
+	select {
+	case result := <-streamsql.GetResult():
+		assert.Equal(t, []map[string]interface{}{{"device": "aa", "max_temp": 25.0}}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlErrorHandler(t *testing.T) {
+	errs := make(chan *stream.StreamError, 10)
+	streamsql := New(WithErrorHandler(func(err *stream.StreamError) {
+		errs <- err
+	}))
+	err := streamsql.Execute("SELECT deviceId, temperature/humidity AS ratio FROM stream WHERE temperature > 40")
+	require.Nil(t, err)
+	defer streamsql.Stop()
+
+	streamsql.AddData(map[string]interface{}{"deviceId": "aa", "temperature": 45.0, "humidity": 0})
+	select {
+	case err := <-errs:
+		assert.Equal(t, stream.StageProject, err.Stage)
+		assert.Equal(t, "ratio", err.Field)
+		assert.EqualError(t, err.Err, "division by zero")
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for errors")
+	}
+	// 出错的字段输出为 nil
+	select {
+	case result := <-streamsql.GetResult():
+		assert.Equal(t, map[string]interface{}{"deviceId": "aa", "ratio": nil}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+}
+
+func TestStreamsqlOverflowPolicy(t *testing.T) {
+	streamsql := New(WithBufferSize(1, 1), WithOverflowPolicy(stream.OverflowDropNewest))
+	err := streamsql.Execute("SELECT deviceId FROM stream")
+	require.Nil(t, err)
+	defer streamsql.Stop()
+
+	// 没有读取结果时，结果通道和数据通道很快被填满，之后的数据被拒绝而不是阻塞
+	var rejected int
+	for i := 0; i < 10; i++ {
+		if err := streamsql.AddData(map[string]interface{}{"deviceId": "aa"}); err != nil {
+			assert.ErrorIs(t, err, stream.ErrBufferFull)
+			rejected++
+		}
+	}
+	assert.Greater(t, rejected, 0)
+	assert.Equal(t, uint64(rejected), streamsql.Stream().Dropped())
+}
+
+func TestStreamsqlResultOverflowPolicy(t *testing.T) {
+	var mu sync.Mutex
+	var errs []*stream.StreamError
+	streamsql := New(WithBufferSize(1, 2), WithResultOverflowPolicy(stream.OverflowDropNewest),
+		WithErrorHandler(func(err *stream.StreamError) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}))
+	err := streamsql.Execute("SELECT count(*) AS cnt FROM stream GROUP BY TumblingWindow('1s') WITH (TIMESTAMP='ts', EVENTTIME=true)")
+	require.Nil(t, err)
+
+	// 从不读取结果时窗口仍然依次触发，结果通道已满后的结果被丢弃并报告，AddData 不会阻塞
+	base := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, streamsql.AddData(map[string]interface{}{"ts": base.Add(time.Duration(i) * time.Second)}))
+		}
+	}()
+	select {
+	case <-added:
+	case <-time.After(3 * time.Second):
+		t.Fatal("AddData blocked by unread results")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+
+	// 20 个窗口中前 2 个结果留在结果通道中，其余的结果被丢弃
+	assert.Equal(t, uint64(18), streamsql.Stream().DroppedResults())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, errs, 18)
+	for _, streamErr := range errs {
+		assert.Equal(t, stream.StageResult, streamErr.Stage)
+		assert.ErrorIs(t, streamErr, stream.ErrBufferFull)
+		assert.Equal(t, []map[string]interface{}{{"cnt": float64(1)}}, streamErr.Data)
+	}
+	var results []interface{}
+	for result := range streamsql.GetResult() {
+		results = append(results, result)
+	}
+	assert.Len(t, results, 2)
+}
+
+func TestStreamsqlCreateStream(t *testing.T) {
+	streamsql := New()
+	require.NoError(t, streamsql.Execute("CREATE STREAM sensors (deviceId STRING, temperature FLOAT, ts TIMESTAMP) WITH (TIMESTAMP='ts', FORMAT='json')"))
+	assert.EqualError(t, streamsql.Execute("CREATE STREAM sensors (a INT)"), "stream sensors already exists")
+	// 查询按流结构检查字段类型
+	assert.EqualError(t, streamsql.Execute("SELECT avg(deviceId) FROM sensors TumblingWindow('1m')"),
+		"avg requires a numeric argument but deviceId is STRING")
+
+	require.NoError(t, streamsql.Execute("SELECT deviceId, temperature, ts FROM sensors WHERE temperature > 20"))
+	defer streamsql.Stop()
+
+	// 数据按流结构转换类型，字符串形式的数值和毫秒时间戳都可以接受
+	ts := time.UnixMilli(1744015560000)
+	require.NoError(t, streamsql.AddData(map[string]interface{}{"deviceId": 1001, "temperature": "25.5", "ts": ts.UnixMilli()}))
+	require.NoError(t, streamsql.AddData([]byte(`{"deviceId":"aa","temperature":30,"ts":"2025-04-07T08:46:00Z"}`)))
+	assert.ErrorContains(t, streamsql.AddData(map[string]interface{}{"deviceId": "bb", "temperature": "hot"}),
+		"field temperature of stream sensors expects FLOAT")
+
+	for _, expected := range []map[string]interface{}{
+		{"deviceId": "1001", "temperature": 25.5, "ts": ts},
+		{"deviceId": "aa", "temperature": 30.0, "ts": time.Date(2025, 4, 7, 8, 46, 0, 0, time.UTC)},
+	} {
+		select {
+		case result := <-streamsql.GetResult():
+			assert.Equal(t, expected, result)
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for results")
+		}
+	}
+}
+
+func TestStreamsqlGroupByExpression(t *testing.T) {
+	streamsql := New()
+	err := streamsql.Execute("SELECT payload.meta.site, floor(temperature/10) AS bucket, count(temperature) AS cnt FROM stream GROUP BY payload.meta.site, floor(temperature/10), TumblingWindow('1m')")
+	require.NoError(t, err)
+
+	// 嵌套的数据不需要展开即可按嵌套字段分组
+	for _, row := range []map[string]interface{}{
+		{"deviceId": "aa", "temperature": 21.0, "payload": map[string]interface{}{"meta": map[string]interface{}{"site": "s1"}}},
+		{"deviceId": "bb", "temperature": 28.0, "payload": map[string]interface{}{"meta": map[string]interface{}{"site": "s1"}}},
+		{"deviceId": "cc", "temperature": 35.0, "payload": map[string]interface{}{"meta": map[string]interface{}{"site": "s1"}}},
+	} {
+		require.NoError(t, streamsql.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+
+	result := <-streamsql.GetResult()
+	assert.ElementsMatch(t, []map[string]interface{}{
+		{"payload.meta.site": "s1", "bucket": 2.0, "cnt": 2.0},
+		{"payload.meta.site": "s1", "bucket": 3.0, "cnt": 1.0},
+	}, result)
+}
+
+func TestStreamsqlProjectGroupResult(t *testing.T) {
+	streamsql := New()
+	err := streamsql.Execute("SELECT deviceId, avg(temperature)*1.8+32 AS f, deviceId + '-x' AS k, 'v1' AS version, 1 AS one, status " +
+		"FROM stream GROUP BY deviceId, TumblingWindow('1m')")
+	require.NoError(t, err)
+
+	require.NoError(t, streamsql.AddData(map[string]interface{}{"deviceId": "aa", "temperature": 20.0, "status": "starting"}))
+	require.NoError(t, streamsql.AddData(map[string]interface{}{"deviceId": "aa", "temperature": 30.0, "status": "running"}))
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, streamsql.Close(ctx))
+
+	// 每个查询字段都按分组结果求值：聚合结果的运算、分组字段上的运算、常量列和取最后一条数据的非聚合字段
+	result := <-streamsql.GetResult()
+	assert.Equal(t, []map[string]interface{}{
+		{"deviceId": "aa", "f": 77.0, "k": "aa-x", "version": "v1", "one": int64(1), "status": "running"},
+	}, result)
+}
+
+func TestStreamsqlFunctions(t *testing.T) {
+	streamsql := New()
+	err := streamsql.Execute("SELECT upper(deviceId) AS dev, format_time(ts, 'yyyy-MM-dd') AS day, if(temperature > 30, 'hot', 'normal') AS level, " +
+		"coalesce(site, 'unknown') AS site FROM stream WHERE regexp_match(deviceId, '^dev-')")
+	require.NoError(t, err)
+	defer streamsql.Stop()
+	assert.EqualError(t, New().Execute("SELECT lower(deviceId, 1) FROM stream"), "lower expects 1 arguments but got 2")
+
+	ts := time.Date(2025, 4, 7, 16, 46, 0, 0, time.Local)
+	require.NoError(t, streamsql.AddData(map[string]interface{}{"deviceId": "gw-1", "temperature": 35.0, "ts": ts}))
+	require.NoError(t, streamsql.AddData(map[string]interface{}{"deviceId": "dev-1", "temperature": 35.0, "ts": ts}))
+	select {
+	case result := <-streamsql.GetResult():
+		assert.Equal(t, map[string]interface{}{"dev": "DEV-1", "day": "2025-04-07", "level": "hot", "site": "unknown"}, result)
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for results")
+	}
+
+	// GROUP BY 和 HAVING 中的函数
+	grouped := New()
+	err = grouped.Execute("SELECT lower(deviceId) AS dev, round(avg(temperature), 1) AS avg_temp FROM stream " +
+		"GROUP BY lower(deviceId), TumblingWindow('1m') HAVING abs(avg_temp) > 10")
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"deviceId": "AA", "temperature": 20.0},
+		{"deviceId": "aa", "temperature": 21.15},
+		{"deviceId": "bb", "temperature": 5.0},
+	} {
+		require.NoError(t, grouped.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, grouped.Close(ctx))
+	assert.Equal(t, []map[string]interface{}{{"dev": "aa", "avg_temp": 20.6}}, <-grouped.GetResult())
+}
+
+// weightedAvg 按权重计算平均值的自定义聚合器
+type weightedAvg struct {
+	sum, weight float64
+}
+
+func (w *weightedAvg) New() aggregator.AggregatorFunction { return &weightedAvg{} }
+func (w *weightedAvg) Add(v interface{})                  { w.AddArgs([]interface{}{v, 1.0}) }
+func (w *weightedAvg) AddArgs(args []interface{}) {
+	w.sum += args[0].(float64) * args[1].(float64)
+	w.weight += args[1].(float64)
+}
+func (w *weightedAvg) Result() interface{} { return w.sum / w.weight }
+
+func TestStreamsqlCustomAggregate(t *testing.T) {
+	number := functions.TypeNumber
+	aggregator.RegisterWithSignature("weighted_avg", aggregator.Signature{Args: []functions.ArgType{number, number}, Result: number},
+		func([]interface{}) (aggregator.AggregatorFunction, error) { return &weightedAvg{}, nil })
+
+	assert.EqualError(t, New().Execute("SELECT weighted_avg(temperature) FROM stream TumblingWindow('1m')"),
+		"weighted_avg expects 2 arguments but got 1")
+
+	ssql := New()
+	err := ssql.Execute("SELECT deviceId, weighted_avg(temperature, weight) AS w FROM stream GROUP BY deviceId, TumblingWindow('1m')")
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"deviceId": "aa", "temperature": 20.0, "weight": 1},
+		{"deviceId": "aa", "temperature": 30.0, "weight": 3},
+		{"deviceId": "aa", "temperature": 99.0},
+	} {
+		require.NoError(t, ssql.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, ssql.Close(ctx))
+	assert.Equal(t, []map[string]interface{}{{"deviceId": "aa", "w": 27.5}}, <-ssql.GetResult())
+}
+
+func TestStreamsqlCountDistinct(t *testing.T) {
+	ssql := New()
+	err := ssql.Execute("SELECT site, count(*) AS reports, count(battery) AS with_battery, count(DISTINCT deviceId) AS devices, " +
+		"approx_count_distinct(deviceId) AS approx FROM stream GROUP BY site, TumblingWindow('1m')")
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"site": "s1", "deviceId": "a", "battery": 90},
+		{"site": "s1", "deviceId": "b"},
+		{"site": "s1", "deviceId": "a", "battery": nil},
+	} {
+		require.NoError(t, ssql.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, ssql.Close(ctx))
+	assert.Equal(t, []map[string]interface{}{
+		{"site": "s1", "reports": 3.0, "with_battery": 1.0, "devices": 2.0, "approx": 2.0},
+	}, <-ssql.GetResult())
+}
+
+func TestStreamsqlValueAggregates(t *testing.T) {
+	ssql := New()
+	err := ssql.Execute("SELECT deviceId, last_value(status) AS status, first_value(status) AS initial, collect_set(status) AS states, " +
+		"max(ts) AS latest, min(temperature) AS min_temp FROM stream GROUP BY deviceId, TumblingWindow('1m') " +
+		"WITH (TIMESTAMP='ts', EVENTTIME=true, MAXOUTOFORDERNESS='5s')")
+	require.NoError(t, err)
+	base := time.Now().Truncate(time.Minute)
+	for _, row := range []map[string]interface{}{
+		{"deviceId": "aa", "status": "running", "temperature": -2, "ts": base.Add(20 * time.Second)},
+		// 乱序到达的数据按事件时间排序
+		{"deviceId": "aa", "status": "starting", "temperature": 5, "ts": base.Add(10 * time.Second)},
+		{"deviceId": "aa", "status": "idle", "temperature": 1, "ts": base.Add(30 * time.Second)},
+	} {
+		require.NoError(t, ssql.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, ssql.Close(ctx))
+	assert.Equal(t, []map[string]interface{}{{
+		"deviceId": "aa",
+		"status":   "idle",
+		"initial":  "starting",
+		"states":   []interface{}{"running", "starting", "idle"},
+		"latest":   base.Add(30 * time.Second),
+		"min_temp": -2.0,
+	}}, <-ssql.GetResult())
+}
+
+func TestStreamsqlTopK(t *testing.T) {
+	ssql := New()
+	err := ssql.Execute("SELECT gateway, topk(deviceId, 2) AS noisy, topk_by(deviceId, bytes, 1) AS heavy FROM stream " +
+		"GROUP BY gateway, TumblingWindow('5m')")
+	require.NoError(t, err)
+	for _, row := range []map[string]interface{}{
+		{"gateway": "g1", "deviceId": "a", "bytes": 10},
+		{"gateway": "g1", "deviceId": "b", "bytes": 300},
+		{"gateway": "g1", "deviceId": "a", "bytes": 10},
+		{"gateway": "g1", "deviceId": "c"},
+	} {
+		require.NoError(t, ssql.AddData(row))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	require.NoError(t, ssql.Close(ctx))
+	assert.Equal(t, []map[string]interface{}{{
+		"gateway": "g1",
+		"noisy":   []map[string]interface{}{{"value": "a", "count": 2.0}, {"value": "b", "count": 1.0}},
+		"heavy":   []map[string]interface{}{{"value": "b", "weight": 300.0}},
+	}}, <-ssql.GetResult())
+}
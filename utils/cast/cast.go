@@ -155,6 +155,54 @@ func ToDurationE(value interface{}) (time.Duration, error) {
 	}
 }
 
+// timeLayouts are the layouts tried in order when casting a string to time.Time.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// ToTimeE converts an interface{} to time.Time with error handling.
+// Integers, floats and numeric strings are treated as Unix timestamps in milliseconds,
+// other strings are parsed as RFC3339 or "2006-01-02 15:04:05" in local time.
+// Returns the zero time and an error if conversion fails.
+func ToTimeE(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v != nil {
+			return *v, nil
+		}
+	case json.Number:
+		ms, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unable to cast %v of type %T to time.Time", value, value)
+		}
+		return time.UnixMilli(ms), nil
+	case float64:
+		return time.UnixMilli(int64(v)), nil
+	case float32:
+		return time.UnixMilli(int64(v)), nil
+	case string:
+		if ms, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("unable to parse %q as time.Time", v)
+	default:
+		if ms, err := ToInt64E(value); err == nil {
+			return time.UnixMilli(ms), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to cast %v of type %T to time.Time", value, value)
+}
+
 // ToBool converts an interface{} to bool.
 // It returns false if conversion fails.
 func ToBool(value interface{}) bool {
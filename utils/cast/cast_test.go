@@ -18,6 +18,7 @@ package cast
 
 import (
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -126,6 +127,39 @@ func TestToDurationE(t *testing.T) {
 	}
 }
 
+func TestToTimeE(t *testing.T) {
+	ts := time.Date(2025, 4, 7, 16, 46, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		input  interface{}
+		expect time.Time
+		hasErr bool
+	}{
+		{"time", ts, ts, false},
+		{"pointer", &ts, ts, false},
+		{"int64 millis", ts.UnixMilli(), ts, false},
+		{"int millis", int(ts.UnixMilli()), ts, false},
+		{"float64 millis", float64(ts.UnixMilli()), ts, false},
+		{"numeric string", strconv.FormatInt(ts.UnixMilli(), 10), ts, false},
+		{"rfc3339", ts.Format(time.RFC3339), ts, false},
+		{"datetime", "2025-04-07 16:46:00", ts, false},
+		{"invalid string", "abc", time.Time{}, true},
+		{"invalid type", []int{1, 2, 3}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ToTimeE(tt.input)
+			if (err != nil) != tt.hasErr {
+				t.Errorf("ToTimeE() error = %v, wantErr %v", err, tt.hasErr)
+			}
+			if !tt.hasErr && !v.Equal(tt.expect) {
+				t.Errorf("ToTimeE() = %v, want %v", v, tt.expect)
+			}
+		})
+	}
+}
+
 func TestToBool(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -0,0 +1,180 @@
+package timex
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock 时钟，窗口通过时钟获取当前时间和创建定时器。
+// 测试时可以替换为手动推进的 FakeClock，使窗口的定时触发变得确定
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+	// NewTicker 创建一个周期为 d 的定时器
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker 定时器
+type Ticker interface {
+	// C 返回定时器的通道，每个周期发送一次当前时间
+	C() <-chan time.Time
+	// Stop 停止定时器
+	Stop()
+}
+
+// SystemClock 系统时钟
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{ticker: time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// FakeClock 手动推进的时钟，时间只在调用 Advance 或 Set 时前进。
+// 时间前进越过定时器的触发时间时，按触发时间的先后依次向定时器发送时间，
+// 每次发送都会等待定时器的接收方取走，因此推进时间后定时触发的次数是确定的
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFakeClock 创建一个以 now 为当前时间的 FakeClock
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// BlockUntil 等待直到至少有 n 个未停止的定时器。
+// 窗口在收到第一条数据时创建定时器，可以用它确认数据已经到达窗口后再推进时间
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.tickers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Now 返回当前时间
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTicker 创建一个周期为 d 的定时器，第一次触发时间为当前时间加 d
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		clock:  c,
+		period: d,
+		next:   c.now.Add(d),
+		c:      make(chan time.Time),
+		done:   make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, t)
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance 将时间推进 d，并触发期间到期的定时器
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set 将时间设置为 t，并按触发时间的先后依次触发期间到期的定时器，t 早于当前时间时不做任何处理
+func (c *FakeClock) Set(t time.Time) {
+	for {
+		c.mu.Lock()
+		if t.Before(c.now) {
+			c.mu.Unlock()
+			return
+		}
+		due := c.due(t)
+		if due == nil {
+			c.now = t
+			c.mu.Unlock()
+			return
+		}
+		c.now = due.next
+		due.next = due.next.Add(due.period)
+		now := c.now
+		c.mu.Unlock()
+		due.send(now)
+	}
+}
+
+// due 返回触发时间不晚于 t 的最早的定时器，调用方需持有锁
+func (c *FakeClock) due(t time.Time) *fakeTicker {
+	sort.SliceStable(c.tickers, func(i, j int) bool {
+		return c.tickers[i].next.Before(c.tickers[j].next)
+	})
+	if len(c.tickers) == 0 || c.tickers[0].next.After(t) {
+		return nil
+	}
+	return c.tickers[0]
+}
+
+// remove 移除已停止的定时器
+func (c *FakeClock) remove(t *fakeTicker) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, item := range c.tickers {
+		if item == t {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			return
+		}
+	}
+}
+
+type fakeTicker struct {
+	clock    *FakeClock
+	period   time.Duration
+	next     time.Time
+	c        chan time.Time
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+		t.clock.remove(t)
+	})
+}
+
+// send 等待接收方取走时间，定时器停止时放弃发送
+func (t *fakeTicker) send(now time.Time) {
+	select {
+	case t.c <- now:
+	case <-t.done:
+	}
+}
@@ -0,0 +1,54 @@
+package timex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	ticker := clock.NewTicker(time.Second)
+	clock.BlockUntil(1)
+	var ticks []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for tick := range ticker.C() {
+			ticks = append(ticks, tick)
+			if len(ticks) == 3 {
+				return
+			}
+		}
+	}()
+	// 推进时间越过三个周期，定时器依次触发三次
+	clock.Advance(3500 * time.Millisecond)
+	<-done
+	assert.Equal(t, []time.Time{start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second)}, ticks)
+	assert.Equal(t, start.Add(3500*time.Millisecond), clock.Now())
+
+	// 已停止的定时器不再触发，推进时间不会阻塞
+	ticker.Stop()
+	clock.Advance(time.Minute)
+	require.Equal(t, start.Add(3500*time.Millisecond+time.Minute), clock.Now())
+
+	// 时间不能倒退
+	clock.Set(start)
+	assert.Equal(t, start.Add(3500*time.Millisecond+time.Minute), clock.Now())
+}
+
+func TestSystemClock(t *testing.T) {
+	ticker := SystemClock.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		t.Fatal("system ticker did not fire")
+	}
+	assert.WithinDuration(t, time.Now(), SystemClock.Now(), time.Second)
+}
@@ -1,12 +1,10 @@
 package window
 
 import (
-	"context"
 	"fmt"
 	"github.com/rulego/streamsql/utils/cast"
 	"github.com/rulego/streamsql/utils/timex"
 	"sync"
-	"time"
 
 	"github.com/rulego/streamsql/model"
 )
@@ -14,33 +12,30 @@ import (
 var _ Window = (*CountingWindow)(nil)
 
 type CountingWindow struct {
-	config      model.WindowConfig
-	threshold   int
-	count       int
-	mu          sync.Mutex
-	callback    func([]model.Row)
-	dataBuffer  []model.Row
-	outputChan  chan []model.Row
-	ctx         context.Context
-	cancelFunc  context.CancelFunc
-	ticker      *time.Ticker
-	triggerChan chan model.Row
+	config     model.WindowConfig
+	clock      timex.Clock
+	threshold  int
+	count      int
+	mu         sync.Mutex
+	callback   func([]model.Row)
+	dataBuffer []model.Row
+	outputChan chan []model.Row
+	// stopOnce 保证输出通道只关闭一次
+	stopOnce sync.Once
 }
 
 func NewCountingWindow(config model.WindowConfig) (*CountingWindow, error) {
-	ctx, cancel := context.WithCancel(context.Background())
 	threshold := cast.ToInt(config.Params["count"])
 	if threshold <= 0 {
 		return nil, fmt.Errorf("threshold must be a positive integer")
 	}
 
 	cw := &CountingWindow{
-		threshold:   threshold,
-		dataBuffer:  make([]model.Row, 0, threshold),
-		outputChan:  make(chan []model.Row, 10),
-		ctx:         ctx,
-		cancelFunc:  cancel,
-		triggerChan: make(chan model.Row, 3),
+		config:     config,
+		clock:      clockOf(config),
+		threshold:  threshold,
+		dataBuffer: make([]model.Row, 0, threshold),
+		outputChan: make(chan []model.Row, 10),
 	}
 
 	if callback, ok := config.Params["callback"].(func([]model.Row)); ok {
@@ -49,77 +44,90 @@ func NewCountingWindow(config model.WindowConfig) (*CountingWindow, error) {
 	return cw, nil
 }
 
+// Add 添加数据，数据量达到阈值时立即触发窗口
 func (cw *CountingWindow) Add(data interface{}) {
 	// 将数据添加到窗口的数据列表中
-	t := GetTimestamp(data, cw.config.TsProp)
+	t := getTimestamp(data, cw.config.TsProp, cw.clock)
 	row := model.Row{
 		Data:      data,
 		Timestamp: t,
 	}
-	cw.triggerChan <- row
+	cw.mu.Lock()
+	cw.dataBuffer = append(cw.dataBuffer, row)
+	cw.count++
+	var batch []model.Row
+	if cw.count >= cw.threshold {
+		batch = cw.take(cw.threshold)
+	}
+	cw.mu.Unlock()
+	cw.emit(batch)
 }
+
+// Start 计数窗口在添加数据时触发，不需要启动定时器
 func (cw *CountingWindow) Start() {
-	go func() {
-		defer cw.cancelFunc()
-
-		for {
-			select {
-			case row, ok := <-cw.triggerChan:
-				if !ok {
-					// 通道已关闭，退出循环
-					return
-				}
-				cw.mu.Lock()
-				cw.dataBuffer = append(cw.dataBuffer, row)
-				cw.count++
-				shouldTrigger := cw.count >= cw.threshold
-				cw.mu.Unlock()
-				// 只有当达到阈值时才触发
-				if shouldTrigger {
-					cw.Trigger()
-				}
-
-			case <-cw.ctx.Done():
-				return
-			}
-		}
-	}()
 }
 
+// Trigger 数据量达到阈值时触发窗口
 func (cw *CountingWindow) Trigger() {
-	//cw.triggerChan <- struct{}{}
 	cw.mu.Lock()
-	defer cw.mu.Unlock()
+	var data []model.Row
+	if len(cw.dataBuffer) >= cw.threshold {
+		data = cw.take(cw.threshold)
+	}
+	cw.mu.Unlock()
+	cw.emit(data)
+}
+
+// Flush 将不足阈值的剩余数据作为一个窗口输出
+func (cw *CountingWindow) Flush() {
+	cw.mu.Lock()
+	data := cw.take(len(cw.dataBuffer))
+	cw.mu.Unlock()
+	cw.emit(data)
+}
 
-	slot := cw.createSlot(cw.dataBuffer[:cw.threshold])
-	for _, r := range cw.dataBuffer[:cw.threshold] {
-		// 由于Row是值类型，这里需要通过指针来修改Slot字段
-		(&r).Slot = slot
+// Stop 关闭输出通道
+func (cw *CountingWindow) Stop() {
+	cw.stopOnce.Do(func() {
+		close(cw.outputChan)
+	})
+}
+
+// take 取出缓冲区中前 n 条数据并标记时间槽位，调用方需持有锁
+func (cw *CountingWindow) take(n int) []model.Row {
+	if n == 0 {
+		return nil
 	}
-	data := cw.dataBuffer[:cw.threshold]
-	if len(cw.dataBuffer) > cw.threshold {
-		remaining := len(cw.dataBuffer) - cw.threshold
-		newBuffer := make([]model.Row, remaining, cw.threshold)
-		copy(newBuffer, cw.dataBuffer[cw.threshold:])
-		cw.dataBuffer = newBuffer
-	} else {
-		cw.dataBuffer = make([]model.Row, 0, cw.threshold)
+	data := make([]model.Row, n)
+	copy(data, cw.dataBuffer[:n])
+	slot := cw.createSlot(data)
+	for i := range data {
+		data[i].Slot = slot
 	}
+	remaining := make([]model.Row, len(cw.dataBuffer)-n, cw.threshold)
+	copy(remaining, cw.dataBuffer[n:])
+	cw.dataBuffer = remaining
 	// 重置计数
 	cw.count = len(cw.dataBuffer)
-	go func(data []model.Row) {
-		if cw.callback != nil {
-			cw.callback(data)
-		}
-		cw.outputChan <- data
-	}(data)
+	return data
+}
+
+// emit 输出窗口数据，调用方不能持有锁
+func (cw *CountingWindow) emit(data []model.Row) {
+	if len(data) == 0 {
+		return
+	}
+	if cw.callback != nil {
+		cw.callback(data)
+	}
+	cw.outputChan <- data
 }
 
 func (cw *CountingWindow) Reset() {
 	cw.mu.Lock()
 	cw.count = 0
-	cw.mu.Unlock()
 	cw.dataBuffer = nil
+	cw.mu.Unlock()
 }
 
 func (cw *CountingWindow) OutputChan() <-chan []model.Row {
@@ -136,7 +144,7 @@ func (cw *CountingWindow) createSlot(data []model.Row) *model.TimeSlot {
 		return nil
 	} else if len(data) < cw.threshold {
 		start := timex.AlignTime(data[0].Timestamp, cw.config.TimeUnit, true)
-		end := timex.AlignTime(data[len(cw.dataBuffer)-1].Timestamp, cw.config.TimeUnit, false)
+		end := timex.AlignTime(data[len(data)-1].Timestamp, cw.config.TimeUnit, false)
 		slot := model.NewTimeSlot(&start, &end)
 		return slot
 	} else {
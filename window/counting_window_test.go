@@ -66,3 +66,26 @@ func TestCountingWindowBadThreshold(t *testing.T) {
 	})
 	require.Error(t, err)
 }
+
+func TestCountingWindowFlush(t *testing.T) {
+	cw, err := NewCountingWindow(model.WindowConfig{
+		Params: map[string]interface{}{"count": 3},
+	})
+	require.NoError(t, err)
+	cw.Start()
+	for i := 0; i < 5; i++ {
+		cw.Add(i)
+	}
+	// 不足阈值的剩余数据在 Flush 时作为一个窗口输出
+	cw.Flush()
+	cw.Stop()
+
+	var sizes []int
+	for rows := range cw.OutputChan() {
+		sizes = append(sizes, len(rows))
+		for _, row := range rows {
+			assert.NotNil(t, row.Slot)
+		}
+	}
+	assert.Equal(t, []int{3, 2}, sizes)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/rulego/streamsql/model"
+	"github.com/rulego/streamsql/utils/timex"
 )
 
 const (
@@ -23,6 +24,17 @@ type Window interface {
 	OutputChan() <-chan []model.Row
 	SetCallback(callback func([]model.Row))
 	Trigger()
+	// Flush 立即触发所有包含未触发数据的窗口，用于停止前输出剩余的数据
+	Flush()
+	// Stop 停止窗口的定时触发并关闭输出通道，未触发的数据被丢弃，重复调用是安全的。
+	// 调用方需保证 Stop 之后不再调用 Add、Trigger 和 Flush
+	Stop()
+}
+
+// LateDataWindow 支持迟到数据旁路输出的窗口。
+// 超过允许迟到时间的数据不会再进入任何窗口，而是交给迟到数据回调函数处理。
+type LateDataWindow interface {
+	SetLateDataCallback(callback func(model.Row))
 }
 
 func CreateWindow(config model.WindowConfig) (Window, error) {
@@ -33,6 +45,8 @@ func CreateWindow(config model.WindowConfig) (Window, error) {
 		return NewSlidingWindow(config)
 	case TypeCounting:
 		return NewCountingWindow(config)
+	case TypeSession:
+		return NewSessionWindow(config)
 	default:
 		return nil, fmt.Errorf("unsupported window type: %s", config.Type)
 	}
@@ -42,30 +56,70 @@ func (cw *CountingWindow) SetCallback(callback func([]model.Row)) {
 	cw.callback = callback
 }
 
-// GetTimestamp 从数据中获取时间戳。
+// clockOf 返回窗口使用的时钟，未配置时使用系统时钟。
+func clockOf(config model.WindowConfig) timex.Clock {
+	if config.Clock == nil {
+		return timex.SystemClock
+	}
+	return config.Clock
+}
+
+// GetTimestamp 从数据中获取时间戳，数据中没有时间戳时返回系统时钟的当前时间。
 func GetTimestamp(data interface{}, tsProp string) time.Time {
-	if ts, ok := data.(interface{ GetTimestamp() time.Time }); ok {
-		return ts.GetTimestamp()
-	} else if tsProp != "" {
-		v := reflect.ValueOf(data)
+	return getTimestamp(data, tsProp, timex.SystemClock)
+}
+
+// getTimestamp 从数据中获取时间戳，数据中没有时间戳或时间戳不是 time.Time 类型时返回时钟的当前时间。
+func getTimestamp(data interface{}, tsProp string, clock timex.Clock) time.Time {
+	if t, ok, err := lookupTimestamp(data, tsProp); ok && err == nil {
+		return t
+	}
+	return clock.Now()
+}
 
-		// 处理不同类型
-		switch v.Kind() {
-		case reflect.Struct:
-			// 如果是结构体，使用反射获取字段值
-			if f := v.FieldByName(tsProp); f.IsValid() {
-				if t, ok := f.Interface().(time.Time); ok {
-					return t
-				}
-			}
-		case reflect.Map:
-			// 如果是map，直接通过key获取值
-			if v.Type().Key().Kind() == reflect.String {
-				if value := v.MapIndex(reflect.ValueOf(tsProp)); value.IsValid() {
-					return value.Interface().(time.Time)
-				}
-			}
+// ValidateTimestamp 检查数据中的时间戳，时间戳字段存在但不是 time.Time 类型时返回错误，
+// 事件时间语义下数据中没有时间戳也返回错误。
+func ValidateTimestamp(data interface{}, config model.WindowConfig) error {
+	_, ok, err := lookupTimestamp(data, config.TsProp)
+	if err != nil {
+		return err
+	}
+	if !ok && isEventTime(config) {
+		return fmt.Errorf("timestamp field %s not found", config.TsProp)
+	}
+	return nil
+}
+
+// lookupTimestamp 从数据中查找时间戳，ok 表示数据中存在时间戳。
+func lookupTimestamp(data interface{}, tsProp string) (t time.Time, ok bool, err error) {
+	if ts, ok := data.(interface{ GetTimestamp() time.Time }); ok {
+		return ts.GetTimestamp(), true, nil
+	}
+	if tsProp == "" {
+		return time.Time{}, false, nil
+	}
+	var value reflect.Value
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	// 处理不同类型
+	switch v.Kind() {
+	case reflect.Struct:
+		// 如果是结构体，使用反射获取字段值
+		value = v.FieldByName(tsProp)
+	case reflect.Map:
+		// 如果是map，直接通过key获取值
+		if v.Type().Key().Kind() == reflect.String {
+			value = v.MapIndex(reflect.ValueOf(tsProp).Convert(v.Type().Key()))
 		}
 	}
-	return time.Now()
+	if !value.IsValid() || !value.CanInterface() {
+		return time.Time{}, false, nil
+	}
+	t, ok = value.Interface().(time.Time)
+	if !ok {
+		return time.Time{}, true, fmt.Errorf("timestamp field %s must be time.Time but got %T", tsProp, value.Interface())
+	}
+	return t, true, nil
 }
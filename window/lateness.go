@@ -0,0 +1,82 @@
+package window
+
+import (
+	"time"
+
+	"github.com/rulego/streamsql/model"
+)
+
+// firedSlot 已触发窗口保留的数据
+type firedSlot struct {
+	slot *model.TimeSlot
+	rows []model.Row
+}
+
+// lateness 在允许的迟到时间内保留已触发窗口的数据，
+// 迟到数据到达时把它并入对应窗口并重新输出更新后的窗口数据。
+type lateness struct {
+	// allowed 允许的迟到时间
+	allowed time.Duration
+	// slots 按窗口开始时间保存已触发窗口的数据
+	slots map[int64]*firedSlot
+	// callback 超过允许迟到时间的数据的回调函数
+	callback func(model.Row)
+}
+
+func newLateness(allowed time.Duration) *lateness {
+	return &lateness{
+		allowed: allowed,
+		slots:   make(map[int64]*firedSlot),
+	}
+}
+
+// retain 保留已触发窗口的数据，未设置允许迟到时间时不保留。
+func (l *lateness) retain(slot *model.TimeSlot, rows []model.Row) {
+	if l.allowed <= 0 {
+		return
+	}
+	retained := make([]model.Row, len(rows))
+	copy(retained, rows)
+	l.slots[slot.Start.UnixNano()] = &firedSlot{slot: slot, rows: retained}
+}
+
+// accepts 判断在时间 now 下，已触发窗口 slot 是否仍然接受迟到数据。
+func (l *lateness) accepts(slot *model.TimeSlot, now time.Time) bool {
+	return l.allowed > 0 && slot.End.Add(l.allowed).After(now)
+}
+
+// add 将迟到数据并入已触发的窗口，返回更新后的完整窗口数据。
+func (l *lateness) add(slot *model.TimeSlot, row model.Row) []model.Row {
+	key := slot.Start.UnixNano()
+	fired, ok := l.slots[key]
+	if !ok {
+		fired = &firedSlot{slot: slot}
+		l.slots[key] = fired
+	}
+	row.Slot = fired.slot
+	fired.rows = append(fired.rows, row)
+	result := make([]model.Row, len(fired.rows))
+	copy(result, fired.rows)
+	return result
+}
+
+// expire 清除在时间 now 下已超过允许迟到时间的窗口数据。
+func (l *lateness) expire(now time.Time) {
+	for key, fired := range l.slots {
+		if !l.accepts(fired.slot, now) {
+			delete(l.slots, key)
+		}
+	}
+}
+
+// drop 将超过允许迟到时间的数据交给迟到数据回调函数。
+func (l *lateness) drop(row model.Row) {
+	if l.callback != nil {
+		l.callback(row)
+	}
+}
+
+// reset 清除所有保留的窗口数据。
+func (l *lateness) reset() {
+	l.slots = make(map[int64]*firedSlot)
+}
@@ -0,0 +1,261 @@
+package window
+
+import (
+	"time"
+
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/model"
+)
+
+// PaneResults 按分片聚合的滑动窗口触发时输出的分组聚合结果。
+// 窗口每次触发只输出一条数据，其 Data 为 PaneResults，Slot 为触发的窗口，窗口内没有数据时输出空批数据
+type PaneResults []map[string]interface{}
+
+// panes 滑动窗口的分片聚合。窗口按 gcd(size, slide) 的大小划分为分片，每条数据在加入窗口时聚合到所属的分片中，
+// 窗口触发时合并窗口内各分片的部分聚合状态，而不是保存并重新聚合窗口内的所有数据。
+// 所有聚合器都可以撤销时维护当前窗口的聚合状态，每次滑动只撤销移出窗口的分片、合并移入窗口的分片。
+// 分片按时间顺序合并，collect_list 等与数据顺序有关的结果按分片的时间顺序排列
+type panes struct {
+	// proto 创建分片和窗口聚合器的原型
+	proto *aggregator.GroupAggregator
+	// size 分片的大小，单位为纳秒
+	size int64
+	// onError 数据聚合出错时的回调函数
+	onError func(data interface{}, err error)
+	// panes 按分片序号保存分片的聚合状态，minIdx 和 maxIdx 为已有分片序号的范围
+	panes          map[int64]*aggregator.GroupAggregator
+	minIdx, maxIdx int64
+	// running 可撤销时最后一个触发的窗口的聚合状态，包含序号在 [runFrom, runTo) 内的分片
+	running        *aggregator.GroupAggregator
+	runFrom, runTo int64
+}
+
+func newPanes(proto *aggregator.GroupAggregator, size time.Duration, onError func(interface{}, error)) *panes {
+	return &panes{
+		proto:   proto,
+		size:    int64(size),
+		onError: onError,
+		panes:   make(map[int64]*aggregator.GroupAggregator),
+	}
+}
+
+// SetPaneAggregator 设置按分片预聚合数据的分组聚合器，需在写入数据前设置。
+// 设置后每条数据在加入窗口时聚合到所属的分片中，窗口不再保存数据，触发时合并窗口内各分片的聚合状态，
+// 输出一条 Data 为 PaneResults 的数据。onError 接收聚合出错的数据和错误。
+// proto 为 nil 或者包含不能合并的聚合器时不按分片聚合，返回 false
+func (sw *SlidingWindow) SetPaneAggregator(proto *aggregator.GroupAggregator, onError func(data interface{}, err error)) bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if proto == nil || !proto.Mergeable() || sw.PaneSize() <= 0 {
+		sw.panes = nil
+		return false
+	}
+	sw.panes = newPanes(proto, sw.PaneSize(), onError)
+	return true
+}
+
+// addToPane 将数据聚合到所属的分片，并重新输出仍在允许迟到时间内的已触发窗口 lateSlots，now 为窗口当前的时间进度。
+// 数据不属于任何未触发的窗口（onTime 为 false），且没有窗口接受该数据时，交给迟到数据回调函数。
+// 事件时间语义下按时到达的数据推进水位线并触发水位线已越过的窗口。调用方需持有锁，函数返回前释放锁
+func (sw *SlidingWindow) addToPane(row model.Row, lateSlots []*model.TimeSlot, now time.Time, onTime bool) {
+	var accepted []*model.TimeSlot
+	for _, slot := range lateSlots {
+		if sw.lateness.accepts(slot, now) {
+			accepted = append(accepted, slot)
+		}
+	}
+	if !onTime && len(accepted) == 0 {
+		sw.mu.Unlock()
+		sw.lateness.drop(row)
+		return
+	}
+	err := sw.panes.add(row)
+	var batches [][]model.Row
+	for _, slot := range accepted {
+		batches = append(batches, sw.panes.fire(slot, false))
+	}
+	if onTime && isEventTime(sw.config) {
+		sw.watermark.Update(row.Timestamp)
+		batches = append(batches, sw.fireByWatermark()...)
+	}
+	onError := sw.panes.onError
+	sw.mu.Unlock()
+	if err != nil && onError != nil {
+		onError(row.Data, err)
+	}
+	sw.emit(batches)
+}
+
+// pending 返回窗口中不早于 from 的最早数据时间，没有这样的数据时返回 false，调用方需持有锁。
+// 不按分片聚合时窗口只保存未触发的数据，from 不起作用
+func (sw *SlidingWindow) pending(from time.Time) (time.Time, bool) {
+	if sw.panes != nil {
+		return sw.panes.earliest(from)
+	}
+	if len(sw.data) == 0 {
+		return time.Time{}, false
+	}
+	return sw.earliest(), true
+}
+
+// hasPending 判断窗口中是否有不早于 from 的数据，调用方需持有锁
+func (sw *SlidingWindow) hasPending(from time.Time) bool {
+	if sw.panes != nil {
+		_, ok := sw.panes.earliest(from)
+		return ok
+	}
+	return len(sw.data) > 0
+}
+
+// evictPanes 清除不再属于任何未触发窗口、也不属于仍接受迟到数据的已触发窗口的分片，调用方需持有锁
+func (sw *SlidingWindow) evictPanes() {
+	var keepFrom, now time.Time
+	if isEventTime(sw.config) {
+		keepFrom, now = sw.nextStart, sw.watermark.Current()
+	} else {
+		// 处理时间语义下当前窗口之前一个窗口大小内有数据时，定时器仍会触发当前窗口
+		keepFrom, now = sw.currentSlot.Start.Add(-sw.size), sw.currentSlot.End.Add(-sw.slide)
+	}
+	if allowed := sw.lateness.allowed; allowed > 0 {
+		if late := now.Add(-allowed - sw.size); late.Before(keepFrom) {
+			keepFrom = late
+		}
+	}
+	sw.panes.evict(keepFrom)
+}
+
+// add 将数据聚合到所属的分片，分片已合并到当前窗口的聚合状态时同时加入窗口状态，返回聚合出错时的错误
+func (p *panes) add(row model.Row) error {
+	idx := floorDiv(row.Timestamp.UnixNano(), p.size)
+	agg, ok := p.panes[idx]
+	if !ok {
+		if len(p.panes) == 0 || idx < p.minIdx {
+			p.minIdx = idx
+		}
+		if len(p.panes) == 0 || idx > p.maxIdx {
+			p.maxIdx = idx
+		}
+		agg = p.proto.New()
+		p.panes[idx] = agg
+	}
+	agg.Put(aggregator.EventTimeKey, row.Timestamp)
+	err := agg.Add(row.Data)
+	if p.running != nil && idx >= p.runFrom && idx < p.runTo {
+		p.running.Put(aggregator.EventTimeKey, row.Timestamp)
+		_ = p.running.Add(row.Data)
+	}
+	return err
+}
+
+// earliest 返回开始时间不早于 from 的第一个分片的开始时间，没有这样的分片时返回 false。
+// 窗口的边界都是分片大小的整数倍，包含分片开始时间的窗口与包含分片内任一数据的窗口相同
+func (p *panes) earliest(from time.Time) (time.Time, bool) {
+	lo := floorDiv(from.UnixNano(), p.size)
+	if lo < p.minIdx {
+		lo = p.minIdx
+	}
+	if len(p.panes) == 0 || lo > p.maxIdx {
+		return time.Time{}, false
+	}
+	found := false
+	var first int64
+	if p.maxIdx-lo < int64(len(p.panes)) {
+		for idx := lo; idx <= p.maxIdx; idx++ {
+			if _, ok := p.panes[idx]; ok {
+				first, found = idx, true
+				break
+			}
+		}
+	} else {
+		// 分片序号的范围远大于分片数量时直接遍历分片
+		for idx := range p.panes {
+			if idx >= lo && (!found || idx < first) {
+				first, found = idx, true
+			}
+		}
+	}
+	return time.Unix(0, first*p.size), found
+}
+
+// fire 合并窗口 slot 内各分片的聚合状态，返回窗口输出的批数据。
+// incremental 为 true 时窗口按时间顺序依次触发，可撤销时在上一个窗口的聚合状态上撤销和合并分片；
+// 迟到数据重新触发的窗口重新合并窗口内的分片
+func (p *panes) fire(slot *model.TimeSlot, incremental bool) []model.Row {
+	from, to := floorDiv(slot.WindowStart(), p.size), floorDiv(slot.WindowEnd(), p.size)
+	var agg *aggregator.GroupAggregator
+	if incremental && p.proto.Retractable() {
+		if p.running == nil || from < p.runFrom || from >= p.runTo {
+			p.running = p.proto.New()
+			p.runFrom, p.runTo = from, from
+		}
+		for idx := p.runFrom; idx < from; idx++ {
+			if pane, ok := p.panes[idx]; ok {
+				p.running.Retract(pane)
+			}
+		}
+		for idx := p.runTo; idx < to; idx++ {
+			if pane, ok := p.panes[idx]; ok {
+				p.running.Merge(pane)
+			}
+		}
+		p.runFrom, p.runTo = from, to
+		agg = p.running
+	} else {
+		agg = p.proto.New()
+		for idx := from; idx < to; idx++ {
+			if pane, ok := p.panes[idx]; ok {
+				agg.Merge(pane)
+			}
+		}
+	}
+	agg.Put("window_start", slot.WindowStart())
+	agg.Put("window_end", slot.WindowEnd())
+	agg.ApplyContext()
+	results, _ := agg.GetResults()
+	if len(results) == 0 {
+		return make([]model.Row, 0)
+	}
+	return []model.Row{{Data: PaneResults(results), Timestamp: *slot.Start, Slot: slot}}
+}
+
+// evict 清除结束时间不晚于 keepFrom 的分片，当前窗口聚合状态中的分片保留到撤销为止
+func (p *panes) evict(keepFrom time.Time) {
+	bound := floorDiv(keepFrom.UnixNano(), p.size)
+	if p.running != nil && p.runFrom < bound {
+		bound = p.runFrom
+	}
+	if len(p.panes) == 0 || bound <= p.minIdx {
+		return
+	}
+	if bound-p.minIdx < int64(len(p.panes)) {
+		for idx := p.minIdx; idx < bound; idx++ {
+			delete(p.panes, idx)
+		}
+	} else {
+		for idx := range p.panes {
+			if idx < bound {
+				delete(p.panes, idx)
+			}
+		}
+	}
+	p.minIdx = bound
+	if len(p.panes) == 0 {
+		p.maxIdx = bound
+	}
+}
+
+// reset 清除所有分片和窗口聚合状态
+func (p *panes) reset() {
+	p.panes = make(map[int64]*aggregator.GroupAggregator)
+	p.running = nil
+	p.runFrom, p.runTo = 0, 0
+}
+
+// floorDiv 向下取整的整数除法，使早于 1970 年的时间也落在正确的分片中
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,315 @@
+package window
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rulego/streamsql/aggregator"
+	"github.com/rulego/streamsql/model"
+	"github.com/rulego/streamsql/utils/cast"
+	"github.com/rulego/streamsql/utils/timex"
+)
+
+// 确保 SessionWindow 结构体实现了 Window 接口。
+var _ Window = (*SessionWindow)(nil)
+var _ LateDataWindow = (*SessionWindow)(nil)
+
+// session 表示一个分组键下的单个会话。
+type session struct {
+	// start 会话中最早一条数据的时间
+	start time.Time
+	// end 会话结束时间，即最近一条数据的时间加上不活跃间隔
+	end time.Time
+	// rows 会话内收集的数据
+	rows []model.Row
+	// lastActive 会话最近一次收到数据的处理时间
+	lastActive time.Time
+}
+
+// SessionWindow 表示一个会话窗口，按分组键维护独立的会话，
+// 会话在超过不活跃间隔没有新数据后关闭并触发处理。
+type SessionWindow struct {
+	// config 是窗口的配置信息。
+	config model.WindowConfig
+	// clock 窗口使用的时钟。
+	clock timex.Clock
+	// timeout 是会话的不活跃间隔。
+	timeout time.Duration
+	// groups 是会话的分组字段，配置了 GroupBy 时使用 GroupBy，否则使用 GroupFields。
+	groups []aggregator.GroupField
+	// mu 用于保护对会话数据的并发访问。
+	mu sync.Mutex
+	// sessions 按分组键存储未关闭的会话，每个分组键下的会话按开始时间排序。
+	sessions map[string][]*session
+	// outputChan 是一个通道，用于在会话关闭时发送数据。
+	outputChan chan []model.Row
+	// callback 是一个可选的回调函数，在会话关闭时调用。
+	callback func([]model.Row)
+	// ctx 用于控制窗口的生命周期。
+	ctx context.Context
+	// cancelFunc 用于取消窗口的操作。
+	cancelFunc context.CancelFunc
+	// watermark 事件时间语义下的水位线
+	watermark *Watermark
+	// lateCallback 迟到数据的回调函数
+	lateCallback func(model.Row)
+	// wg 等待定时检查协程退出
+	wg sync.WaitGroup
+	// stopOnce 保证输出通道只关闭一次
+	stopOnce sync.Once
+}
+
+// NewSessionWindow 创建一个新的会话窗口实例。
+// 参数 timeout 是会话的不活跃间隔。
+func NewSessionWindow(config model.WindowConfig) (*SessionWindow, error) {
+	timeout, err := cast.ToDurationE(config.Params["timeout"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout for session window: %v", err)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("timeout for session window must be positive")
+	}
+	groups := config.GroupBy
+	if len(groups) == 0 {
+		groups = aggregator.GroupFieldsOf(config.GroupFields)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SessionWindow{
+		config:     config,
+		clock:      clockOf(config),
+		timeout:    timeout,
+		groups:     groups,
+		sessions:   make(map[string][]*session),
+		outputChan: make(chan []model.Row, 10),
+		ctx:        ctx,
+		cancelFunc: cancel,
+		watermark:  NewWatermark(config.MaxOutOfOrderness),
+	}, nil
+}
+
+// Add 向会话窗口添加数据。
+// 数据会并入所属分组键下与其时间间隔不超过 timeout 的会话，
+// 如果乱序数据填补了两个会话之间的间隔，这些会话会被合并为一个。
+// 事件时间语义下，数据单独成会话时已经关闭、且不与任何未关闭的会话重叠时才作为迟到数据。
+// 事件时间语义下会关闭结束时间不晚于水位线的会话。
+func (sw *SessionWindow) Add(data interface{}) {
+	sw.mu.Lock()
+	t := getTimestamp(data, sw.config.TsProp, sw.clock)
+	row := model.Row{
+		Data:      data,
+		Timestamp: t,
+	}
+	key := sessionKey(data, sw.groups)
+	if isEventTime(sw.config) && !t.Add(sw.timeout).After(sw.watermark.Current()) && !sw.overlapsOpen(key, t) {
+		// 数据单独成会话时已经关闭，且不属于任何未关闭的会话，交给迟到数据回调函数
+		sw.mu.Unlock()
+		if sw.lateCallback != nil {
+			sw.lateCallback(row)
+		}
+		return
+	}
+	merged := &session{
+		start:      t,
+		end:        t.Add(sw.timeout),
+		rows:       []model.Row{row},
+		lastActive: sw.clock.Now(),
+	}
+	// 合并所有与新数据重叠的会话
+	remaining := make([]*session, 0, len(sw.sessions[key]))
+	for _, s := range sw.sessions[key] {
+		if !s.start.After(merged.end) && !merged.start.After(s.end) {
+			if s.start.Before(merged.start) {
+				merged.start = s.start
+			}
+			if s.end.After(merged.end) {
+				merged.end = s.end
+			}
+			merged.rows = append(merged.rows, s.rows...)
+			continue
+		}
+		remaining = append(remaining, s)
+	}
+	remaining = append(remaining, merged)
+	sort.Slice(remaining, func(i, j int) bool {
+		return remaining[i].start.Before(remaining[j].start)
+	})
+	sw.sessions[key] = remaining
+	if !isEventTime(sw.config) {
+		sw.mu.Unlock()
+		return
+	}
+	sw.watermark.Update(t)
+	closed := sw.closeSessions()
+	sw.mu.Unlock()
+	sw.emit(closed)
+}
+
+// overlapsOpen 判断时间为 t 的数据是否与分组键 key 下未关闭的会话重叠，重叠的乱序数据并入会话而不是作为迟到数据，调用方需持有锁。
+func (sw *SessionWindow) overlapsOpen(key string, t time.Time) bool {
+	for _, s := range sw.sessions[key] {
+		if !s.start.After(t.Add(sw.timeout)) && !t.After(s.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start 启动会话窗口的定时检查机制，关闭超时的会话。
+// 事件时间语义下会话由水位线关闭，不启动定时器。
+func (sw *SessionWindow) Start() {
+	if isEventTime(sw.config) {
+		return
+	}
+	sw.wg.Add(1)
+	go func() {
+		defer sw.wg.Done()
+		timer := sw.clock.NewTicker(sw.checkInterval())
+		defer timer.Stop()
+		for {
+			select {
+			// 当定时器到期时，检查并关闭超时的会话。
+			case <-timer.C():
+				sw.Trigger()
+			// 当上下文被取消时，退出循环。
+			case <-sw.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// checkInterval 返回检查会话是否超时的时间间隔。
+func (sw *SessionWindow) checkInterval() time.Duration {
+	interval := sw.timeout / 2
+	if interval <= 0 {
+		interval = sw.timeout
+	}
+	return interval
+}
+
+// Stop 停止会话窗口的操作，等待定时检查协程退出后关闭输出通道。
+func (sw *SessionWindow) Stop() {
+	sw.stopOnce.Do(func() {
+		sw.cancelFunc()
+		sw.wg.Wait()
+		close(sw.outputChan)
+	})
+}
+
+// Flush 关闭所有未关闭的会话，并按会话开始时间依次输出会话数据。
+func (sw *SessionWindow) Flush() {
+	sw.mu.Lock()
+	var all []*session
+	for _, sessions := range sw.sessions {
+		all = append(all, sessions...)
+	}
+	sw.sessions = make(map[string][]*session)
+	sw.mu.Unlock()
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].start.Before(all[j].start)
+	})
+	closed := make([][]model.Row, 0, len(all))
+	for _, s := range all {
+		closed = append(closed, s.result())
+	}
+	sw.emit(closed)
+}
+
+// Trigger 关闭所有超过不活跃间隔的会话，并逐个输出会话数据。
+func (sw *SessionWindow) Trigger() {
+	sw.mu.Lock()
+	closed := sw.closeSessions()
+	sw.mu.Unlock()
+	sw.emit(closed)
+}
+
+// closeSessions 移除并返回所有已关闭的会话数据，调用方需持有锁。
+// 处理时间语义下，会话在超过不活跃间隔没有收到数据后关闭；
+// 事件时间语义下，会话在水位线越过会话结束时间后关闭。
+func (sw *SessionWindow) closeSessions() [][]model.Row {
+	now := sw.clock.Now()
+	wm := sw.watermark.Current()
+	eventTime := isEventTime(sw.config)
+	var closed [][]model.Row
+	for key, sessions := range sw.sessions {
+		remaining := sessions[:0]
+		for _, s := range sessions {
+			if (eventTime && !s.end.After(wm)) || (!eventTime && now.Sub(s.lastActive) >= sw.timeout) {
+				closed = append(closed, s.result())
+			} else {
+				remaining = append(remaining, s)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(sw.sessions, key)
+		} else {
+			sw.sessions[key] = remaining
+		}
+	}
+	return closed
+}
+
+// emit 依次输出关闭的会话数据，调用方不能持有锁。
+func (sw *SessionWindow) emit(closed [][]model.Row) {
+	for _, rows := range closed {
+		// 如果设置了回调函数，则执行回调函数
+		if sw.callback != nil {
+			sw.callback(rows)
+		}
+		sw.outputChan <- rows
+	}
+}
+
+// result 返回按时间排序并标记了会话时间槽的会话数据。
+func (s *session) result() []model.Row {
+	start, end := s.start, s.end
+	slot := model.NewTimeSlot(&start, &end)
+	rows := make([]model.Row, len(s.rows))
+	copy(rows, s.rows)
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Timestamp.Before(rows[j].Timestamp)
+	})
+	for i := range rows {
+		rows[i].Slot = slot
+	}
+	return rows
+}
+
+// Reset 重置会话窗口，丢弃所有未关闭的会话。
+func (sw *SessionWindow) Reset() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.sessions = make(map[string][]*session)
+	sw.watermark = NewWatermark(sw.config.MaxOutOfOrderness)
+}
+
+// OutputChan 返回一个只读通道，用于接收会话关闭时的数据。
+func (sw *SessionWindow) OutputChan() <-chan []model.Row {
+	return sw.outputChan
+}
+
+// SetLateDataCallback 设置迟到数据的回调函数。
+// 事件时间语义下，所属会话已经关闭的数据会交给该回调函数。
+func (sw *SessionWindow) SetLateDataCallback(callback func(model.Row)) {
+	sw.lateCallback = callback
+}
+
+// SetCallback 设置会话关闭时的回调函数。
+func (sw *SessionWindow) SetCallback(callback func([]model.Row)) {
+	sw.callback = callback
+}
+
+// sessionKey 根据分组字段生成会话的分组键，分组表达式求值出错时按 nil 分组，错误由聚合阶段报告
+func sessionKey(data interface{}, fields []aggregator.GroupField) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		values[i], _ = field.Value(data)
+	}
+	return aggregator.GroupKey(values)
+}
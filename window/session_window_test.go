@@ -0,0 +1,146 @@
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rulego/streamsql/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionWindow(t *testing.T) {
+	sw, err := NewSessionWindow(model.WindowConfig{
+		Type:        TypeSession,
+		Params:      map[string]interface{}{"timeout": "200ms"},
+		TsProp:      "ts",
+		GroupFields: []string{"device"},
+	})
+	require.NoError(t, err)
+	sw.Start()
+	defer sw.Stop()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	// device aa 的前两条数据间隔超过 timeout，形成两个会话，
+	// 第三条乱序数据填补了两者之间的间隔，三条数据合并为一个会话
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(350 * time.Millisecond)})
+	sw.Add(map[string]interface{}{"device": "bb", "ts": baseTime.Add(100 * time.Millisecond)})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(180 * time.Millisecond)})
+
+	results := make(map[string][]model.Row)
+	timeout := time.After(2 * time.Second)
+	for len(results) < 2 {
+		select {
+		case rows := <-sw.OutputChan():
+			require.NotEmpty(t, rows)
+			results[rows[0].Data.(map[string]interface{})["device"].(string)] = rows
+		case <-timeout:
+			t.Fatal("No session closed within timeout")
+		}
+	}
+
+	aa := results["aa"]
+	require.Len(t, aa, 3)
+	assert.Equal(t, baseTime, aa[0].Timestamp)
+	assert.Equal(t, baseTime.Add(180*time.Millisecond), aa[1].Timestamp)
+	assert.Equal(t, baseTime, *aa[0].Slot.Start)
+	assert.Equal(t, baseTime.Add(550*time.Millisecond), *aa[0].Slot.End)
+
+	bb := results["bb"]
+	require.Len(t, bb, 1)
+	assert.Equal(t, baseTime.Add(100*time.Millisecond), *bb[0].Slot.Start)
+	assert.Equal(t, baseTime.Add(300*time.Millisecond), *bb[0].Slot.End)
+}
+
+func TestSessionWindowBadTimeout(t *testing.T) {
+	_, err := CreateWindow(model.WindowConfig{
+		Type:   TypeSession,
+		Params: map[string]interface{}{},
+	})
+	require.Error(t, err)
+}
+
+func TestSessionWindowEventTime(t *testing.T) {
+	sw, err := NewSessionWindow(model.WindowConfig{
+		Type:               TypeSession,
+		Params:             map[string]interface{}{"timeout": "10s"},
+		TsProp:             "ts",
+		GroupFields:        []string{"device"},
+		TimeCharacteristic: model.EventTime,
+	})
+	require.NoError(t, err)
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(5 * time.Second)})
+	sw.Add(map[string]interface{}{"device": "bb", "ts": baseTime.Add(14 * time.Second)})
+	// 会话窗口不依赖系统时钟，水位线未越过会话结束时间前不会关闭
+	require.Len(t, sw.OutputChan(), 0)
+	// 水位线推进到 15s，关闭 device aa 的会话 [0s,15s)
+	sw.Add(map[string]interface{}{"device": "bb", "ts": baseTime.Add(15 * time.Second)})
+	require.Len(t, sw.OutputChan(), 1)
+	rows := <-sw.OutputChan()
+	require.Len(t, rows, 2)
+	assert.Equal(t, baseTime.Add(15*time.Second), *rows[0].Slot.End)
+}
+
+func TestSessionWindowOutOfOrderJoinsOpenSession(t *testing.T) {
+	sw, err := NewSessionWindow(model.WindowConfig{
+		Type:               TypeSession,
+		Params:             map[string]interface{}{"timeout": "10s"},
+		TsProp:             "ts",
+		GroupFields:        []string{"device"},
+		TimeCharacteristic: model.EventTime,
+	})
+	require.NoError(t, err)
+	var late []model.Row
+	sw.SetLateDataCallback(func(row model.Row) {
+		late = append(late, row)
+	})
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(9 * time.Second)})
+	// 水位线为 9s，-1s 的数据单独成会话时已经关闭，但与未关闭的会话 [0s,19s] 重叠，并入该会话
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(-time.Second)})
+	assert.Len(t, late, 0)
+	// 不与任何未关闭的会话重叠的数据仍是迟到数据
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(-20 * time.Second)})
+	assert.Len(t, late, 1)
+
+	// 水位线推进到 30s，关闭会话 [-1s,19s]
+	sw.Add(map[string]interface{}{"device": "bb", "ts": baseTime.Add(30 * time.Second)})
+	require.Len(t, sw.OutputChan(), 1)
+	rows := <-sw.OutputChan()
+	require.Len(t, rows, 3)
+	assert.Equal(t, baseTime.Add(-time.Second), *rows[0].Slot.Start)
+	assert.Equal(t, baseTime.Add(19*time.Second), *rows[0].Slot.End)
+}
+
+func TestSessionWindowFlush(t *testing.T) {
+	sw, err := NewSessionWindow(model.WindowConfig{
+		Type:        TypeSession,
+		Params:      map[string]interface{}{"timeout": "1m"},
+		TsProp:      "ts",
+		GroupFields: []string{"device"},
+	})
+	require.NoError(t, err)
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(map[string]interface{}{"device": "bb", "ts": baseTime.Add(time.Second)})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime})
+	sw.Add(map[string]interface{}{"device": "aa", "ts": baseTime.Add(2 * time.Second)})
+	// 未超时的会话在 Flush 时按开始时间依次关闭
+	sw.Flush()
+	sw.Stop()
+
+	var devices []string
+	for rows := range sw.OutputChan() {
+		devices = append(devices, rows[0].Data.(map[string]interface{})["device"].(string))
+	}
+	assert.Equal(t, []string{"aa", "bb"}, devices)
+}
@@ -13,6 +13,7 @@ import (
 
 // 确保 SlidingWindow 结构体实现了 Window 接口
 var _ Window = (*SlidingWindow)(nil)
+var _ LateDataWindow = (*SlidingWindow)(nil)
 
 // TimedData 用于包装数据和时间戳
 type TimedData struct {
@@ -40,19 +41,31 @@ type SlidingWindow struct {
 	ctx context.Context
 	// 用于取消上下文的函数
 	cancelFunc context.CancelFunc
+	// 窗口使用的时钟
+	clock timex.Clock
 	// 用于定时触发窗口的定时器
-	timer       *time.Ticker
+	timer       timex.Ticker
 	currentSlot *model.TimeSlot
 	// 用于初始化窗口的通道
 	initChan    chan struct{}
 	initialized bool
+	// watermark 事件时间语义下的水位线
+	watermark *Watermark
+	// nextStart 事件时间语义下下一个待触发窗口的最早开始时间，开始时间早于该时间的窗口均已触发
+	nextStart time.Time
+	// lateness 在允许的迟到时间内保留已触发窗口的数据
+	lateness *lateness
+	// wg 等待定时触发协程退出
+	wg sync.WaitGroup
+	// stopOnce 保证输出通道只关闭一次
+	stopOnce sync.Once
+	// panes 按分片预聚合数据，为 nil 时窗口保存数据并在触发时输出窗口内的所有数据
+	panes *panes
 }
 
 // NewSlidingWindow 创建一个新的滑动窗口实例
 // 参数 size 表示窗口的总大小，slide 表示窗口每次滑动的时间间隔
 func NewSlidingWindow(config model.WindowConfig) (*SlidingWindow, error) {
-	// 创建一个可取消的上下文
-	ctx, cancel := context.WithCancel(context.Background())
 	size, err := cast.ToDurationE(config.Params["size"])
 	if err != nil {
 		return nil, fmt.Errorf("invalid size for sliding window: %v", err)
@@ -61,8 +74,11 @@ func NewSlidingWindow(config model.WindowConfig) (*SlidingWindow, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid slide for sliding window: %v", err)
 	}
+	// 创建一个可取消的上下文
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SlidingWindow{
 		config:      config,
+		clock:       clockOf(config),
 		size:        size,
 		slide:       slide,
 		outputChan:  make(chan []model.Row, 10),
@@ -71,20 +87,28 @@ func NewSlidingWindow(config model.WindowConfig) (*SlidingWindow, error) {
 		data:        make([]model.Row, 0),
 		initChan:    make(chan struct{}),
 		initialized: false,
+		watermark:   NewWatermark(config.MaxOutOfOrderness),
+		lateness:    newLateness(config.AllowedLateness),
 	}, nil
 }
 
 // Add 向滑动窗口中添加数据
 // 参数 data 表示要添加的数据
 func (sw *SlidingWindow) Add(data interface{}) {
+	if isEventTime(sw.config) {
+		sw.addEventTime(data)
+		return
+	}
 	// 加锁以保证数据的并发安全
 	sw.mu.Lock()
-	defer sw.mu.Unlock()
 	// 将数据添加到窗口的数据列表中
-	t := GetTimestamp(data, sw.config.TsProp)
+	t := getTimestamp(data, sw.config.TsProp, sw.clock)
 	if !sw.initialized {
 		sw.currentSlot = sw.createSlot(t)
-		sw.timer = time.NewTicker(sw.slide)
+		// 重置后继续使用已有的定时器，定时触发协程一直监听同一个定时器
+		if sw.timer == nil {
+			sw.timer = sw.clock.NewTicker(sw.slide)
+		}
 		// 发送初始化完成信号
 		close(sw.initChan)
 		sw.initialized = true
@@ -93,20 +117,247 @@ func (sw *SlidingWindow) Add(data interface{}) {
 		Data:      data,
 		Timestamp: t,
 	}
+	// 开始时间早于当前窗口的窗口均已触发，数据在这些窗口中作为迟到数据处理
+	var lateSlots []*model.TimeSlot
+	for _, slot := range sw.slotsContaining(t, *sw.currentSlot.Start) {
+		if slot.Start.Before(*sw.currentSlot.Start) {
+			lateSlots = append(lateSlots, slot)
+		}
+	}
+	onTime := !t.Before(*sw.currentSlot.Start)
+	if sw.panes != nil {
+		sw.addToPane(row, lateSlots, sw.currentSlot.End.Add(-sw.slide), onTime)
+		return
+	}
+	if onTime {
+		sw.data = append(sw.data, row)
+	}
+	sw.addLate(row, lateSlots, sw.currentSlot.End.Add(-sw.slide), onTime)
+}
+
+// addLate 将数据并入已触发的窗口 lateSlots，now 为窗口当前的时间进度，调用方需持有锁，函数返回前释放锁。
+// 在允许迟到时间内的窗口会重新输出更新后的窗口数据；
+// 如果数据不属于任何未触发的窗口（onTime 为 false），且没有窗口接受该数据，则交给迟到数据回调函数。
+func (sw *SlidingWindow) addLate(row model.Row, lateSlots []*model.TimeSlot, now time.Time, onTime bool) {
+	batches := sw.lateBatches(row, lateSlots, now)
+	sw.mu.Unlock()
+	if !onTime && len(batches) == 0 {
+		sw.lateness.drop(row)
+		return
+	}
+	sw.emit(batches)
+}
+
+// lateBatches 将数据并入仍在允许迟到时间内的已触发窗口，返回这些窗口更新后的数据，调用方需持有锁
+func (sw *SlidingWindow) lateBatches(row model.Row, lateSlots []*model.TimeSlot, now time.Time) [][]model.Row {
+	var batches [][]model.Row
+	for _, slot := range lateSlots {
+		if sw.lateness.accepts(slot, now) {
+			batches = append(batches, sw.lateness.add(slot, row))
+		}
+	}
+	return batches
+}
+
+// slotsContaining 返回所有包含时间 t 的窗口，窗口的开始时间与 anchor 相差滑动步长的整数倍
+func (sw *SlidingWindow) slotsContaining(t, anchor time.Time) []*model.TimeSlot {
+	offset := time.Duration(anchor.UnixNano() % int64(sw.slide))
+	start := timex.AlignTimeToWindow(t.Add(-sw.size-offset), sw.slide).Add(offset)
+	for !start.Add(sw.size).After(t) {
+		start = start.Add(sw.slide)
+	}
+	var slots []*model.TimeSlot
+	for ; !start.After(t); start = start.Add(sw.slide) {
+		slotStart, slotEnd := start, start.Add(sw.size)
+		slots = append(slots, model.NewTimeSlot(&slotStart, &slotEnd))
+	}
+	return slots
+}
+
+// addEventTime 在事件时间语义下添加数据，推进水位线并触发水位线已越过的窗口
+func (sw *SlidingWindow) addEventTime(data interface{}) {
+	sw.mu.Lock()
+	t := getTimestamp(data, sw.config.TsProp, sw.clock)
+	row := model.Row{
+		Data:      data,
+		Timestamp: t,
+	}
+	// 开始时间早于 nextStart 的窗口均已触发，数据在这些窗口中作为迟到数据处理
+	var lateSlots []*model.TimeSlot
+	for _, slot := range sw.slotsContaining(t, time.Unix(0, 0)) {
+		if slot.Start.Before(sw.nextStart) {
+			lateSlots = append(lateSlots, slot)
+		}
+	}
+	onTime := !t.Before(sw.nextStart)
+	if sw.panes != nil {
+		sw.addToPane(row, lateSlots, sw.watermark.Current(), onTime)
+		return
+	}
+	if !onTime {
+		sw.addLate(row, lateSlots, sw.watermark.Current(), false)
+		return
+	}
+	batches := sw.lateBatches(row, lateSlots, sw.watermark.Current())
 	sw.data = append(sw.data, row)
+	sw.watermark.Update(t)
+	batches = append(batches, sw.fireByWatermark()...)
+	sw.mu.Unlock()
+	sw.emit(batches)
+}
+
+// fireByWatermark 提取所有结束时间不晚于水位线的窗口数据，调用方需持有锁
+func (sw *SlidingWindow) fireByWatermark() [][]model.Row {
+	wm := sw.watermark.Current()
+	var batches [][]model.Row
+	for {
+		// 跳过不包含任何数据的窗口，从包含最早数据的窗口开始触发
+		earliest, ok := sw.pending(sw.nextStart)
+		if !ok {
+			break
+		}
+		slot := sw.firstSlotContaining(earliest)
+		if slot.End.After(wm) {
+			break
+		}
+		batches = append(batches, sw.fireSlot(slot))
+	}
+	// 结束时间不晚于水位线的窗口都视为已触发，其后到达的数据按迟到数据处理
+	boundary := timex.AlignTimeToWindow(wm.Add(-sw.size), sw.slide)
+	for !boundary.Add(sw.size).After(wm) {
+		boundary = boundary.Add(sw.slide)
+	}
+	if boundary.After(sw.nextStart) {
+		sw.nextStart = boundary
+		newData := make([]model.Row, 0, len(sw.data))
+		for _, item := range sw.data {
+			if !item.Timestamp.Before(sw.nextStart) {
+				newData = append(newData, item)
+			}
+		}
+		sw.data = newData
+	}
+	sw.lateness.expire(wm)
+	if sw.panes != nil {
+		sw.evictPanes()
+	}
+	return batches
+}
+
+// earliest 返回窗口中最早的数据时间，调用方需持有锁且窗口中有数据
+func (sw *SlidingWindow) earliest() time.Time {
+	earliest := sw.data[0].Timestamp
+	for _, item := range sw.data {
+		if item.Timestamp.Before(earliest) {
+			earliest = item.Timestamp
+		}
+	}
+	return earliest
+}
+
+// fireSlot 提取窗口 slot 的数据，并丢弃不再属于后续窗口的数据，按分片聚合时输出合并分片后的聚合结果，调用方需持有锁
+func (sw *SlidingWindow) fireSlot(slot *model.TimeSlot) []model.Row {
+	if sw.panes != nil {
+		sw.nextStart = slot.Start.Add(sw.slide)
+		sw.currentSlot = slot
+		return sw.panes.fire(slot, true)
+	}
+	resultData := make([]model.Row, 0)
+	for _, item := range sw.data {
+		if slot.Contains(item.Timestamp) {
+			item.Slot = slot
+			resultData = append(resultData, item)
+		}
+	}
+	sw.nextStart = slot.Start.Add(sw.slide)
+	newData := make([]model.Row, 0, len(sw.data))
+	for _, item := range sw.data {
+		if !item.Timestamp.Before(sw.nextStart) {
+			newData = append(newData, item)
+		}
+	}
+	sw.data = newData
+	sw.currentSlot = slot
+	sw.lateness.retain(slot, resultData)
+	return resultData
+}
+
+// Flush 按时间顺序触发所有包含未触发数据的窗口
+func (sw *SlidingWindow) Flush() {
+	sw.mu.Lock()
+	var batches [][]model.Row
+	if isEventTime(sw.config) {
+		for earliest, ok := sw.pending(sw.nextStart); ok; earliest, ok = sw.pending(sw.nextStart) {
+			batches = append(batches, sw.fireSlot(sw.firstSlotContaining(earliest)))
+		}
+	} else if sw.initialized {
+		// 处理时间语义下从当前窗口开始按滑动步长依次触发，跳过不包含数据的窗口
+		for slot := sw.currentSlot; sw.hasPending(*slot.Start); slot = sw.NextSlot() {
+			if resultData := sw.fireSlot(slot); len(resultData) > 0 {
+				batches = append(batches, resultData)
+			}
+		}
+	}
+	if sw.panes != nil && sw.currentSlot != nil {
+		sw.evictPanes()
+	}
+	sw.mu.Unlock()
+	sw.emit(batches)
+}
+
+// Stop 停止滑动窗口，等待定时触发协程退出后关闭输出通道
+func (sw *SlidingWindow) Stop() {
+	sw.stopOnce.Do(func() {
+		sw.cancelFunc()
+		sw.wg.Wait()
+		close(sw.outputChan)
+	})
+}
+
+// firstSlotContaining 返回包含时间 t 且尚未触发的第一个窗口，窗口的开始时间按滑动步长对齐
+func (sw *SlidingWindow) firstSlotContaining(t time.Time) *model.TimeSlot {
+	start := timex.AlignTimeToWindow(t.Add(-sw.size), sw.slide)
+	for !start.Add(sw.size).After(t) {
+		start = start.Add(sw.slide)
+	}
+	if start.Before(sw.nextStart) {
+		start = sw.nextStart
+	}
+	end := start.Add(sw.size)
+	return model.NewTimeSlot(&start, &end)
+}
+
+// emit 依次输出触发的窗口数据，调用方不能持有锁
+func (sw *SlidingWindow) emit(batches [][]model.Row) {
+	for _, resultData := range batches {
+		// 如果设置了回调函数，则执行回调函数
+		if sw.callback != nil {
+			sw.callback(resultData)
+		}
+		sw.outputChan <- resultData
+	}
 }
 
 // Start 启动滑动窗口，开始定时触发窗口
+// 事件时间语义下窗口由水位线触发，不启动定时器
 func (sw *SlidingWindow) Start() {
+	if isEventTime(sw.config) {
+		return
+	}
+	initChan := sw.initChan
+	sw.wg.Add(1)
 	go func() {
-		// 等待初始化信号
-		<-sw.initChan
-		// 在函数结束时关闭输出通道。
-		defer close(sw.outputChan)
+		defer sw.wg.Done()
+		// 等待初始化信号，窗口停止时直接退出
+		select {
+		case <-initChan:
+		case <-sw.ctx.Done():
+			return
+		}
 		for {
 			select {
 			// 当定时器到期时，触发窗口
-			case <-sw.timer.C:
+			case <-sw.timer.C():
 				sw.Trigger()
 			// 当上下文被取消时，停止定时器并退出循环
 			case <-sw.ctx.Done():
@@ -118,38 +369,55 @@ func (sw *SlidingWindow) Start() {
 }
 
 // Trigger 触发滑动窗口，处理窗口内的数据
+// 事件时间语义下只触发结束时间不晚于当前水位线的窗口
 func (sw *SlidingWindow) Trigger() {
+	if isEventTime(sw.config) {
+		sw.mu.Lock()
+		batches := sw.fireByWatermark()
+		sw.mu.Unlock()
+		sw.emit(batches)
+		return
+	}
 	// 加锁以保证数据的并发安全
 	sw.mu.Lock()
 	defer sw.mu.Unlock()
 
-	// 如果窗口内没有数据，则直接返回
-	if len(sw.data) == 0 {
+	if !sw.initialized {
 		return
 	}
-	if !sw.initialized {
+	// 如果当前窗口及其之前一个窗口大小内没有数据，则直接返回
+	if !sw.hasPending(sw.currentSlot.Start.Add(-sw.size)) {
 		return
 	}
 	// 计算截止时间，即当前时间减去窗口的总大小
 	next := sw.NextSlot()
-	// 保留下一个窗口的数据
-	tms := next.Start.Add(-sw.size)
-	tme := next.End.Add(sw.size)
-	temp := model.NewTimeSlot(&tms, &tme)
-	newData := make([]model.Row, 0)
-	for _, item := range sw.data {
-		if temp.Contains(item.Timestamp) {
-			newData = append(newData, item)
+	var resultData []model.Row
+	if sw.panes != nil {
+		// 按分片聚合时只合并窗口内的分片，窗口不保存数据
+		resultData = sw.panes.fire(sw.currentSlot, true)
+	} else {
+		// 保留下一个窗口的数据
+		tms := next.Start.Add(-sw.size)
+		tme := next.End.Add(sw.size)
+		temp := model.NewTimeSlot(&tms, &tme)
+		newData := make([]model.Row, 0)
+		for _, item := range sw.data {
+			if temp.Contains(item.Timestamp) {
+				newData = append(newData, item)
+			}
 		}
-	}
 
-	// 提取出 Data 字段组成 []interface{} 类型的数据
-	resultData := make([]model.Row, 0)
-	for _, item := range sw.data {
-		if sw.currentSlot.Contains(item.Timestamp) {
-			item.Slot = sw.currentSlot
-			resultData = append(resultData, item)
+		// 提取出 Data 字段组成 []interface{} 类型的数据
+		resultData = make([]model.Row, 0)
+		for _, item := range sw.data {
+			if sw.currentSlot.Contains(item.Timestamp) {
+				item.Slot = sw.currentSlot
+				resultData = append(resultData, item)
+			}
 		}
+		// 更新窗口内的数据
+		sw.data = newData
+		sw.lateness.retain(sw.currentSlot, resultData)
 	}
 
 	// 如果设置了回调函数，则执行回调函数
@@ -157,9 +425,11 @@ func (sw *SlidingWindow) Trigger() {
 		sw.callback(resultData)
 	}
 
-	// 更新窗口内的数据
-	sw.data = newData
+	sw.lateness.expire(*sw.currentSlot.End)
 	sw.currentSlot = next
+	if sw.panes != nil {
+		sw.evictPanes()
+	}
 	// 将新的数据发送到输出通道
 	sw.outputChan <- resultData
 }
@@ -174,6 +444,12 @@ func (sw *SlidingWindow) Reset() {
 	sw.currentSlot = nil
 	sw.initialized = false
 	sw.initChan = make(chan struct{})
+	sw.watermark = NewWatermark(sw.config.MaxOutOfOrderness)
+	sw.nextStart = time.Time{}
+	sw.lateness.reset()
+	if sw.panes != nil {
+		sw.panes.reset()
+	}
 }
 
 // OutputChan 返回滑动窗口的输出通道
@@ -181,6 +457,11 @@ func (sw *SlidingWindow) OutputChan() <-chan []model.Row {
 	return sw.outputChan
 }
 
+// SetLateDataCallback 设置超过允许迟到时间的数据的回调函数
+func (sw *SlidingWindow) SetLateDataCallback(callback func(model.Row)) {
+	sw.lateness.callback = callback
+}
+
 // SetCallback 设置滑动窗口触发时执行的回调函数
 // 参数 callback 表示要设置的回调函数
 func (sw *SlidingWindow) SetCallback(callback func([]model.Row)) {
@@ -197,6 +478,16 @@ func (sw *SlidingWindow) NextSlot() *model.TimeSlot {
 	return next
 }
 
+// PaneSize 返回窗口分片的大小 gcd(size, slide)，窗口的开始和结束时间都是分片大小的整数倍，
+// 每个窗口恰好由连续的若干个分片组成
+func (sw *SlidingWindow) PaneSize() time.Duration {
+	a, b := sw.size, sw.slide
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
 // createSlot 创建一个新的时间槽位
 func (sw *SlidingWindow) createSlot(t time.Time) *model.TimeSlot {
 	// 创建一个新的时间槽位
@@ -1,11 +1,11 @@
 package window
 
 import (
-	"context"
 	"github.com/rulego/streamsql/utils/timex"
 	"testing"
 	"time"
 
+	"github.com/rulego/streamsql/aggregator"
 	"github.com/rulego/streamsql/model"
 	"github.com/stretchr/testify/assert"
 )
@@ -18,9 +18,7 @@ type TestResult struct {
 }
 
 func TestSlidingWindow(t *testing.T) {
-	_, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
+	clock := timex.NewFakeClock(time.Date(2025, 4, 7, 16, 47, 0, 0, time.UTC))
 	sw, _ := NewSlidingWindow(model.WindowConfig{
 		Params: map[string]interface{}{
 			"size":  "2s",
@@ -28,6 +26,7 @@ func TestSlidingWindow(t *testing.T) {
 		},
 		TsProp:   "Ts",
 		TimeUnit: time.Second,
+		Clock:    clock,
 	})
 	sw.SetCallback(func(results []model.Row) {
 		if len(results) == 0 {
@@ -39,6 +38,7 @@ func TestSlidingWindow(t *testing.T) {
 
 	})
 	sw.Start()
+	defer sw.Stop()
 
 	// 添加数据
 	t_3 := TestDate{Ts: time.Date(2025, 4, 7, 16, 46, 56, 789000000, time.UTC), tag: "1"}
@@ -58,36 +58,26 @@ func TestSlidingWindow(t *testing.T) {
 		{size: 2, data: []TestDate{t_1, t_0}, start: timex.AlignTime(t_1.Ts, time.Second, true), end: timex.AlignTime(t_0.Ts, time.Second, false)},
 		{size: 1, data: []TestDate{t_0}, start: timex.AlignTime(t_0.Ts, time.Second, true), end: timex.AlignTime(t_0.Ts, time.Second, true).Add(sw.size)},
 	}
-	// 等待一段时间，触发窗口
-	//time.Sleep(3 * time.Second)
-
-	// 检查结果
-	// resultsChan := sw.OutputChan()
-	// results := make(chan []model.Row)
+	// 每推进一个滑动步长的时间，定时器触发一次窗口
 	actual := make([]TestResult, 0)
-	timeout := time.After(6 * time.Second)
-	for {
+	for range expected {
+		clock.Advance(time.Second)
 		select {
 		case results := <-sw.OutputChan():
 			raw := make([]TestDate, 0)
 			for _, row := range results {
 				raw = append(raw, row.Data.(TestDate))
 			}
-			if len(results) == 0 {
-				continue
-			}
 			actual = append(actual, TestResult{
 				size:  len(results),
 				data:  raw,
 				start: *results[0].Slot.Start,
 				end:   *results[0].Slot.End})
-		case <-timeout:
-			goto END
-		default:
+		case <-time.After(time.Second):
+			t.Fatal("No results received within timeout")
 		}
 	}
 
-END:
 	assert.Equal(t, len(actual), len(expected))
 	// 预期结果：保留最近 2 秒内的数据
 	for i, exp := range expected {
@@ -128,3 +118,162 @@ func TestGetTimestamp(t *testing.T) {
 	assert.Equal(t, t_0, t_2)
 	assert.Equal(t, t_0, t_3)
 }
+
+func TestSlidingWindowEventTime(t *testing.T) {
+	sw, err := NewSlidingWindow(model.WindowConfig{
+		Type:               TypeSliding,
+		Params:             map[string]interface{}{"size": "2s", "slide": "1s"},
+		TsProp:             "Ts",
+		TimeCharacteristic: model.EventTime,
+	})
+	assert.NoError(t, err)
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(TestDate{Ts: baseTime.Add(500 * time.Millisecond), tag: "1"})
+	sw.Add(TestDate{Ts: baseTime.Add(1500 * time.Millisecond), tag: "2"})
+	// 水位线推进到 3.5s，触发 [-1s,1s)、[0s,2s)、[1s,3s) 三个窗口
+	sw.Add(TestDate{Ts: baseTime.Add(3500 * time.Millisecond), tag: "3"})
+
+	expected := []struct {
+		start time.Time
+		tags  []string
+	}{
+		{start: baseTime.Add(-time.Second), tags: []string{"1"}},
+		{start: baseTime, tags: []string{"1", "2"}},
+		{start: baseTime.Add(time.Second), tags: []string{"2"}},
+	}
+	assert.Len(t, sw.OutputChan(), len(expected))
+	for _, exp := range expected {
+		results := <-sw.OutputChan()
+		assert.Len(t, results, len(exp.tags))
+		for _, row := range results {
+			assert.Contains(t, exp.tags, row.Data.(TestDate).tag)
+			assert.Equal(t, exp.start, *row.Slot.Start)
+			assert.Equal(t, exp.start.Add(2*time.Second), *row.Slot.End)
+		}
+	}
+}
+
+func TestSlidingWindowAllowedLateness(t *testing.T) {
+	sw, err := NewSlidingWindow(model.WindowConfig{
+		Type:               TypeSliding,
+		Params:             map[string]interface{}{"size": "2s", "slide": "1s"},
+		TsProp:             "Ts",
+		TimeCharacteristic: model.EventTime,
+		AllowedLateness:    1500 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	var late []model.Row
+	sw.SetLateDataCallback(func(row model.Row) {
+		late = append(late, row)
+	})
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(TestDate{Ts: baseTime.Add(1500 * time.Millisecond), tag: "1"})
+	// 水位线推进到 3s，触发 [0s,2s)、[1s,3s)
+	sw.Add(TestDate{Ts: baseTime.Add(3 * time.Second), tag: "2"})
+	assert.Len(t, sw.OutputChan(), 2)
+	<-sw.OutputChan()
+	<-sw.OutputChan()
+
+	// 迟到数据属于 [-1s,1s) 与 [0s,2s)，只有 [0s,2s) 仍在允许迟到时间内
+	sw.Add(TestDate{Ts: baseTime.Add(500 * time.Millisecond), tag: "3"})
+	assert.Len(t, sw.OutputChan(), 1)
+	updated := <-sw.OutputChan()
+	assert.Len(t, updated, 2)
+	assert.Equal(t, baseTime, *updated[0].Slot.Start)
+	assert.Len(t, late, 0)
+
+	// 水位线推进到 4s，[0s,2s) 超过允许迟到时间，数据所属的窗口都不再接受数据
+	sw.Add(TestDate{Ts: baseTime.Add(4 * time.Second), tag: "4"})
+	for len(sw.OutputChan()) > 0 {
+		<-sw.OutputChan()
+	}
+	sw.Add(TestDate{Ts: baseTime.Add(800 * time.Millisecond), tag: "5"})
+	assert.Len(t, sw.OutputChan(), 0)
+	assert.Len(t, late, 1)
+}
+
+func TestSlidingWindowFlush(t *testing.T) {
+	sw, err := NewSlidingWindow(model.WindowConfig{
+		Type:               TypeSliding,
+		Params:             map[string]interface{}{"size": "2s", "slide": "1s"},
+		TsProp:             "Ts",
+		TimeCharacteristic: model.EventTime,
+	})
+	assert.NoError(t, err)
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	sw.Add(TestDate{Ts: baseTime.Add(500 * time.Millisecond), tag: "1"})
+	sw.Add(TestDate{Ts: baseTime.Add(1500 * time.Millisecond), tag: "2"})
+	// 水位线没有越过任何窗口的结束时间，Flush 触发所有包含数据的窗口
+	sw.Flush()
+	sw.Stop()
+
+	var starts []time.Time
+	var sizes []int
+	for rows := range sw.OutputChan() {
+		starts = append(starts, *rows[0].Slot.Start)
+		sizes = append(sizes, len(rows))
+	}
+	assert.Equal(t, []time.Time{baseTime.Add(-time.Second), baseTime, baseTime.Add(time.Second)}, starts)
+	assert.Equal(t, []int{1, 2, 1}, sizes)
+}
+
+func TestSlidingWindowPanes(t *testing.T) {
+	sw, err := NewSlidingWindow(model.WindowConfig{
+		Type:               TypeSliding,
+		Params:             map[string]interface{}{"size": "3s", "slide": "2s"},
+		TsProp:             "ts",
+		TimeCharacteristic: model.EventTime,
+		AllowedLateness:    2 * time.Second,
+	})
+	assert.NoError(t, err)
+	agg, err := aggregator.NewGroupAggregatorWithFields(nil, []aggregator.AggregationField{
+		{InputField: "value", AggregateType: aggregator.Sum, OutputAlias: "sum"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, sw.SetPaneAggregator(agg, nil))
+	assert.Equal(t, time.Second, sw.PaneSize())
+	var late []model.Row
+	sw.SetLateDataCallback(func(row model.Row) {
+		late = append(late, row)
+	})
+	sw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	add := func(offset time.Duration, value int) {
+		sw.Add(map[string]interface{}{"ts": baseTime.Add(offset), "value": value})
+	}
+	add(500*time.Millisecond, 1)
+	add(1500*time.Millisecond, 2)
+	add(2500*time.Millisecond, 4)
+	// 水位线推进到 4.5s，触发 [-2s,1s)、[0s,3s)
+	add(4500*time.Millisecond, 8)
+	// 迟到数据使 [0s,3s) 重新输出
+	add(1200*time.Millisecond, 32)
+	// 水位线推进到 6s，触发 [2s,5s)，[0s,3s) 超过允许迟到时间
+	add(6*time.Second, 16)
+	add(800*time.Millisecond, 64)
+	assert.Len(t, late, 1)
+	sw.Flush()
+	// 窗口不保存数据，只保留仍可能属于后续窗口的分片
+	assert.Empty(t, sw.data)
+	assert.LessOrEqual(t, len(sw.panes.panes), 5)
+	sw.Stop()
+
+	var starts []time.Duration
+	var sums []float64
+	for rows := range sw.OutputChan() {
+		assert.Len(t, rows, 1)
+		results := rows[0].Data.(PaneResults)
+		assert.Len(t, results, 1)
+		starts = append(starts, rows[0].Slot.Start.Sub(baseTime))
+		sums = append(sums, results[0]["sum"].(float64))
+	}
+	assert.Equal(t, []time.Duration{-2 * time.Second, 0, 0, 2 * time.Second, 4 * time.Second, 6 * time.Second}, starts)
+	assert.Equal(t, []float64{1, 7, 39, 12, 24, 16}, sums)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/rulego/streamsql/utils/cast"
 	"github.com/rulego/streamsql/utils/timex"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 
 // 确保 TumblingWindow 结构体实现了 Window 接口。
 var _ Window = (*TumblingWindow)(nil)
+var _ LateDataWindow = (*TumblingWindow)(nil)
 
 // TumblingWindow 表示一个滚动窗口，用于在固定时间间隔内收集数据并触发处理。
 type TumblingWindow struct {
@@ -33,53 +35,92 @@ type TumblingWindow struct {
 	ctx context.Context
 	// cancelFunc 用于取消窗口的操作。
 	cancelFunc context.CancelFunc
+	// clock 窗口使用的时钟。
+	clock timex.Clock
 	// timer 用于定时触发窗口。
-	timer       *time.Ticker
+	timer       timex.Ticker
 	currentSlot *model.TimeSlot
 	// 用于初始化窗口的通道
 	initChan    chan struct{}
 	initialized bool
+	// watermark 事件时间语义下的水位线
+	watermark *Watermark
+	// lateness 在允许的迟到时间内保留已触发窗口的数据
+	lateness *lateness
+	// wg 等待定时触发协程退出
+	wg sync.WaitGroup
+	// stopOnce 保证输出通道只关闭一次
+	stopOnce sync.Once
 }
 
 // NewTumblingWindow 创建一个新的滚动窗口实例。
 // 参数 size 是窗口的时间大小。
 func NewTumblingWindow(config model.WindowConfig) (*TumblingWindow, error) {
-	// 创建一个可取消的上下文。
-	ctx, cancel := context.WithCancel(context.Background())
 	size, err := cast.ToDurationE(config.Params["size"])
 	if err != nil {
 		return nil, fmt.Errorf("invalid size for tumbling window: %v", err)
 	}
+	// 创建一个可取消的上下文。
+	ctx, cancel := context.WithCancel(context.Background())
 	return &TumblingWindow{
 		config:      config,
+		clock:       clockOf(config),
 		size:        size,
 		outputChan:  make(chan []model.Row, 10),
 		ctx:         ctx,
 		cancelFunc:  cancel,
 		initChan:    make(chan struct{}),
 		initialized: false,
+		watermark:   NewWatermark(config.MaxOutOfOrderness),
+		lateness:    newLateness(config.AllowedLateness),
 	}, nil
 }
 
 // Add 向滚动窗口添加数据。
 // 参数 data 是要添加的数据。
 func (tw *TumblingWindow) Add(data interface{}) {
+	if isEventTime(tw.config) {
+		tw.addEventTime(data)
+		return
+	}
 	// 加锁以确保并发安全。
 	tw.mu.Lock()
-	defer tw.mu.Unlock()
 	// 将数据追加到窗口的数据列表中。
 	if !tw.initialized {
-		tw.currentSlot = tw.createSlot(GetTimestamp(data, tw.config.TsProp))
-		tw.timer = time.NewTicker(tw.size)
+		tw.currentSlot = tw.createSlot(getTimestamp(data, tw.config.TsProp, tw.clock))
+		// 重置后继续使用已有的定时器，定时触发协程一直监听同一个定时器
+		if tw.timer == nil {
+			tw.timer = tw.clock.NewTicker(tw.size)
+		}
 		// 发送初始化完成信号
 		close(tw.initChan)
 		tw.initialized = true
 	}
 	row := model.Row{
 		Data:      data,
-		Timestamp: GetTimestamp(data, tw.config.TsProp),
+		Timestamp: getTimestamp(data, tw.config.TsProp, tw.clock),
+	}
+	// 数据所属的窗口已经触发，作为迟到数据处理
+	if row.Timestamp.Before(*tw.currentSlot.Start) {
+		tw.addLate(row, *tw.currentSlot.Start)
+		return
 	}
 	tw.data = append(tw.data, row)
+	tw.mu.Unlock()
+}
+
+// addLate 处理所属窗口已经触发的迟到数据，now 为窗口当前的时间进度，调用方需持有锁，函数返回前释放锁。
+// 在允许迟到时间内，数据并入已触发的窗口并重新输出更新后的窗口数据，否则交给迟到数据回调函数。
+func (tw *TumblingWindow) addLate(row model.Row, now time.Time) {
+	slot := tw.createSlot(row.Timestamp)
+	if !tw.lateness.accepts(slot, now) {
+		tw.mu.Unlock()
+		tw.lateness.drop(row)
+		return
+	}
+	resultData := tw.lateness.add(slot, row)
+	tw.mu.Unlock()
+	tw.emit([][]model.Row{resultData})
 }
 
 func (sw *TumblingWindow) createSlot(t time.Time) *model.TimeSlot {
@@ -99,22 +140,128 @@ func (sw *TumblingWindow) NextSlot() *model.TimeSlot {
 	return model.NewTimeSlot(start, &end)
 }
 
-// Stop 停止滚动窗口的操作。
+// Stop 停止滚动窗口的操作，等待定时触发协程退出后关闭输出通道。
 func (tw *TumblingWindow) Stop() {
-	// 调用取消函数以停止窗口的操作。
-	tw.cancelFunc()
+	tw.stopOnce.Do(func() {
+		// 调用取消函数以停止窗口的操作。
+		tw.cancelFunc()
+		tw.wg.Wait()
+		close(tw.outputChan)
+	})
+}
+
+// Flush 按时间顺序触发所有包含未触发数据的窗口。
+func (tw *TumblingWindow) Flush() {
+	tw.mu.Lock()
+	slots := make(map[int64]*model.TimeSlot)
+	rows := make(map[int64][]model.Row)
+	var starts []int64
+	for _, item := range tw.data {
+		slot := tw.createSlot(item.Timestamp)
+		key := slot.Start.UnixNano()
+		if _, ok := slots[key]; !ok {
+			slots[key] = slot
+			starts = append(starts, key)
+		}
+		item.Slot = slots[key]
+		rows[key] = append(rows[key], item)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	batches := make([][]model.Row, 0, len(starts))
+	for _, key := range starts {
+		batches = append(batches, rows[key])
+	}
+	tw.data = nil
+	tw.mu.Unlock()
+	tw.emit(batches)
+}
+
+// addEventTime 在事件时间语义下添加数据，推进水位线并触发水位线已越过的窗口。
+func (tw *TumblingWindow) addEventTime(data interface{}) {
+	tw.mu.Lock()
+	row := model.Row{
+		Data:      data,
+		Timestamp: getTimestamp(data, tw.config.TsProp, tw.clock),
+	}
+	// 水位线已越过数据所属窗口的结束时间，窗口已经触发，作为迟到数据处理
+	wm := tw.watermark.Current()
+	if !tw.createSlot(row.Timestamp).End.After(wm) {
+		tw.addLate(row, wm)
+		return
+	}
+	tw.data = append(tw.data, row)
+	tw.watermark.Update(row.Timestamp)
+	batches := tw.fireByWatermark()
+	tw.mu.Unlock()
+	tw.emit(batches)
+}
+
+// fireByWatermark 提取所有结束时间不晚于水位线的窗口数据，调用方需持有锁。
+func (tw *TumblingWindow) fireByWatermark() [][]model.Row {
+	wm := tw.watermark.Current()
+	var batches [][]model.Row
+	for len(tw.data) > 0 {
+		// 从最早的数据所在的窗口开始触发
+		earliest := tw.data[0].Timestamp
+		for _, item := range tw.data {
+			if item.Timestamp.Before(earliest) {
+				earliest = item.Timestamp
+			}
+		}
+		slot := tw.createSlot(earliest)
+		if slot.End.After(wm) {
+			break
+		}
+		resultData := make([]model.Row, 0)
+		newData := make([]model.Row, 0, len(tw.data))
+		for _, item := range tw.data {
+			if slot.Contains(item.Timestamp) {
+				item.Slot = slot
+				resultData = append(resultData, item)
+			} else {
+				newData = append(newData, item)
+			}
+		}
+		tw.data = newData
+		tw.currentSlot = slot
+		tw.lateness.retain(slot, resultData)
+		batches = append(batches, resultData)
+	}
+	tw.lateness.expire(wm)
+	return batches
+}
+
+// emit 依次输出触发的窗口数据，调用方不能持有锁。
+func (tw *TumblingWindow) emit(batches [][]model.Row) {
+	for _, resultData := range batches {
+		// 如果设置了回调函数，则执行回调函数
+		if tw.callback != nil {
+			tw.callback(resultData)
+		}
+		tw.outputChan <- resultData
+	}
 }
 
 // Start 启动滚动窗口的定时触发机制。
+// 事件时间语义下窗口由水位线触发，不启动定时器。
 func (tw *TumblingWindow) Start() {
+	if isEventTime(tw.config) {
+		return
+	}
+	initChan := tw.initChan
+	tw.wg.Add(1)
 	go func() {
-		<-tw.initChan
-		// 在函数结束时关闭输出通道。
-		defer close(tw.outputChan)
+		defer tw.wg.Done()
+		// 等待第一条数据到达，窗口停止时直接退出
+		select {
+		case <-initChan:
+		case <-tw.ctx.Done():
+			return
+		}
 		for {
 			select {
 			// 当定时器到期时，触发窗口。
-			case <-tw.timer.C:
+			case <-tw.timer.C():
 				tw.Trigger()
 			// 当上下文被取消时，停止定时器并退出循环。
 			case <-tw.ctx.Done():
@@ -126,7 +273,15 @@ func (tw *TumblingWindow) Start() {
 }
 
 // Trigger 触发滚动窗口的处理逻辑。
+// 事件时间语义下只触发结束时间不晚于当前水位线的窗口。
 func (tw *TumblingWindow) Trigger() {
+	if isEventTime(tw.config) {
+		tw.mu.Lock()
+		batches := tw.fireByWatermark()
+		tw.mu.Unlock()
+		tw.emit(batches)
+		return
+	}
 	// 加锁以确保并发安全。
 	tw.mu.Lock()
 	defer tw.mu.Unlock()
@@ -162,6 +317,8 @@ func (tw *TumblingWindow) Trigger() {
 
 	// 更新窗口内的数据
 	tw.data = newData
+	tw.lateness.retain(tw.currentSlot, resultData)
+	tw.lateness.expire(*next.Start)
 	tw.currentSlot = next
 	// 将新的数据发送到输出通道
 	tw.outputChan <- resultData
@@ -177,6 +334,8 @@ func (tw *TumblingWindow) Reset() {
 	tw.currentSlot = nil
 	tw.initialized = false
 	tw.initChan = make(chan struct{})
+	tw.watermark = NewWatermark(tw.config.MaxOutOfOrderness)
+	tw.lateness.reset()
 }
 
 // OutputChan 返回一个只读通道，用于接收窗口触发时的数据。
@@ -184,6 +343,11 @@ func (tw *TumblingWindow) OutputChan() <-chan []model.Row {
 	return tw.outputChan
 }
 
+// SetLateDataCallback 设置超过允许迟到时间的数据的回调函数。
+func (tw *TumblingWindow) SetLateDataCallback(callback func(model.Row)) {
+	tw.lateness.callback = callback
+}
+
 // SetCallback 设置滚动窗口触发时的回调函数。
 // 参数 callback 是要设置的回调函数。
 func (tw *TumblingWindow) SetCallback(callback func([]model.Row)) {
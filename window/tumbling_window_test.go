@@ -1,30 +1,30 @@
 package window
 
 import (
-	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/rulego/streamsql/model"
+	"github.com/rulego/streamsql/utils/timex"
 	"github.com/stretchr/testify/require"
 )
 
 func TestTumblingWindow(t *testing.T) {
-	_, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	clock := timex.NewFakeClock(baseTime)
 	tw, _ := NewTumblingWindow(model.WindowConfig{
 		Type:   "TumblingWindow",
 		Params: map[string]interface{}{"size": "2s"},
 		TsProp: "Ts",
+		Clock:  clock,
 	})
 	tw.SetCallback(func(results []model.Row) {
 		// Process results
 	})
-	go tw.Start()
+	tw.Start()
+	defer tw.Stop()
 
-	// Add data every 500ms
-	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
 	// 添加测试数据
 	for i := 0; i < 5; i++ {
 		data := TestDate{
@@ -36,23 +36,21 @@ func TestTumblingWindow(t *testing.T) {
 
 	// 收集窗口结果
 	resultsChan := tw.OutputChan()
-	var all [][]model.Row = make([][]model.Row, 0)
-
-	// 收集所有窗口数据
-COLLECT:
-	for {
+	next := func() []model.Row {
+		// 推进一个窗口大小的时间，定时器触发一次窗口
+		clock.Advance(2 * time.Second)
 		select {
 		case results := <-resultsChan:
-			all = append(all, results)
-			if len(all) >= 3 {
-				break COLLECT
-			}
-		default:
+			return results
+		case <-time.After(time.Second):
+			t.Fatal("No results received within timeout")
+			return nil
 		}
 	}
-
-	// 验证窗口数据
-	require.Len(t, all, 3, "应该有3个时间窗口的数据")
+	var all [][]model.Row
+	for i := 0; i < 3; i++ {
+		all = append(all, next())
+	}
 
 	// 验证每个窗口的数据
 	expectedWindows := []struct {
@@ -88,15 +86,135 @@ COLLECT:
 		Ts:  baseTime.Add(time.Duration(99) * 1100 * time.Millisecond),
 		tag: fmt.Sprintf("%d", 99),
 	})
-	// time.Sleep(1100 * time.Millisecond)
-	cancel()
-
-	select {
-	case results := <-resultsChan:
-		require.Len(t, results, 1)
-		require.Equal(t, "99", results[0].Data.(TestDate).tag)
-		startTime := baseTime.Add(108 * time.Second)
-		endTime := baseTime.Add(110 * time.Second)
-		require.True(t, results[0].Slot.Start.Equal(startTime) && results[0].Slot.End.Equal(endTime))
+
+	results := next()
+	require.Len(t, results, 1)
+	require.Equal(t, "99", results[0].Data.(TestDate).tag)
+	startTime := baseTime.Add(108 * time.Second)
+	endTime := baseTime.Add(110 * time.Second)
+	require.True(t, results[0].Slot.Start.Equal(startTime) && results[0].Slot.End.Equal(endTime))
+}
+
+func TestTumblingWindowEventTime(t *testing.T) {
+	tw, err := NewTumblingWindow(model.WindowConfig{
+		Type:               TypeTumbling,
+		Params:             map[string]interface{}{"size": "2s"},
+		TsProp:             "Ts",
+		TimeCharacteristic: model.EventTime,
+		MaxOutOfOrderness:  time.Second,
+	})
+	require.NoError(t, err)
+	tw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	add := func(offset time.Duration, tag string) {
+		tw.Add(TestDate{Ts: baseTime.Add(offset), tag: tag})
+	}
+	add(0, "0")
+	add(1500*time.Millisecond, "1")
+	// 水位线为 1.5s，第一个窗口 [0s,2s) 尚未触发
+	add(2500*time.Millisecond, "2")
+	require.Len(t, tw.OutputChan(), 0)
+	// 乱序数据仍在允许的乱序时间内，归入第一个窗口
+	add(1800*time.Millisecond, "3")
+	// 水位线推进到 2s，触发第一个窗口
+	add(3*time.Second, "4")
+	require.Len(t, tw.OutputChan(), 1)
+	first := <-tw.OutputChan()
+	require.Len(t, first, 3)
+	for _, row := range first {
+		require.Contains(t, []string{"0", "1", "3"}, row.Data.(TestDate).tag)
+		require.True(t, row.Slot.Start.Equal(baseTime) && row.Slot.End.Equal(baseTime.Add(2*time.Second)))
 	}
+	// 数据所属窗口已触发，作为迟到数据丢弃
+	add(500*time.Millisecond, "late")
+	// 水位线跳过没有数据的窗口 [4s,6s)，触发 [2s,4s)
+	add(7*time.Second, "5")
+	require.Len(t, tw.OutputChan(), 1)
+	second := <-tw.OutputChan()
+	require.Len(t, second, 2)
+	require.True(t, second[0].Slot.Start.Equal(baseTime.Add(2*time.Second)))
+	// 手动触发不会触发水位线尚未越过的窗口
+	tw.Trigger()
+	require.Len(t, tw.OutputChan(), 0)
+}
+
+func TestTumblingWindowAllowedLateness(t *testing.T) {
+	tw, err := NewTumblingWindow(model.WindowConfig{
+		Type:               TypeTumbling,
+		Params:             map[string]interface{}{"size": "2s"},
+		TsProp:             "Ts",
+		TimeCharacteristic: model.EventTime,
+		AllowedLateness:    3 * time.Second,
+	})
+	require.NoError(t, err)
+	var late []model.Row
+	tw.SetLateDataCallback(func(row model.Row) {
+		late = append(late, row)
+	})
+	tw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	add := func(offset time.Duration, tag string) {
+		tw.Add(TestDate{Ts: baseTime.Add(offset), tag: tag})
+	}
+	add(500*time.Millisecond, "0")
+	// 水位线推进到 2s，触发窗口 [0s,2s)
+	add(2*time.Second, "1")
+	require.Len(t, tw.OutputChan(), 1)
+	require.Len(t, <-tw.OutputChan(), 1)
+
+	// 迟到数据在允许迟到时间内，窗口 [0s,2s) 重新输出更新后的数据
+	add(time.Second, "2")
+	require.Len(t, tw.OutputChan(), 1)
+	updated := <-tw.OutputChan()
+	require.Len(t, updated, 2)
+	for _, row := range updated {
+		require.Contains(t, []string{"0", "2"}, row.Data.(TestDate).tag)
+		require.True(t, row.Slot.Start.Equal(baseTime))
+	}
+
+	// 水位线推进到 5s，窗口 [0s,2s) 超过允许迟到时间，迟到数据交给迟到数据回调
+	add(5*time.Second, "3")
+	<-tw.OutputChan()
+	add(1500*time.Millisecond, "4")
+	require.Len(t, tw.OutputChan(), 0)
+	require.Len(t, late, 1)
+	require.Equal(t, "4", late[0].Data.(TestDate).tag)
+}
+
+func TestTumblingWindowFlushAndStop(t *testing.T) {
+	tw, err := NewTumblingWindow(model.WindowConfig{
+		Type:   TypeTumbling,
+		Params: map[string]interface{}{"size": "1m"},
+		TsProp: "Ts",
+	})
+	require.NoError(t, err)
+	tw.Start()
+
+	baseTime := time.Date(2025, 4, 7, 16, 46, 0, 0, time.UTC)
+	tw.Add(TestDate{Ts: baseTime, tag: "0"})
+	tw.Add(TestDate{Ts: baseTime.Add(30 * time.Second), tag: "1"})
+	tw.Add(TestDate{Ts: baseTime.Add(70 * time.Second), tag: "2"})
+
+	// 未到触发时间的窗口在 Flush 时按时间顺序输出
+	tw.Flush()
+	tw.Stop()
+	var all [][]model.Row
+	for rows := range tw.OutputChan() {
+		all = append(all, rows)
+	}
+	require.Len(t, all, 2)
+	require.Len(t, all[0], 2)
+	require.Len(t, all[1], 1)
+	require.Equal(t, baseTime.Add(time.Minute).UnixNano(), all[1][0].Slot.WindowStart())
+
+	// 没有收到数据的窗口也可以停止，重复调用 Stop 是安全的
+	empty, err := NewTumblingWindow(model.WindowConfig{Params: map[string]interface{}{"size": "1m"}})
+	require.NoError(t, err)
+	empty.Start()
+	empty.Stop()
+	empty.Stop()
+	_, ok := <-empty.OutputChan()
+	require.False(t, ok)
 }
@@ -0,0 +1,45 @@
+package window
+
+import (
+	"time"
+
+	"github.com/rulego/streamsql/model"
+)
+
+// Watermark 事件时间水位线，水位线 = 已观察到的最大事件时间 - 允许的最大乱序时间。
+// 水位线只会单调递增，表示不会再有早于该时间的数据到达。
+type Watermark struct {
+	// maxOutOfOrderness 允许的最大乱序时间
+	maxOutOfOrderness time.Duration
+	// maxEventTime 已观察到的最大事件时间
+	maxEventTime time.Time
+	// initialized 是否已观察到事件
+	initialized bool
+}
+
+// NewWatermark 创建一个新的水位线生成器。
+func NewWatermark(maxOutOfOrderness time.Duration) *Watermark {
+	return &Watermark{maxOutOfOrderness: maxOutOfOrderness}
+}
+
+// Update 根据新到达数据的事件时间推进水位线，并返回当前水位线。
+func (w *Watermark) Update(t time.Time) time.Time {
+	if !w.initialized || t.After(w.maxEventTime) {
+		w.maxEventTime = t
+		w.initialized = true
+	}
+	return w.Current()
+}
+
+// Current 返回当前水位线，尚未观察到事件时返回零值时间。
+func (w *Watermark) Current() time.Time {
+	if !w.initialized {
+		return time.Time{}
+	}
+	return w.maxEventTime.Add(-w.maxOutOfOrderness)
+}
+
+// isEventTime 判断窗口配置是否使用事件时间语义。
+func isEventTime(config model.WindowConfig) bool {
+	return config.TimeCharacteristic == model.EventTime
+}